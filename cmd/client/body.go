@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// loadBody returns a request body given inline, or read from a file when spec starts with @, e.g., @payload.json.
+// The content type is guessed from the file extension or the body itself.
+func loadBody(spec string) (body []byte, contentType string, err error) {
+	if !strings.HasPrefix(spec, "@") {
+		body = []byte(spec)
+	} else {
+		name := strings.TrimPrefix(spec, "@")
+		if body, err = ioutil.ReadFile(name); err != nil {
+			return nil, "", err
+		}
+		contentType = mime.TypeByExtension(filepath.Ext(name))
+	}
+
+	switch {
+	case contentType != "":
+	case json.Valid(body):
+		contentType = "application/json"
+	default:
+		contentType = http.DetectContentType(body)
+	}
+	return body, contentType, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLoadBody(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"payload.json": `{"cat":"🐈"}`,
+		"payload":      "cat=🐈",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := map[string]struct {
+		spec            string
+		wantBody        string
+		wantContentType string
+	}{
+		"inline json": {spec: `{"cat":"🐈"}`, wantBody: `{"cat":"🐈"}`, wantContentType: "application/json"},
+		"inline text": {spec: "cat", wantBody: "cat", wantContentType: "text/plain; charset=utf-8"},
+		"json file":   {spec: "@" + filepath.Join(dir, "payload.json"), wantBody: `{"cat":"🐈"}`, wantContentType: "application/json"},
+		"file":        {spec: "@" + filepath.Join(dir, "payload"), wantBody: "cat=🐈", wantContentType: "text/plain; charset=utf-8"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			body, contentType, err := loadBody(tc.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tc.wantBody || contentType != tc.wantContentType {
+				t.Errorf("got %q %q, want %q %q", body, contentType, tc.wantBody, tc.wantContentType)
+			}
+		})
+	}
+
+	if _, _, err := loadBody("@" + filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected error for a missing file")
+	}
+}
+
+func TestFetchBody(t *testing.T) {
+	type echo struct {
+		method      string
+		contentType string
+		body        string
+	}
+	echoed := make(chan echo, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		echoed <- echo{method: r.Method, contentType: r.Header.Get("Content-Type"), body: string(b)}
+		w.Write(b)
+	}))
+	defer srv.Close()
+
+	body, contentType, err := loadBody(`{"cat":"🐈"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{"Content-Type": {contentType}}
+	total := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "total"}, []string{"method", "status"})
+	latency := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "latency"})
+
+	// The body is shared by requests, so every one of them sends it whole.
+	want := echo{method: http.MethodPost, contentType: "application/json", body: `{"cat":"🐈"}`}
+	for i := 0; i < 2; i++ {
+		if _, err = fetch(context.Background(), srv.Client(), http.MethodPost, srv.URL, body, header, total, latency); err != nil {
+			t.Fatal(err)
+		}
+		if got := <-echoed; got != want {
+			t.Errorf("request %d: origin got %+v, want %+v", i, got, want)
+		}
+	}
+	if got := testutil.ToFloat64(total.WithLabelValues(http.MethodPost, "200")); got != 2 {
+		t.Errorf("counted %v POST responses, want 2", got)
+	}
+}
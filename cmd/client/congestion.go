@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// congestionMarks counts responses marked with X-Congestion header by the proxy.
+type congestionMarks struct {
+	marked int64
+	total  int64
+}
+
+// Observe records whether a response was marked as congested.
+func (c *congestionMarks) Observe(marked bool) {
+	atomic.AddInt64(&c.total, 1)
+	if marked {
+		atomic.AddInt64(&c.marked, 1)
+	}
+}
+
+// Flush returns how many responses were marked and how many there were since the last flush.
+func (c *congestionMarks) Flush() (marked, total int64) {
+	return atomic.SwapInt64(&c.marked, 0), atomic.SwapInt64(&c.total, 0)
+}
+
+// adaptToCongestion throttles limiter every interval when any response was marked as congested
+// and gradually restores it up to maxRPS once marks disappear.
+// The rate is backed off to 75% and increased by 10% of maxRPS.
+func adaptToCongestion(limiter *rate.Limiter, marks *congestionMarks, maxRPS float64, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		throttleOnCongestion(limiter, marks, maxRPS)
+	}
+}
+
+// throttleOnCongestion adjusts limiter once according to the marks observed since the last flush.
+// The rate isn't changed when no responses were observed.
+func throttleOnCongestion(limiter *rate.Limiter, marks *congestionMarks, maxRPS float64) {
+	marked, total := marks.Flush()
+	if total == 0 {
+		return
+	}
+
+	minRPS := maxRPS / 100
+	rps := float64(limiter.Limit())
+	if marked > 0 {
+		rps *= 0.75
+		if rps < minRPS {
+			rps = minRPS
+		}
+	} else {
+		rps += maxRPS / 10
+		if rps > maxRPS {
+			rps = maxRPS
+		}
+	}
+	if rps != float64(limiter.Limit()) {
+		fmt.Printf("%d/%d responses marked as congested, setting rate to %.2f rps\n", marked, total, rps)
+		limiter.SetLimit(rate.Limit(rps))
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/time/rate"
+)
+
+func TestThrottleOnCongestion(t *testing.T) {
+	limiter := rate.NewLimiter(100, 1)
+	var marks congestionMarks
+
+	// A single marked response is enough to back off.
+	marks.Observe(false)
+	marks.Observe(true)
+	throttleOnCongestion(limiter, &marks, 100)
+	if got := float64(limiter.Limit()); got != 75 {
+		t.Fatalf("rate %.2f rps, want 75", got)
+	}
+
+	// Nothing was observed, so the rate stays.
+	throttleOnCongestion(limiter, &marks, 100)
+	if got := float64(limiter.Limit()); got != 75 {
+		t.Fatalf("rate %.2f rps, want 75 when no responses were observed", got)
+	}
+
+	// The rate is restored gradually without exceeding the max once marks disappear.
+	want := []float64{85, 95, 100, 100}
+	for i, w := range want {
+		marks.Observe(false)
+		throttleOnCongestion(limiter, &marks, 100)
+		if got := float64(limiter.Limit()); got != w {
+			t.Fatalf("step %d: rate %.2f rps, want %.2f", i, got, w)
+		}
+	}
+
+	// Persistent congestion doesn't throttle below 1% of the max.
+	for i := 0; i < 100; i++ {
+		marks.Observe(true)
+		throttleOnCongestion(limiter, &marks, 100)
+	}
+	if got := float64(limiter.Limit()); got != 1 {
+		t.Errorf("rate %.2f rps, want 1", got)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// connTrace counts how requests got their connections, e.g., to check whether keep-alive works.
+type connTrace struct {
+	// conns counts connections partitioned by whether they were reused.
+	conns *prometheus.CounterVec
+	// handshakes counts TLS handshakes partitioned by result: full, resumed, or error.
+	handshakes *prometheus.CounterVec
+}
+
+// WithTrace returns a copy of ctx which traces a request's connection.
+func (t connTrace) WithTrace(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.conns.WithLabelValues(strconv.FormatBool(info.Reused)).Inc()
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			switch {
+			case err != nil:
+				t.handshakes.WithLabelValues("error").Inc()
+			case state.DidResume:
+				t.handshakes.WithLabelValues("resumed").Inc()
+			default:
+				t.handshakes.WithLabelValues("full").Inc()
+			}
+		},
+	})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newConnTrace() connTrace {
+	return connTrace{
+		conns:      prometheus.NewCounterVec(prometheus.CounterOpts{Name: "conns"}, []string{"reused"}),
+		handshakes: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "handshakes"}, []string{"result"}),
+	}
+}
+
+// get sends n sequential requests to url through client c traced by trace.
+func get(t *testing.T, c *http.Client, trace connTrace, url string, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		req, err := http.NewRequestWithContext(trace.WithTrace(context.Background()), http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
+
+func TestConnTrace(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	tests := map[string]struct {
+		disableKeepAlives bool
+		wantReused        float64
+		wantNew           float64
+		wantFull          float64
+		wantResumed       float64
+	}{
+		// Requests after the first one reuse its connection.
+		"keep-alive": {wantReused: 4, wantNew: 1, wantFull: 1},
+		// Every request dials, but only the first one does a full handshake.
+		"no reuse": {disableKeepAlives: true, wantNew: 5, wantFull: 1, wantResumed: 4},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			transport := srv.Client().Transport.(*http.Transport).Clone()
+			transport.DisableKeepAlives = tc.disableKeepAlives
+			transport.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+			defer transport.CloseIdleConnections()
+
+			trace := newConnTrace()
+			get(t, &http.Client{Transport: transport}, trace, srv.URL, 5)
+
+			if got := testutil.ToFloat64(trace.conns.WithLabelValues("true")); got != tc.wantReused {
+				t.Errorf("reused %v, want %v", got, tc.wantReused)
+			}
+			if got := testutil.ToFloat64(trace.conns.WithLabelValues("false")); got != tc.wantNew {
+				t.Errorf("new %v, want %v", got, tc.wantNew)
+			}
+			if got := testutil.ToFloat64(trace.handshakes.WithLabelValues("full")); got != tc.wantFull {
+				t.Errorf("full handshakes %v, want %v", got, tc.wantFull)
+			}
+			if got := testutil.ToFloat64(trace.handshakes.WithLabelValues("resumed")); got != tc.wantResumed {
+				t.Errorf("resumed handshakes %v, want %v", got, tc.wantResumed)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/marselester/capacity/internal/derive"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// derivedGauges are computed from client's raw metrics, so that a dashboard doesn't need PromQL recording rules.
+type derivedGauges struct {
+	rejectionRatio prometheus.Gauge
+	goodputRate    prometheus.Gauge
+}
+
+// newDerivedGauges creates and registers derived gauges of the client.
+func newDerivedGauges() *derivedGauges {
+	d := derivedGauges{
+		rejectionRatio: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "client_derived_rejection_ratio",
+			Help: "Fraction of requests shed with 429 or 503 since the previous derivation.",
+		}),
+		goodputRate: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "client_derived_goodput_per_second",
+			Help: "How many requests per second succeeded with 2xx since the previous derivation.",
+		}),
+	}
+	prometheus.MustRegister(d.rejectionRatio, d.goodputRate)
+	return &d
+}
+
+// Update sets the gauges based on how client_requests_total changed in elapsed time.
+func (d *derivedGauges) Update(prev, cur derive.Totals, elapsed time.Duration) {
+	total, _ := derive.Delta(prev, cur, "client_requests_total", nil)
+	shed, _ := derive.Delta(prev, cur, "client_requests_total", func(labels map[string]string) bool {
+		return labels["status"] == "429" || labels["status"] == "503"
+	})
+	ok, _ := derive.Delta(prev, cur, "client_requests_total", func(labels map[string]string) bool {
+		return strings.HasPrefix(labels["status"], "2")
+	})
+
+	d.rejectionRatio.Set(derive.Ratio(shed, total))
+	d.goodputRate.Set(derive.Ratio(ok, elapsed.Seconds()))
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// headerFlag is a repeatable flag of request headers, e.g., -header "X-Client-ID: a" -header "Accept: text/plain".
+type headerFlag http.Header
+
+// String returns the headers as a comma-separated list.
+func (h headerFlag) String() string {
+	var hh []string
+	for k, vv := range h {
+		for _, v := range vv {
+			hh = append(hh, k+": "+v)
+		}
+	}
+	return strings.Join(hh, ", ")
+}
+
+// Set adds a header from "Key: Value" string.
+func (h headerFlag) Set(s string) error {
+	kv := strings.SplitN(s, ":", 2)
+	if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+		return fmt.Errorf("header %q: want Key: Value", s)
+	}
+	http.Header(h).Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestHeaderFlag(t *testing.T) {
+	received := make(chan http.Header, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header
+	}))
+	defer srv.Close()
+
+	header := headerFlag{}
+	fs := flag.NewFlagSet("client", flag.ContinueOnError)
+	fs.Var(header, "header", "")
+	err := fs.Parse([]string{
+		"-header", "X-Api-Key: secret",
+		"-header", "Accept: text/plain",
+		"-header", "X-Tag: a",
+		"-header", "X-Tag: b:c",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	total := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "total"}, []string{"method", "status"})
+	latency := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "latency"})
+	if _, err = fetch(context.Background(), srv.Client(), http.MethodGet, srv.URL, nil, http.Header(header), total, latency); err != nil {
+		t.Fatal(err)
+	}
+	h := <-received
+	if got := h.Get("X-Api-Key"); got != "secret" {
+		t.Errorf("X-Api-Key %q, want secret", got)
+	}
+	if got := h.Get("Accept"); got != "text/plain" {
+		t.Errorf("Accept %q, want text/plain", got)
+	}
+	if got := h.Values("X-Tag"); len(got) != 2 || got[0] != "a" || got[1] != "b:c" {
+		t.Errorf("X-Tag %q, want [a b:c]", got)
+	}
+}
+
+func TestHeaderFlagError(t *testing.T) {
+	for _, s := range []string{"X-Api-Key", ": value", ""} {
+		if err := (headerFlag{}).Set(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// latencyMetric is either a histogram or a summary of request latency.
+type latencyMetric interface {
+	prometheus.Collector
+	prometheus.Observer
+}
+
+// newLatencyMetric creates a request latency metric of the given kind: histogram or summary.
+func newLatencyMetric(kind string) (latencyMetric, error) {
+	switch kind {
+	case "histogram":
+		return prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "client_request_duration_seconds",
+			Help:    "Total duration of HTTP requests in seconds. Buckets can be aggregated across clients, though quantiles are only as precise as the buckets.",
+			Buckets: []float64{0.95, 1, 1.05, 1.1, 1.5, 1.95, 2, 2.05, 2.1, 2.5},
+		}), nil
+	case "summary":
+		return prometheus.NewSummary(prometheus.SummaryOpts{
+			Name:       "client_request_duration_seconds",
+			Help:       "Total duration of HTTP requests in seconds. Quantiles over the last 10 minutes are precise, though they can't be aggregated across clients.",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		}), nil
+	}
+	return nil, fmt.Errorf("unknown latency metric %q: want histogram or summary", kind)
+}
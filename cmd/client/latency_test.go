@@ -0,0 +1,72 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestLatencyMetricSummary(t *testing.T) {
+	m, err := newLatencyMetric("summary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Latencies are 1s, 1.01s, ..., 1.99s.
+	for i := 0; i < 100; i++ {
+		m.Observe(1 + float64(i)/100)
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(m)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mfs) != 1 || mfs[0].GetName() != "client_request_duration_seconds" {
+		t.Fatalf("got %v, want client_request_duration_seconds", mfs)
+	}
+	s := mfs[0].GetMetric()[0].GetSummary()
+	if s == nil {
+		t.Fatal("latency isn't a summary")
+	}
+	if s.GetSampleCount() != 100 {
+		t.Errorf("count %d, want 100", s.GetSampleCount())
+	}
+
+	want := map[float64]float64{0.5: 1.5, 0.9: 1.9, 0.99: 1.99}
+	if len(s.GetQuantile()) != len(want) {
+		t.Fatalf("got %d quantiles, want %d", len(s.GetQuantile()), len(want))
+	}
+	for _, q := range s.GetQuantile() {
+		// The quantile's rank can be off by its objective's error, e.g., p50 is within p45..p55.
+		if w, ok := want[q.GetQuantile()]; !ok || math.Abs(q.GetValue()-w) > 0.05 {
+			t.Errorf("p%v is %v, want %v", q.GetQuantile()*100, q.GetValue(), w)
+		}
+	}
+}
+
+func TestLatencyMetricHistogram(t *testing.T) {
+	m, err := newLatencyMetric("histogram")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Observe(1.2)
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(m)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := mfs[0].GetMetric()[0].GetHistogram()
+	if h == nil || h.GetSampleCount() != 1 {
+		t.Fatalf("got %v, want a histogram with one sample", mfs[0])
+	}
+}
+
+func TestLatencyMetricError(t *testing.T) {
+	if _, err := newLatencyMetric("gauge"); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -1,100 +1,360 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"flag"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
-	"sync"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/marselester/capacity/internal/derive"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"golang.org/x/time/rate"
 )
 
 func main() {
-	originAddr := flag.String("origin", "http://localhost:8000", "origin address where to send requests")
+	originAddr := flag.String("origin", "http://localhost:8000", "origin address where to send requests, or comma-separated url=weight targets picked at random in proportion to their weights, e.g., http://o/a=3,http://o/b=1")
 	addr := flag.String("addr", ":8080", "address to expose metrics at")
 	workerNum := flag.Int("worker", 10, "number of workers to generate load")
 	rps := flag.Float64("rps", 5, "requests allowed to send per second")
+	scheduleSpec := flag.String("schedule", "", "requests per second over time as offset:rps phases, e.g., 0:1,30s:10,60s:50; it overrides -rps")
+	latencyMetric := flag.String("latency-metric", "histogram", "type of request latency metric: histogram (aggregatable buckets) or summary (precise p50, p90, p99 quantiles of a single client)")
+	loop := flag.String("loop", "closed", "how load is generated: closed (workers wait for a response before the next request) or open (Poisson arrivals at -rps regardless of response times)")
+	maxInflight := flag.Int("max-inflight", 1000, "how many requests can be in flight with -loop open, arrivals beyond that are dropped")
 	timeout := flag.Duration("timeout", 2500*time.Millisecond, "how long to wait for a response")
+	workerProfileSpec := flag.String("worker-profile", "", "number of workers over time as offset:workers phases, e.g., 0:10,30s:20,1m:5; it overrides -worker")
+	clientSLO := flag.Duration("client-slo", 0, "p99 latency above which the client throttles its rate, 0 means the rate is fixed")
+	honorCongestion := flag.Bool("honor-congestion", false, "throttle the rate when responses are marked with X-Congestion: high header")
+	pushgatewayURL := flag.String("pushgateway-url", "", "Prometheus Pushgateway URL where to push metrics on exit, e.g., http://localhost:9091")
+	job := flag.String("job", "client", "job label of metrics pushed to Pushgateway")
+	maxIdleConnsPerHost := flag.Int("max-idle-conns-per-host", http.DefaultMaxIdleConnsPerHost, "how many idle connections to keep per host for reuse")
+	maxConnsPerHost := flag.Int("max-conns-per-host", 0, "how many connections can be open per host, requests over the limit wait for a connection, 0 means no limit")
+	noReuse := flag.Bool("no-reuse", false, "open a fresh connection for every request by disabling keep-alives")
+	tlsSessionCache := flag.Int("tls-session-cache", 0, "how many TLS sessions to cache so that new connections resume them instead of a full handshake, 0 disables the cache")
+	replayFile := flag.String("replay", "", "access log in Common Log Format whose request paths are replayed to -origin as GET requests with the original timing; it overrides -worker and -rps")
+	replaySpeed := flag.Float64("replay-speed", 1, "how many times faster an access log is replayed, e.g., 2 halves intervals between requests")
+	deriveInterval := flag.Duration("derive-interval", 0, "how often rejection ratio and goodput gauges are derived from raw metrics, 0 disables them")
+	assertP99 := flag.Duration("assert-p99", 0, "exit with code 1 if p99 latency of the run exceeds this, 0 means no assertion")
+	assertErrorRate := flag.Float64("assert-error-rate", -1, "exit with code 1 if the fraction of non-2xx responses of the run exceeds this, negative means no assertion")
+	duration := flag.Duration("duration", 0, "how long to generate load before printing a summary and exiting, 0 means until SIGINT/SIGTERM")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 5*time.Second, "how long to wait for the metrics server to close on exit")
+	method := flag.String("method", http.MethodGet, "HTTP method of requests, e.g., POST to generate write load")
+	bodySpec := flag.String("body", "", "request body given inline, or read from a file with @ prefix, e.g., @payload.json; Content-Type is guessed unless it's set with -header")
+	header := headerFlag{}
+	flag.Var(header, "header", "request header as Key: Value, it can be repeated")
 	flag.Parse()
 
+	if *loop != "closed" && *loop != "open" {
+		log.Fatalf("client: unknown loop %q: want closed or open", *loop)
+	}
+	if *maxInflight <= 0 {
+		log.Fatalf("client: max in-flight requests must be positive: %d", *maxInflight)
+	}
+	if *replaySpeed <= 0 {
+		log.Fatalf("client: replay speed must be positive: %v", *replaySpeed)
+	}
+	targets, err := parseTargets(*originAddr)
+	if err != nil {
+		log.Fatalf("client: %v", err)
+	}
+
+	var body []byte
+	// requestHeader are headers of requests to -origin, they describe the request body if there is one.
+	requestHeader := http.Header(header).Clone()
+	if *bodySpec != "" {
+		var (
+			contentType string
+			err         error
+		)
+		if body, contentType, err = loadBody(*bodySpec); err != nil {
+			log.Fatalf("client: %v", err)
+		}
+		if requestHeader.Get("Content-Type") == "" {
+			requestHeader.Set("Content-Type", contentType)
+		}
+	}
+
+	var replayEntries []replayEntry
+	if *replayFile != "" {
+		if targets.Len() > 1 {
+			log.Fatalf("client: replay needs a single -origin")
+		}
+		f, err := os.Open(*replayFile)
+		if err != nil {
+			log.Fatalf("client: %v", err)
+		}
+		replayEntries, err = parseAccessLog(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("client: %v", err)
+		}
+	}
+
+	var schedule []phase
+	if *scheduleSpec != "" {
+		var err error
+		if schedule, err = parseProfile(*scheduleSpec); err != nil {
+			log.Fatalf("client: %v", err)
+		}
+		for _, p := range schedule {
+			if p.value <= 0 {
+				log.Fatalf("client: schedule phase %v:%v: rps must be positive", p.offset, p.value)
+			}
+		}
+		*rps = schedule[0].value
+	}
+
+	workerProfile := []phase{{value: float64(*workerNum)}}
+	if *workerProfileSpec != "" {
+		var err error
+		if workerProfile, err = parseProfile(*workerProfileSpec); err != nil {
+			log.Fatalf("client: %v", err)
+		}
+	}
+
 	requestTotal := prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "client_requests_total",
-			Help: "How many HTTP requests processed, partitioned by status code.",
+			Help: "How many HTTP requests processed, partitioned by endpoint (-origin target), method and status code.",
 		},
-		[]string{"status"},
+		[]string{"endpoint", "method", "status"},
 	)
-	requestLatency := prometheus.NewHistogram(prometheus.HistogramOpts{
-		Name:    "client_request_duration_seconds",
-		Help:    "Total duration of HTTP requests in seconds.",
-		Buckets: []float64{0.95, 1, 1.05, 1.1, 1.5, 1.95, 2, 2.05, 2.1, 2.5},
+	requestLatency, err := newLatencyMetric(*latencyMetric)
+	if err != nil {
+		log.Fatalf("client: %v", err)
+	}
+	activeWorkers := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "client_active_workers",
+		Help: "How many workers are generating load.",
 	})
+	workerRequests := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "client_worker_requests_total",
+			Help: "How many HTTP requests a closed-loop worker sent, partitioned by worker and result: ok or error. Worker IDs are reused when workers are stopped, so there are at most as many workers as their peak number.",
+		},
+		[]string{"worker", "result"},
+	)
 	prometheus.MustRegister(requestLatency)
+	prometheus.MustRegister(activeWorkers)
+	prometheus.MustRegister(workerRequests)
 	prometheus.MustRegister(requestTotal)
+	trace := connTrace{
+		conns: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "client_connections_total",
+				Help: "How many connections requests got, partitioned by whether a connection was reused.",
+			},
+			[]string{"reused"},
+		),
+		handshakes: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "client_tls_handshakes_total",
+				Help: "How many TLS handshakes were done, partitioned by result: full, resumed, or error.",
+			},
+			[]string{"result"},
+		),
+	}
+	droppedArrivals := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "client_dropped_arrivals_total",
+		Help: "How many open-loop arrivals weren't sent because -max-inflight requests were in flight.",
+	})
+	prometheus.MustRegister(droppedArrivals)
+	prometheus.MustRegister(trace.conns)
+	prometheus.MustRegister(trace.handshakes)
+	client := &http.Client{Transport: newTransport(*maxIdleConnsPerHost, *maxConnsPerHost, *noReuse, *tlsSessionCache)}
+	var pause pauseSwitch
 	http.Handle("/metrics", promhttp.Handler())
-	go http.ListenAndServe(*addr, nil)
+	if *deriveInterval > 0 {
+		go derive.Run(time.NewTicker(*deriveInterval).C, prometheus.DefaultGatherer, newDerivedGauges().Update, func(err error) {
+			log.Printf("client: failed to gather metrics: %v", err)
+		})
+	}
+	http.Handle("/admin/pause", pauseHandler(&pause, true))
+	http.Handle("/admin/resume", pauseHandler(&pause, false))
+	srv := http.Server{Addr: *addr}
+	go srv.ListenAndServe()
 
 	// limiter throttles requests that exceeded rps requests per second.
 	limiter := rate.NewLimiter(rate.Limit(*rps), int(*rps))
 
-	ctx := context.Background()
+	samples := newLatencySamples(maxLatencySamples)
+	if *clientSLO > 0 {
+		go adaptRate(limiter, samples, *clientSLO, *rps, time.Second)
+	}
+	marks := congestionMarks{}
+	if *honorCongestion {
+		go adaptToCongestion(limiter, &marks, *rps, time.Second)
+	}
+
+	// Workers are stopped on SIGINT/SIGTERM so the final metrics can be pushed.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+	summary := newReport()
 
-	fmt.Printf("starting %d workers\n", *workerNum)
-	var wg sync.WaitGroup
-	for i := 0; i < *workerNum; i++ {
-		wg.Add(1)
-		go func(workerID int) {
-			defer wg.Done()
+	if schedule != nil {
+		go runProfile(ctx, schedule, func(rps float64) {
+			fmt.Printf("scheduled %v requests per second\n", rps)
+			setRate(limiter, rps)
+		})
+	}
 
+	// send makes a request to origin and feeds the rate adaptation.
+	send := func() error {
+		// The request isn't cancelled when a worker is stopped,
+		// so it doesn't show up as an error.
+		reqCtx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		reqCtx = trace.WithTrace(reqCtx)
+		begun := time.Now()
+		target := targets.Pick()
+		total := requestTotal.MustCurryWith(prometheus.Labels{"endpoint": target})
+		congested, err := fetch(reqCtx, client, *method, target, body, requestHeader, total, requestLatency)
+		summary.Observe(time.Since(begun))
+		if *clientSLO > 0 {
+			samples.Observe(time.Since(begun))
+		}
+		if *honorCongestion && err == nil {
+			marks.Observe(congested)
+		}
+		return err
+	}
+	pool := workerPool{
+		work: func(ctx context.Context, workerID int) {
 			for {
+				if err := pause.Wait(ctx); err != nil {
+					return
+				}
 				if err := limiter.Wait(ctx); err != nil {
 					if ctx.Err() != nil {
 						return
 					}
 				}
 
-				ctx, cancel := context.WithTimeout(ctx, *timeout)
-				err := fetch(ctx, *originAddr, requestTotal, requestLatency)
-				cancel()
-				if err != nil {
+				if err := send(); err != nil {
+					workerRequests.WithLabelValues(fmt.Sprint(workerID), "error").Inc()
 					fmt.Printf("worker #%d: %v\n", workerID, err)
 					continue
 				}
+				workerRequests.WithLabelValues(fmt.Sprint(workerID), "ok").Inc()
 				fmt.Printf("worker #%d: ok\n", workerID)
 			}
-		}(i)
+		},
+		active: activeWorkers,
+	}
+	if replayEntries != nil {
+		fmt.Printf("replaying %d requests\n", len(replayEntries))
+		replay(ctx, replayEntries, *replaySpeed, func(path string) {
+			reqCtx, cancel := context.WithTimeout(context.Background(), *timeout)
+			defer cancel()
+			reqCtx = trace.WithTrace(reqCtx)
+			total := requestTotal.MustCurryWith(prometheus.Labels{"endpoint": *originAddr})
+			begun := time.Now()
+			_, err := fetch(reqCtx, client, http.MethodGet, strings.TrimSuffix(*originAddr, "/")+path, nil, http.Header(header), total, requestLatency)
+			summary.Observe(time.Since(begun))
+			if err != nil {
+				fmt.Printf("replay %s: %v\n", path, err)
+				return
+			}
+			fmt.Printf("replay %s: ok\n", path)
+		})
+	} else if *loop == "open" {
+		fmt.Printf("sending %v requests per second\n", *rps)
+		// The rate follows the limiter, so that -client-slo and -honor-congestion still throttle it.
+		openLoop(ctx, func() float64 { return float64(limiter.Limit()) }, *maxInflight, droppedArrivals, func() {
+			if err := pause.Wait(ctx); err != nil {
+				return
+			}
+			if err := send(); err != nil {
+				fmt.Printf("arrival: %v\n", err)
+				return
+			}
+			fmt.Println("arrival: ok")
+		})
+	} else {
+		runProfile(ctx, workerProfile, func(n float64) {
+			fmt.Printf("running %d workers\n", int(n))
+			pool.Resize(ctx, int(n))
+		})
+		pool.Wait()
+	}
+
+	fmt.Println("summary:")
+	if err := summary.Print(os.Stdout, prometheus.DefaultGatherer); err != nil {
+		log.Printf("client: failed to summarize the run: %v", err)
+	}
+	failed, err := summary.Assert(prometheus.DefaultGatherer, *assertP99, *assertErrorRate)
+	if err != nil {
+		log.Fatalf("client: failed to check assertions: %v", err)
+	}
+	for _, f := range failed {
+		fmt.Printf("assertion failed: %s\n", f)
+	}
+
+	if *pushgatewayURL != "" {
+		if err := pushMetrics(*pushgatewayURL, *job, prometheus.DefaultGatherer); err != nil {
+			log.Fatalf("client: failed to push metrics: %v", err)
+		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Fatalf("client: %v", err)
+	}
+	if len(failed) > 0 {
+		os.Exit(1)
 	}
-	wg.Wait()
 }
 
-func fetch(ctx context.Context, addr string, total *prometheus.CounterVec, latency prometheus.Histogram) error {
+// fetch sends a request with the given method, body and header to addr using client c
+// and reports whether the response was marked as congested.
+// The body can be nil, otherwise it's shared by concurrent requests, so it must not be modified.
+func fetch(ctx context.Context, c *http.Client, method, addr string, body []byte, header http.Header, total *prometheus.CounterVec, latency prometheus.Observer) (bool, error) {
 	var status int
 
 	defer func(begun time.Time) {
 		latency.Observe(time.Since(begun).Seconds())
 		total.With(prometheus.Labels{
+			"method": method,
 			"status": fmt.Sprint(status),
 		}).Inc()
 	}(time.Now())
 
-	req, err := http.NewRequest(http.MethodGet, addr, nil)
+	// Every request reads the body with its own reader.
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, addr, r)
 	if err != nil {
 		status = http.StatusBadGateway
-		return err
+		return false, err
 	}
 	req = req.WithContext(ctx)
+	for k, vv := range header {
+		req.Header[k] = vv
+	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.Do(req)
 	if err != nil {
 		status = http.StatusBadGateway
-		return err
+		return false, err
 	}
 	resp.Body.Close()
 
 	status = resp.StatusCode
-	return nil
+	return resp.Header.Get("X-Congestion") == "high", nil
 }
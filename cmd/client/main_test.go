@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAssertExitCode(t *testing.T) {
+	if testing.Short() {
+		t.Skip("client binary is built and run")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command isn't found")
+	}
+	bin := filepath.Join(t.TempDir(), "client")
+	if out, err := exec.Command(goBin, "build", "-o", bin, ".").CombinedOutput(); err != nil {
+		t.Fatalf("failed to build client: %v\n%s", err, out)
+	}
+
+	// The stub is quick, except /slow takes 100ms, and /fail responds with 503.
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			time.Sleep(100 * time.Millisecond)
+		case "/fail":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer stub.Close()
+
+	tests := map[string]struct {
+		path       string
+		assertions []string
+		wantCode   int
+		wantFailed string
+	}{
+		"pass":          {path: "/", assertions: []string{"-assert-p99", "1s", "-assert-error-rate", "0.01"}},
+		"p99":           {path: "/slow", assertions: []string{"-assert-p99", "50ms"}, wantCode: 1, wantFailed: "assertion failed: p99 latency"},
+		"error rate":    {path: "/fail", assertions: []string{"-assert-error-rate", "0.01"}, wantCode: 1, wantFailed: "assertion failed: error rate 1.0000 exceeds 0.01"},
+		"no assertions": {path: "/fail"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			args := append([]string{"-origin", stub.URL + tc.path, "-addr", "127.0.0.1:0", "-worker", "2", "-rps", "50", "-duration", "300ms"}, tc.assertions...)
+			out, err := exec.Command(bin, args...).CombinedOutput()
+			code := 0
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				code = exitErr.ExitCode()
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if code != tc.wantCode {
+				t.Errorf("exit code %d, want %d\n%s", code, tc.wantCode, out)
+			}
+			if tc.wantFailed != "" && !strings.Contains(string(out), tc.wantFailed) {
+				t.Errorf("output doesn't tell %q failed\n%s", tc.wantFailed, out)
+			}
+			if tc.wantFailed == "" && strings.Contains(string(out), "assertion failed") {
+				t.Errorf("unexpected failed assertion\n%s", out)
+			}
+		})
+	}
+}
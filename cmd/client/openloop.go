@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// openLoop calls send as Poisson arrivals at rps() requests per second regardless of response times,
+// so that a slow origin doesn't hold off the next requests (coordinated omission).
+// At most maxInflight sends run concurrently, arrivals beyond that are dropped and counted.
+// It returns once ctx is cancelled and all sends are done.
+func openLoop(ctx context.Context, rps func() float64, maxInflight int, dropped prometheus.Counter, send func()) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	inflight := make(chan struct{}, maxInflight)
+	// Arrivals are scheduled from the previous one, not from when it was sent,
+	// so that timer delays don't accumulate and lower the rate.
+	next := time.Now()
+	for {
+		next = next.Add(time.Duration(rnd.ExpFloat64() / rps() * float64(time.Second)))
+		t := time.NewTimer(time.Until(next))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+
+		select {
+		case inflight <- struct{}{}:
+		default:
+			dropped.Inc()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			send()
+			<-inflight
+			wg.Done()
+		}()
+	}
+}
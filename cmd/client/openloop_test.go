@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestOpenLoopRate(t *testing.T) {
+	const (
+		rps      = 500
+		duration = time.Second
+	)
+	dropped := prometheus.NewCounter(prometheus.CounterOpts{Name: "dropped"})
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	defer cancel()
+
+	// Origin takes 250 times longer to respond than the mean gap between arrivals.
+	var sent int64
+	openLoop(ctx, func() float64 { return rps }, 10000, dropped, func() {
+		atomic.AddInt64(&sent, 1)
+		time.Sleep(duration / 2)
+	})
+
+	// Poisson arrivals within a second have standard deviation of sqrt(500) ≈ 22.
+	want := rps * duration.Seconds()
+	if got := float64(atomic.LoadInt64(&sent)); math.Abs(got-want) > 0.15*want {
+		t.Errorf("sent %v requests, want about %v", got, want)
+	}
+	if got := testutil.ToFloat64(dropped); got != 0 {
+		t.Errorf("dropped %v arrivals, want 0", got)
+	}
+}
+
+func TestOpenLoopMaxInflight(t *testing.T) {
+	dropped := prometheus.NewCounter(prometheus.CounterOpts{Name: "dropped"})
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	var inflight, peak int64
+	release := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		close(release)
+	}()
+	openLoop(ctx, func() float64 { return 1000 }, 5, dropped, func() {
+		n := atomic.AddInt64(&inflight, 1)
+		for {
+			p := atomic.LoadInt64(&peak)
+			if n <= p || atomic.CompareAndSwapInt64(&peak, p, n) {
+				break
+			}
+		}
+		<-release
+		atomic.AddInt64(&inflight, -1)
+	})
+
+	if peak != 5 {
+		t.Errorf("%d requests were in flight at peak, want 5", peak)
+	}
+	if got := testutil.ToFloat64(dropped); got < 100 {
+		t.Errorf("dropped %v arrivals, want about 195", got)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// pauseSwitch pauses and resumes load generation without stopping workers.
+type pauseSwitch struct {
+	// paused is set to 1 when workers shouldn't send requests.
+	paused int32
+}
+
+// Set pauses (true) or resumes (false) load generation.
+func (p *pauseSwitch) Set(paused bool) {
+	var v int32
+	if paused {
+		v = 1
+	}
+	atomic.StoreInt32(&p.paused, v)
+}
+
+// Wait blocks while load generation is paused.
+// It returns ctx.Err() if ctx is cancelled while waiting.
+func (p *pauseSwitch) Wait(ctx context.Context) error {
+	for atomic.LoadInt32(&p.paused) == 1 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	return nil
+}
+
+// pauseHandler pauses (paused is true) or resumes load generation.
+func pauseHandler(p *pauseSwitch, paused bool) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			rw.Header().Set("Allow", http.MethodPost)
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		p.Set(paused)
+		if paused {
+			fmt.Println("paused")
+		} else {
+			fmt.Println("resumed")
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPauseSwitch(t *testing.T) {
+	var sent int64
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&sent, 1)
+	}))
+	defer origin.Close()
+
+	var pause pauseSwitch
+	mux := http.NewServeMux()
+	mux.Handle("/admin/pause", pauseHandler(&pause, true))
+	mux.Handle("/admin/resume", pauseHandler(&pause, false))
+	admin := httptest.NewServer(mux)
+	defer admin.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for pause.Wait(ctx) == nil {
+			resp, err := origin.Client().Get(origin.URL)
+			if err != nil {
+				return
+			}
+			resp.Body.Close()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	// post toggles the pause via the admin endpoint.
+	post := func(path string) {
+		t.Helper()
+		resp, err := http.Post(admin.URL+path, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("%s: status %d, want %d", path, resp.StatusCode, http.StatusNoContent)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	post("/admin/pause")
+	// A request which was in-flight when the pause began can still arrive.
+	time.Sleep(50 * time.Millisecond)
+	paused := atomic.LoadInt64(&sent)
+	if paused == 0 {
+		t.Fatal("no requests were sent before the pause")
+	}
+	time.Sleep(200 * time.Millisecond)
+	if n := atomic.LoadInt64(&sent); n != paused {
+		t.Fatalf("%d requests were sent while paused", n-paused)
+	}
+
+	post("/admin/resume")
+	time.Sleep(200 * time.Millisecond)
+	if n := atomic.LoadInt64(&sent); n == paused {
+		t.Error("no requests were sent after resume")
+	}
+
+	// The pause can't be toggled with GET.
+	resp, err := http.Get(admin.URL + "/admin/pause")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET status %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+
+	// A paused worker stops once its context is cancelled.
+	post("/admin/pause")
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("paused worker didn't stop")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// phase is a step of a load profile which starts at offset since the beginning of a test.
+type phase struct {
+	offset time.Duration
+	value  float64
+}
+
+// parseProfile parses a comma-separated list of offset:value phases, e.g., 0:10,30s:20,1m:5.
+// Phases must be listed in order of their offsets.
+func parseProfile(spec string) ([]phase, error) {
+	var pp []phase
+	for _, s := range strings.Split(spec, ",") {
+		kv := strings.Split(strings.TrimSpace(s), ":")
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("profile phase %q: want offset:value", s)
+		}
+
+		var (
+			p   phase
+			err error
+		)
+		if kv[0] != "0" {
+			if p.offset, err = time.ParseDuration(kv[0]); err != nil {
+				return nil, fmt.Errorf("profile phase %q: invalid offset: %w", s, err)
+			}
+		}
+		if p.value, err = strconv.ParseFloat(kv[1], 64); err != nil || p.value < 0 {
+			return nil, fmt.Errorf("profile phase %q: invalid value", s)
+		}
+		if len(pp) > 0 && p.offset <= pp[len(pp)-1].offset {
+			return nil, fmt.Errorf("profile phase %q: offset must be greater than previous one", s)
+		}
+
+		pp = append(pp, p)
+	}
+	return pp, nil
+}
+
+// runProfile calls apply with a phase value when the phase begins.
+// It returns once the last phase has begun or ctx is cancelled.
+func runProfile(ctx context.Context, pp []phase, apply func(value float64)) {
+	begun := time.Now()
+	for _, p := range pp {
+		t := time.NewTimer(p.offset - time.Since(begun))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+		apply(p.value)
+	}
+}
+
+// setRate lets limiter l allow rps requests per second with a burst of up to a second's worth of requests.
+func setRate(l *rate.Limiter, rps float64) {
+	l.SetLimit(rate.Limit(rps))
+	l.SetBurst(int(math.Ceil(rps)))
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestParseProfile(t *testing.T) {
+	pp, err := parseProfile("0:1, 30s:10,1m:50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []phase{
+		{offset: 0, value: 1},
+		{offset: 30 * time.Second, value: 10},
+		{offset: time.Minute, value: 50},
+	}
+	if len(pp) != len(want) {
+		t.Fatalf("got %v, want %v", pp, want)
+	}
+	for i := range want {
+		if pp[i] != want[i] {
+			t.Errorf("phase %d is %v, want %v", i, pp[i], want[i])
+		}
+	}
+}
+
+func TestScheduleSetsRate(t *testing.T) {
+	schedule, err := parseProfile("0:1,50ms:10,100ms:2.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter := rate.NewLimiter(rate.Limit(schedule[0].value), 1)
+
+	type change struct {
+		at    time.Duration
+		limit rate.Limit
+		burst int
+	}
+	var changes []change
+	begun := time.Now()
+	runProfile(context.Background(), schedule, func(rps float64) {
+		setRate(limiter, rps)
+		changes = append(changes, change{at: time.Since(begun), limit: limiter.Limit(), burst: limiter.Burst()})
+	})
+
+	want := []change{
+		{at: 0, limit: 1, burst: 1},
+		{at: 50 * time.Millisecond, limit: 10, burst: 10},
+		{at: 100 * time.Millisecond, limit: 2.5, burst: 3},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("got %v, want %v", changes, want)
+	}
+	for i, w := range want {
+		c := changes[i]
+		if c.limit != w.limit || c.burst != w.burst {
+			t.Errorf("phase %d: limit %v burst %d, want %v burst %d", i, c.limit, c.burst, w.limit, w.burst)
+		}
+		if c.at < w.at || c.at > w.at+30*time.Millisecond {
+			t.Errorf("phase %d began at %v, want %v", i, c.at, w.at)
+		}
+	}
+}
+
+func TestRunProfileCancel(t *testing.T) {
+	schedule := []phase{{value: 1}, {offset: time.Hour, value: 2}}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	var applied []float64
+	runProfile(ctx, schedule, func(v float64) {
+		applied = append(applied, v)
+	})
+	if len(applied) != 1 {
+		t.Errorf("applied %v, want only the first phase", applied)
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// pushMetrics pushes metrics gathered from g to Pushgateway at url under the job label,
+// so that results of a short-lived run outlive it.
+func pushMetrics(url, job string, g prometheus.Gatherer) error {
+	return push.New(url, job).
+		Gatherer(g).
+		Push()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestPushMetrics(t *testing.T) {
+	type push struct {
+		method string
+		path   string
+		body   string
+	}
+	pushes := make(chan push, 1)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		pushes <- push{method: r.Method, path: r.URL.Path, body: string(b)}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer gateway.Close()
+
+	reg := prometheus.NewRegistry()
+	requests := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "client_requests_total",
+		Help: "How many requests were sent.",
+	})
+	reg.MustRegister(requests)
+	requests.Add(3)
+
+	if err := pushMetrics(gateway.URL, "benchmark", reg); err != nil {
+		t.Fatal(err)
+	}
+	p := <-pushes
+	if p.method != http.MethodPut {
+		t.Errorf("method %s, want %s", p.method, http.MethodPut)
+	}
+	if want := "/metrics/job/benchmark"; p.path != want {
+		t.Errorf("path %s, want %s", p.path, want)
+	}
+	// Metrics are pushed in protobuf format, so the name is looked up verbatim.
+	if !strings.Contains(p.body, "client_requests_total") {
+		t.Error("pushed metrics don't contain client_requests_total")
+	}
+}
+
+func TestPushMetricsError(t *testing.T) {
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer gateway.Close()
+
+	if err := pushMetrics(gateway.URL, "benchmark", prometheus.NewRegistry()); err == nil {
+		t.Error("expected an error when Pushgateway fails")
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clfTimeLayout is a timestamp layout of Common Log Format, e.g., 10/Oct/2000:13:55:36 -0700.
+const clfTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// replayEntry is a request from an access log which is sent at offset since the beginning of a replay.
+type replayEntry struct {
+	offset time.Duration
+	path   string
+}
+
+// parseAccessLog parses an access log in Common Log Format, e.g.,
+// 127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326.
+// Offsets of entries are relative to the first one, entries must be in order of their timestamps.
+func parseAccessLog(r io.Reader) ([]replayEntry, error) {
+	var (
+		ee    []replayEntry
+		first time.Time
+	)
+	s := bufio.NewScanner(r)
+	for lineNo := 1; s.Scan(); lineNo++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+
+		tsStart := strings.IndexByte(line, '[')
+		tsEnd := strings.IndexByte(line, ']')
+		if tsStart < 0 || tsEnd < tsStart {
+			return nil, fmt.Errorf("access log line %d: timestamp not found", lineNo)
+		}
+		ts, err := time.Parse(clfTimeLayout, line[tsStart+1:tsEnd])
+		if err != nil {
+			return nil, fmt.Errorf("access log line %d: invalid timestamp: %w", lineNo, err)
+		}
+
+		// The request line is quoted, e.g., "GET /apache_pb.gif HTTP/1.0".
+		rest := line[tsEnd+1:]
+		reqStart := strings.IndexByte(rest, '"')
+		if reqStart < 0 {
+			return nil, fmt.Errorf("access log line %d: request line not found", lineNo)
+		}
+		rest = rest[reqStart+1:]
+		reqEnd := strings.IndexByte(rest, '"')
+		if reqEnd < 0 {
+			return nil, fmt.Errorf("access log line %d: request line not found", lineNo)
+		}
+		fields := strings.Fields(rest[:reqEnd])
+		if len(fields) < 2 || !strings.HasPrefix(fields[1], "/") {
+			return nil, fmt.Errorf("access log line %d: invalid request line", lineNo)
+		}
+
+		if len(ee) == 0 {
+			first = ts
+		}
+		e := replayEntry{
+			offset: ts.Sub(first),
+			path:   fields[1],
+		}
+		if len(ee) > 0 && e.offset < ee[len(ee)-1].offset {
+			return nil, fmt.Errorf("access log line %d: timestamp is earlier than previous one", lineNo)
+		}
+		ee = append(ee, e)
+	}
+	return ee, s.Err()
+}
+
+// replay calls send for every entry at its offset divided by speed, e.g., speed 2 replays a log twice as fast.
+// Requests are sent concurrently, so a slow response doesn't delay the next one.
+// It returns once all requests are done or ctx is cancelled.
+func replay(ctx context.Context, ee []replayEntry, speed float64, send func(path string)) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	begun := time.Now()
+	for _, e := range ee {
+		offset := time.Duration(float64(e.offset) / speed)
+		t := time.NewTimer(offset - time.Since(begun))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+
+		wg.Add(1)
+		go func(path string) {
+			send(path)
+			wg.Done()
+		}(e.path)
+	}
+}
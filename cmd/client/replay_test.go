@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const accessLog = `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /a HTTP/1.0" 200 2326
+127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /b?x=1 HTTP/1.1" 200 10
+
+10.0.0.1 - - [10/Oct/2000:13:55:37 -0700] "POST /c HTTP/1.1" 201 0
+10.0.0.1 - - [10/Oct/2000:13:55:39 -0700] "GET /d HTTP/1.1" 503 0
+`
+
+func TestParseAccessLog(t *testing.T) {
+	ee, err := parseAccessLog(strings.NewReader(accessLog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []replayEntry{
+		{offset: 0, path: "/a"},
+		{offset: 0, path: "/b?x=1"},
+		{offset: time.Second, path: "/c"},
+		{offset: 3 * time.Second, path: "/d"},
+	}
+	if len(ee) != len(want) {
+		t.Fatalf("got %v, want %v", ee, want)
+	}
+	for i := range want {
+		if ee[i] != want[i] {
+			t.Errorf("entry %d is %v, want %v", i, ee[i], want[i])
+		}
+	}
+}
+
+func TestParseAccessLogError(t *testing.T) {
+	tests := map[string]string{
+		"no timestamp":      `127.0.0.1 - - "GET / HTTP/1.1" 200 0`,
+		"invalid timestamp": `127.0.0.1 - - [10/Oct/2000] "GET / HTTP/1.1" 200 0`,
+		"no request line":   `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] 200 0`,
+		"unquoted":          `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.1 200 0`,
+		"no path":           `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "-" 400 0`,
+		"out of order": `127.0.0.1 - - [10/Oct/2000:13:55:37 -0700] "GET /a HTTP/1.1" 200 0
+127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /b HTTP/1.1" 200 0`,
+	}
+	for name, log := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseAccessLog(strings.NewReader(log)); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestReplayTiming(t *testing.T) {
+	ee, err := parseAccessLog(strings.NewReader(accessLog))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The log spans 3s, it's replayed 20 times faster, so requests are sent at 0, 0, 50ms and 150ms.
+	var (
+		mu    sync.Mutex
+		sent  = make(map[string]time.Duration)
+		begun = time.Now()
+	)
+	replay(context.Background(), ee, 20, func(path string) {
+		mu.Lock()
+		sent[path] = time.Since(begun)
+		mu.Unlock()
+	})
+
+	want := map[string]time.Duration{
+		"/a":     0,
+		"/b?x=1": 0,
+		"/c":     50 * time.Millisecond,
+		"/d":     150 * time.Millisecond,
+	}
+	if len(sent) != len(want) {
+		t.Fatalf("sent %v, want %v", sent, want)
+	}
+	const slack = 30 * time.Millisecond
+	for path, at := range want {
+		if got := sent[path]; got < at || got > at+slack {
+			t.Errorf("%s was sent at %v, want %v", path, got, at)
+		}
+	}
+}
+
+func TestReplayCancel(t *testing.T) {
+	ee := []replayEntry{
+		{offset: 0, path: "/a"},
+		{offset: time.Hour, path: "/b"},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	var sent []string
+	done := make(chan struct{})
+	go func() {
+		replay(ctx, ee, 1, func(path string) {
+			sent = append(sent, path)
+		})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("replay didn't return once ctx was cancelled")
+	}
+	if len(sent) != 1 || sent[0] != "/a" {
+		t.Errorf("sent %q, want [/a]", sent)
+	}
+}
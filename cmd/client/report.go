@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/marselester/capacity/internal/derive"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// report summarizes a run once it's over: how many requests were sent, how many succeeded, and how fast they were.
+type report struct {
+	begun     time.Time
+	latencies *latencySamples
+}
+
+// newReport creates a report of a run which begins now.
+func newReport() *report {
+	r := report{
+		begun:     time.Now(),
+		latencies: newLatencySamples(maxLatencySamples),
+	}
+	return &r
+}
+
+// Observe records latency d of a request.
+func (r *report) Observe(d time.Duration) {
+	r.latencies.Observe(d)
+}
+
+// requests returns how many requests were sent and how many of them succeeded with 2xx
+// according to client_requests_total gathered from g.
+func (r *report) requests(g prometheus.Gatherer) (total, ok float64, err error) {
+	t, err := derive.Gather(g)
+	if err != nil {
+		return 0, 0, err
+	}
+	total, _ = t.Sum("client_requests_total", nil)
+	ok, _ = t.Sum("client_requests_total", func(labels map[string]string) bool {
+		return strings.HasPrefix(labels["status"], "2")
+	})
+	return total, ok, nil
+}
+
+// Print writes the summary to w: request totals are taken from client_requests_total gathered from g.
+func (r *report) Print(w io.Writer, g prometheus.Gatherer) error {
+	elapsed := time.Since(r.begun)
+	total, ok, err := r.requests(g)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "duration: %v\n", elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "requests: %.0f\n", total)
+	fmt.Fprintf(w, "success rate: %.2f%%\n", 100*derive.Ratio(ok, total))
+	fmt.Fprintf(w, "throughput: %.2f rps\n", derive.Ratio(total, elapsed.Seconds()))
+	qs := []float64{0.5, 0.9, 0.99, 1}
+	latencies := r.latencies.Quantiles(qs...)
+	for i, q := range qs[:len(qs)-1] {
+		fmt.Fprintf(w, "latency p%v: %v\n", 100*q, latencies[i].Round(time.Microsecond))
+	}
+	fmt.Fprintf(w, "latency max: %v\n", latencies[len(latencies)-1].Round(time.Microsecond))
+	return nil
+}
+
+// Assert checks the run against SLO: p99 latency must not exceed maxP99 and error rate (non-2xx) must not exceed maxErrorRate.
+// Zero maxP99 or negative maxErrorRate skips the check.
+// It returns descriptions of the violated assertions.
+func (r *report) Assert(g prometheus.Gatherer, maxP99 time.Duration, maxErrorRate float64) ([]string, error) {
+	total, ok, err := r.requests(g)
+	if err != nil {
+		return nil, err
+	}
+
+	var failed []string
+	if p99 := r.latencies.Quantile(0.99); maxP99 > 0 && p99 > maxP99 {
+		failed = append(failed, fmt.Sprintf("p99 latency %v exceeds %v", p99.Round(time.Microsecond), maxP99))
+	}
+	if errorRate := derive.Ratio(total-ok, total); maxErrorRate >= 0 && errorRate > maxErrorRate {
+		failed = append(failed, fmt.Sprintf("error rate %.4f exceeds %v", errorRate, maxErrorRate))
+	}
+	return failed, nil
+}
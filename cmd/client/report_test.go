@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestReportPrint(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	reg := prometheus.NewRegistry()
+	requestTotal := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "client_requests_total"}, []string{"endpoint", "method", "status"})
+	reg.MustRegister(requestTotal)
+	summary := newReport()
+
+	// Workers run for a short duration, every fourth request fails.
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	pool := workerPool{
+		work: func(ctx context.Context, workerID int) {
+			for i := 0; ctx.Err() == nil; i++ {
+				target := srv.URL
+				if i%4 == 3 {
+					target += "/fail"
+				}
+				total := requestTotal.MustCurryWith(prometheus.Labels{"endpoint": target})
+				begun := time.Now()
+				if _, err := fetch(context.Background(), srv.Client(), http.MethodGet, target, nil, nil, total, prometheus.NewHistogram(prometheus.HistogramOpts{})); err != nil {
+					t.Error(err)
+				}
+				summary.Observe(time.Since(begun))
+			}
+		},
+		active: prometheus.NewGauge(prometheus.GaugeOpts{}),
+	}
+	pool.Resize(ctx, 4)
+	pool.Wait()
+
+	var out bytes.Buffer
+	if err := summary.Print(&out, reg); err != nil {
+		t.Fatal(err)
+	}
+	fields := make(map[string]string)
+	sc := bufio.NewScanner(&out)
+	for sc.Scan() {
+		kv := strings.SplitN(sc.Text(), ": ", 2)
+		if len(kv) != 2 {
+			t.Fatalf("malformed summary line %q", sc.Text())
+		}
+		fields[kv[0]] = kv[1]
+	}
+
+	for _, name := range []string{"duration", "requests", "success rate", "throughput", "latency p50", "latency p90", "latency p99", "latency max"} {
+		if _, ok := fields[name]; !ok {
+			t.Errorf("summary is missing %q: %q", name, out.String())
+		}
+	}
+	requests, err := strconv.Atoi(fields["requests"])
+	if err != nil || requests < 4 {
+		t.Errorf("got %q requests, want at least 4", fields["requests"])
+	}
+	successRate, err := strconv.ParseFloat(strings.TrimSuffix(fields["success rate"], "%"), 64)
+	if err != nil || successRate < 70 || successRate > 80 {
+		t.Errorf("got %q success rate, want about 75%%", fields["success rate"])
+	}
+	var latencies []time.Duration
+	for _, name := range []string{"latency p50", "latency p90", "latency p99", "latency max"} {
+		d, err := time.ParseDuration(fields[name])
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		latencies = append(latencies, d)
+	}
+	for i := 1; i < len(latencies); i++ {
+		if latencies[i] < latencies[i-1] {
+			t.Errorf("latency quantiles %v aren't ordered", latencies)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// maxLatencySamples is how many latencies a sampler keeps,
+// so that memory doesn't grow with the duration of a run.
+const maxLatencySamples = 10000
+
+// latencySamples collects request latencies to estimate their quantiles.
+// It keeps a uniform random sample of a fixed size (reservoir sampling),
+// so quantiles of a long run are estimated from a bounded number of latencies.
+type latencySamples struct {
+	mu sync.Mutex
+	// size is how many latencies the reservoir keeps.
+	size int
+	// seen is how many latencies were observed since the last flush.
+	seen    int64
+	samples []time.Duration
+	// max is the highest latency observed since the last flush,
+	// it's tracked apart from the samples which might not have kept it.
+	max time.Duration
+}
+
+// newLatencySamples creates a sampler which keeps at most size latencies.
+func newLatencySamples(size int) *latencySamples {
+	s := latencySamples{size: size}
+	return &s
+}
+
+// Observe records request latency d.
+// Once the reservoir is full, d replaces a random sample with probability size/seen.
+func (s *latencySamples) Observe(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seen++
+	if d > s.max {
+		s.max = d
+	}
+	if len(s.samples) < s.size {
+		s.samples = append(s.samples, d)
+		return
+	}
+	if i := rand.Int63n(s.seen); i < int64(s.size) {
+		s.samples[i] = d
+	}
+}
+
+// Quantile returns the q-quantile (0 <= q <= 1) of latencies observed since the last flush, zero if there were none.
+func (s *latencySamples) Quantile(q float64) time.Duration {
+	return s.Quantiles(q)[0]
+}
+
+// Quantiles returns the quantiles (0 <= q <= 1) of latencies observed since the last flush, zeros if there were none.
+// The samples are sorted once for all the quantiles.
+func (s *latencySamples) Quantiles(qs ...float64) []time.Duration {
+	s.mu.Lock()
+	samples := make([]time.Duration, len(s.samples))
+	copy(samples, s.samples)
+	max := s.max
+	s.mu.Unlock()
+
+	return quantiles(samples, max, qs)
+}
+
+// Flush returns the q-quantile (0 <= q <= 1) of latencies observed since the last flush
+// and how many there were.
+func (s *latencySamples) Flush(q float64) (time.Duration, int) {
+	s.mu.Lock()
+	samples, seen, max := s.samples, s.seen, s.max
+	s.samples, s.seen, s.max = nil, 0, 0
+	s.mu.Unlock()
+
+	return quantiles(samples, max, []float64{q})[0], int(seen)
+}
+
+// quantiles sorts samples and returns their quantiles qs, the 1-quantile is the max latency.
+func quantiles(samples []time.Duration, max time.Duration, qs []float64) []time.Duration {
+	values := make([]time.Duration, len(qs))
+	if len(samples) == 0 {
+		return values
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	for k, q := range qs {
+		if q >= 1 {
+			values[k] = max
+			continue
+		}
+		values[k] = samples[int(q*float64(len(samples)-1))]
+	}
+	return values
+}
+
+// adaptRate throttles limiter every interval when p99 latency exceeds slo
+// and gradually restores it up to maxRPS once latency is back within slo.
+// The rate is backed off to 75% and increased by 10% of maxRPS.
+func adaptRate(limiter *rate.Limiter, samples *latencySamples, slo time.Duration, maxRPS float64, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	minRPS := maxRPS / 100
+	for range ticker.C {
+		p99, n := samples.Flush(0.99)
+		if n == 0 {
+			continue
+		}
+
+		rps := float64(limiter.Limit())
+		if p99 > slo {
+			rps *= 0.75
+			if rps < minRPS {
+				rps = minRPS
+			}
+		} else {
+			rps += maxRPS / 10
+			if rps > maxRPS {
+				rps = maxRPS
+			}
+		}
+		if rps != float64(limiter.Limit()) {
+			fmt.Printf("p99 latency %v, setting rate to %.2f rps\n", p99, rps)
+			limiter.SetLimit(rate.Limit(rps))
+		}
+	}
+}
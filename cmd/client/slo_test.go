@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestLatencySamplesBounded(t *testing.T) {
+	s := newLatencySamples(1000)
+	// Latencies are 1ms..10000ms, so their quantiles are known.
+	for i := 1; i <= 10000; i++ {
+		s.Observe(time.Duration(i) * time.Millisecond)
+	}
+	if len(s.samples) != 1000 {
+		t.Fatalf("kept %d samples, want 1000", len(s.samples))
+	}
+
+	got := s.Quantiles(0.5, 0.9, 1)
+	want := []time.Duration{5 * time.Second, 9 * time.Second, 10 * time.Second}
+	tolerance := []time.Duration{time.Second, time.Second, 0}
+	for i := range want {
+		if d := got[i] - want[i]; d < -tolerance[i] || d > tolerance[i] {
+			t.Errorf("quantile %d is %v, want %v±%v", i, got[i], want[i], tolerance[i])
+		}
+	}
+
+	p99, n := s.Flush(0.99)
+	if n != 10000 {
+		t.Errorf("flushed %d latencies, want 10000", n)
+	}
+	if p99 < 9*time.Second {
+		t.Errorf("p99 %v, want above 9s", p99)
+	}
+	if q := s.Quantile(1); q != 0 {
+		t.Errorf("max %v after flush, want 0", q)
+	}
+}
+
+func TestAdaptRate(t *testing.T) {
+	var delay int64 = int64(time.Millisecond)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Duration(atomic.LoadInt64(&delay)))
+	}))
+	defer srv.Close()
+
+	const maxRPS = 200
+	limiter := rate.NewLimiter(maxRPS, maxRPS)
+	samples := newLatencySamples(maxLatencySamples)
+	go adaptRate(limiter, samples, 20*time.Millisecond, maxRPS, 50*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for w := 0; w < 10; w++ {
+		go func() {
+			for limiter.Wait(ctx) == nil {
+				begun := time.Now()
+				resp, err := srv.Client().Get(srv.URL)
+				if err != nil {
+					continue
+				}
+				resp.Body.Close()
+				samples.Observe(time.Since(begun))
+			}
+		}()
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if l := limiter.Limit(); l != maxRPS {
+		t.Fatalf("rate %v, want %v while latency is within SLO", l, maxRPS)
+	}
+	// Origin got slow, so the client throttles.
+	atomic.StoreInt64(&delay, int64(50*time.Millisecond))
+	time.Sleep(300 * time.Millisecond)
+	if l := limiter.Limit(); l > maxRPS*0.75 {
+		t.Errorf("rate %v, want at most %v once p99 latency exceeded SLO", l, maxRPS*0.75)
+	}
+}
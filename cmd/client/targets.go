@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// targetChooser picks a target URL at random in proportion to its weight.
+type targetChooser struct {
+	urls []string
+	// cumulative are running totals of target weights, so a target is found with binary search.
+	cumulative []float64
+}
+
+// parseTargets parses a comma-separated list of url=weight targets, e.g., http://o/a=3,http://o/b=1.
+// The weight is optional and defaults to 1.
+// A URL with a query or fragment can't be followed by a weight,
+// so that a query value such as http://o/?v=2 isn't mistaken for it.
+func parseTargets(spec string) (*targetChooser, error) {
+	var c targetChooser
+	var total float64
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		url, weight := s, 1.0
+		if i := strings.LastIndex(s, "="); i != -1 && !strings.ContainsAny(s[:i], "?#") {
+			if w, err := strconv.ParseFloat(s[i+1:], 64); err == nil {
+				url, weight = s[:i], w
+			}
+		}
+		if url == "" {
+			return nil, fmt.Errorf("target %q: want url=weight", s)
+		}
+		if weight <= 0 {
+			return nil, fmt.Errorf("target %q: weight must be positive", s)
+		}
+
+		total += weight
+		c.urls = append(c.urls, url)
+		c.cumulative = append(c.cumulative, total)
+	}
+	return &c, nil
+}
+
+// Pick returns a target URL chosen in proportion to the weights.
+func (c *targetChooser) Pick() string {
+	x := rand.Float64() * c.cumulative[len(c.cumulative)-1]
+	i := sort.Search(len(c.cumulative), func(i int) bool {
+		return c.cumulative[i] > x
+	})
+	return c.urls[i]
+}
+
+// Len returns the number of targets.
+func (c *targetChooser) Len() int {
+	return len(c.urls)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseTargets(t *testing.T) {
+	c, err := parseTargets("http://o/a=3, http://o/b,http://o/?v=2,http://o/c=0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"http://o/a", "http://o/b", "http://o/?v=2", "http://o/c"}
+	if c.Len() != len(want) {
+		t.Fatalf("got %d targets %q, want %q", c.Len(), c.urls, want)
+	}
+	for i, u := range want {
+		if c.urls[i] != u {
+			t.Errorf("target %d is %q, want %q", i, c.urls[i], u)
+		}
+	}
+	wantCumulative := []float64{3, 4, 5, 5.5}
+	for i, w := range wantCumulative {
+		if c.cumulative[i] != w {
+			t.Errorf("cumulative weight %d is %v, want %v", i, c.cumulative[i], w)
+		}
+	}
+}
+
+func TestParseTargetsError(t *testing.T) {
+	for _, spec := range []string{"=1", "http://o/a=0", "http://o/a=-1", "http://o/a,"} {
+		if _, err := parseTargets(spec); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
+
+func TestTargetChooserPick(t *testing.T) {
+	c, err := parseTargets("http://o/a=3,http://o/b=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 100000
+	picked := map[string]int{}
+	for i := 0; i < n; i++ {
+		picked[c.Pick()]++
+	}
+	for u, want := range map[string]float64{"http://o/a": 0.75, "http://o/b": 0.25} {
+		if got := float64(picked[u]) / n; math.Abs(got-want) > 0.01 {
+			t.Errorf("%s was picked %.3f of the time, want %.2f", u, got, want)
+		}
+	}
+}
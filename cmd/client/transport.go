@@ -0,0 +1,23 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+)
+
+// newTransport creates the client's own transport, so that connection pooling is controlled by flags
+// rather than defaults shared with the rest of the process.
+// With noReuse every request opens a fresh connection.
+// TLS sessions are cached when tlsSessionCache is positive.
+func newTransport(maxIdleConnsPerHost, maxConnsPerHost int, noReuse bool, tlsSessionCache int) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	t.MaxConnsPerHost = maxConnsPerHost
+	t.DisableKeepAlives = noReuse
+	if tlsSessionCache > 0 {
+		t.TLSClientConfig = &tls.Config{
+			ClientSessionCache: tls.NewLRUClientSessionCache(tlsSessionCache),
+		}
+	}
+	return t
+}
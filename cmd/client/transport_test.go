@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// connCountingServer starts a server which counts connections it accepted,
+// a request takes delay to serve.
+func connCountingServer(delay time.Duration) (*httptest.Server, *int64) {
+	var conns int64
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+	}))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&conns, 1)
+		}
+	}
+	srv.Start()
+	return srv, &conns
+}
+
+func TestTransportNoReuse(t *testing.T) {
+	tests := map[string]struct {
+		noReuse bool
+		want    int64
+	}{
+		"reuse":    {want: 1},
+		"no reuse": {noReuse: true, want: 5},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv, conns := connCountingServer(0)
+			defer srv.Close()
+
+			transport := newTransport(http.DefaultMaxIdleConnsPerHost, 0, tc.noReuse, 0)
+			defer transport.CloseIdleConnections()
+			c := http.Client{Transport: transport}
+			for i := 0; i < 5; i++ {
+				resp, err := c.Get(srv.URL)
+				if err != nil {
+					t.Fatal(err)
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+
+			if got := atomic.LoadInt64(conns); got != tc.want {
+				t.Errorf("server accepted %d connections, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTransportMaxConnsPerHost(t *testing.T) {
+	srv, conns := connCountingServer(20 * time.Millisecond)
+	defer srv.Close()
+
+	transport := newTransport(http.DefaultMaxIdleConnsPerHost, 2, false, 0)
+	defer transport.CloseIdleConnections()
+	c := http.Client{Transport: transport}
+
+	// Concurrent requests wait for one of two connections instead of dialing more.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := c.Get(srv.URL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt64(conns); got != 2 {
+		t.Errorf("server accepted %d connections, want 2", got)
+	}
+}
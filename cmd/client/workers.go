@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// workerPool runs a varying number of workers generating load.
+type workerPool struct {
+	// work is a worker's loop which should return when ctx is cancelled.
+	work   func(ctx context.Context, workerID int)
+	active prometheus.Gauge
+
+	mu      sync.Mutex
+	cancels []context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// Resize starts or stops workers so that n of them are running.
+// The most recently started workers are stopped first.
+func (p *workerPool) Resize(ctx context.Context, n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.cancels) < n {
+		workerCtx, cancel := context.WithCancel(ctx)
+		p.cancels = append(p.cancels, cancel)
+		p.active.Inc()
+
+		p.wg.Add(1)
+		go func(workerID int) {
+			defer p.wg.Done()
+			defer p.active.Dec()
+
+			p.work(workerCtx, workerID)
+		}(len(p.cancels) - 1)
+	}
+
+	for len(p.cancels) > n {
+		last := len(p.cancels) - 1
+		p.cancels[last]()
+		p.cancels = p.cancels[:last]
+	}
+}
+
+// Wait blocks until all workers have stopped.
+func (p *workerPool) Wait() {
+	p.wg.Wait()
+}
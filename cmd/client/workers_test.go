@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestWorkerPoolFollowsProfile(t *testing.T) {
+	pp, err := parseProfile("0:3,50ms:6,100ms:2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var live int64
+	pool := workerPool{
+		work: func(ctx context.Context, workerID int) {
+			atomic.AddInt64(&live, 1)
+			defer atomic.AddInt64(&live, -1)
+			<-ctx.Done()
+		},
+		active: prometheus.NewGauge(prometheus.GaugeOpts{}),
+	}
+	// waitLive waits until n workers are running.
+	waitLive := func(n int64) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for atomic.LoadInt64(&live) != n {
+			if time.Now().After(deadline) {
+				t.Fatalf("%d workers are running, want %d", atomic.LoadInt64(&live), n)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if active := testutil.ToFloat64(pool.active); active != float64(n) {
+			t.Errorf("active workers gauge %v, want %d", active, n)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	begun := time.Now()
+	var offsets []time.Duration
+	runProfile(ctx, pp, func(n float64) {
+		offsets = append(offsets, time.Since(begun))
+		pool.Resize(ctx, int(n))
+		waitLive(int64(n))
+	})
+	for i, p := range pp {
+		if offsets[i] < p.offset {
+			t.Errorf("phase %d began at %v, want after %v", i, offsets[i], p.offset)
+		}
+	}
+
+	cancel()
+	pool.Wait()
+	waitLive(0)
+}
+
+func TestParseProfileError(t *testing.T) {
+	tests := map[string]string{
+		"no value":        "0",
+		"bad offset":      "0:1,soon:2",
+		"negative value":  "0:-1",
+		"unordered phase": "0:1,1m:2,30s:3",
+	}
+	for name, spec := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseProfile(spec); err == nil {
+				t.Errorf("%q: expected an error", spec)
+			}
+		})
+	}
+}
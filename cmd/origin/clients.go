@@ -0,0 +1,56 @@
+package main
+
+import "sync"
+
+// clientQuota limits in-flight jobs per client,
+// so a single client can't occupy all the workers.
+type clientQuota struct {
+	max int64
+
+	mu sync.Mutex
+	// used is how many jobs are in-flight per client ID.
+	// A client is deleted once it has no jobs in-flight,
+	// so that random client IDs don't grow the map unbounded.
+	used map[string]int64
+}
+
+// newClientQuota creates a quota allowing n in-flight jobs per client.
+func newClientQuota(n int64) *clientQuota {
+	q := clientQuota{
+		max:  n,
+		used: make(map[string]int64),
+	}
+	return &q
+}
+
+// Receive fills client's quota by one and returns true if the quota was available.
+func (q *clientQuota) Receive(clientID string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.used[clientID] >= q.max {
+		return false
+	}
+	q.used[clientID]++
+	return true
+}
+
+// Release frees up client's quota by one.
+func (q *clientQuota) Release(clientID string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.used[clientID] <= 1 {
+		delete(q.used, clientID)
+		return
+	}
+	q.used[clientID]--
+}
+
+// Len returns how many clients have jobs in-flight.
+func (q *clientQuota) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.used)
+}
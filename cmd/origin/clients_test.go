@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestClientQuota(t *testing.T) {
+	q := newClientQuota(2)
+	for i := 0; i < 2; i++ {
+		if !q.Receive("a") {
+			t.Fatalf("job %d of client a wasn't admitted", i)
+		}
+	}
+	if q.Receive("a") {
+		t.Fatal("client a exceeded its quota")
+	}
+	// One client at its limit doesn't block another.
+	if !q.Receive("b") {
+		t.Fatal("client b wasn't admitted while client a was at its limit")
+	}
+
+	q.Release("a")
+	if !q.Receive("a") {
+		t.Fatal("client a wasn't admitted after a release")
+	}
+}
+
+func TestClientQuotaForgetsIdleClients(t *testing.T) {
+	q := newClientQuota(3)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 50; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				id := strconv.Itoa(g*100 + i%5)
+				if q.Receive(id) {
+					q.Release(id)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if n := q.Len(); n != 0 {
+		t.Errorf("%d clients are tracked, want 0", n)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// coDel decides which jobs to drop when they wait in the queue too long (controlled delay).
+// When the sojourn time of jobs stays above the target for an interval, jobs start being dropped
+// at an increasing rate until the sojourn time falls below the target.
+type coDel struct {
+	// target is an acceptable sojourn time of a job in the queue.
+	target time.Duration
+	// interval is how long sojourn time can stay above the target before jobs are dropped.
+	interval time.Duration
+
+	mu sync.Mutex
+	// dropping is set while jobs are being dropped.
+	dropping bool
+	// firstAbove is when sojourn time will have stayed above the target for an interval,
+	// it's zero when sojourn time is below the target.
+	firstAbove time.Time
+	// dropNext is when the next job is dropped while dropping.
+	dropNext time.Time
+	// count is how many jobs were dropped since dropping started,
+	// the drop interval shrinks as interval/sqrt(count).
+	count int
+
+	// droppingGauge is 1 while jobs are being dropped.
+	droppingGauge prometheus.Gauge
+	// dropInterval is the current interval between drops in seconds.
+	dropInterval prometheus.Gauge
+}
+
+// Drop reports whether a job which waited in the queue for sojourn time should be dropped
+// when a worker picked it up at the given time.
+func (c *coDel) Drop(now time.Time, sojourn time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	okToDrop := false
+	switch {
+	case sojourn < c.target:
+		c.firstAbove = time.Time{}
+	case c.firstAbove.IsZero():
+		c.firstAbove = now.Add(c.interval)
+	case !now.Before(c.firstAbove):
+		okToDrop = true
+	}
+
+	if c.dropping {
+		if !okToDrop {
+			c.dropping = false
+			c.droppingGauge.Set(0)
+			return false
+		}
+		if now.Before(c.dropNext) {
+			return false
+		}
+		c.count++
+		c.dropNext = c.dropNext.Add(c.dropGap())
+		return true
+	}
+	if !okToDrop {
+		return false
+	}
+
+	// Dropping resumes at the recent rate if it stopped not long ago,
+	// since the queue likely hasn't recovered yet.
+	if c.count > 2 && now.Sub(c.dropNext) < 8*c.interval {
+		c.count -= 2
+	} else {
+		c.count = 1
+	}
+	c.dropping = true
+	c.droppingGauge.Set(1)
+	c.dropNext = now.Add(c.dropGap())
+	return true
+}
+
+// dropGap returns the interval until the next drop and reports it to the gauge.
+func (c *coDel) dropGap() time.Duration {
+	gap := time.Duration(float64(c.interval) / math.Sqrt(float64(c.count)))
+	c.dropInterval.Set(gap.Seconds())
+	return gap
+}
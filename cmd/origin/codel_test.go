@@ -0,0 +1,124 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newCoDel(target, interval time.Duration) *coDel {
+	return &coDel{
+		target:        target,
+		interval:      interval,
+		droppingGauge: prometheus.NewGauge(prometheus.GaugeOpts{Name: "dropping"}),
+		dropInterval:  prometheus.NewGauge(prometheus.GaugeOpts{Name: "drop_interval"}),
+	}
+}
+
+// overload simulates a worker which spends service time on every job while a job arrives every arrival interval,
+// codel drops jobs unless it's nil.
+// It returns sojourn times of the jobs which were served.
+func overload(codel *coDel, arrival, service, duration time.Duration) []time.Duration {
+	var (
+		start   = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+		now     = start
+		next    = start
+		queue   []time.Time
+		sojourn []time.Duration
+	)
+	for now.Sub(start) < duration {
+		for !next.After(now) {
+			queue = append(queue, next)
+			next = next.Add(arrival)
+		}
+		if len(queue) == 0 {
+			now = next
+			continue
+		}
+
+		waited := now.Sub(queue[0])
+		queue = queue[1:]
+		if codel != nil && codel.Drop(now, waited) {
+			continue
+		}
+		sojourn = append(sojourn, waited)
+		now = now.Add(service)
+	}
+	return sojourn
+}
+
+// mean returns an average of the last n durations.
+func mean(dd []time.Duration, n int) time.Duration {
+	var sum time.Duration
+	for _, d := range dd[len(dd)-n:] {
+		sum += d
+	}
+	return sum / time.Duration(n)
+}
+
+func TestCoDelSustainedOverload(t *testing.T) {
+	const (
+		target   = 5 * time.Millisecond
+		interval = 100 * time.Millisecond
+	)
+	// Jobs arrive 25% faster than the worker completes them.
+	fifo := overload(nil, 8*time.Millisecond, 10*time.Millisecond, time.Minute)
+	if got := mean(fifo, 100); got < time.Second {
+		t.Fatalf("sojourn %v without CoDel, want the queue to grow", got)
+	}
+
+	codel := newCoDel(target, interval)
+	sojourn := overload(codel, 8*time.Millisecond, 10*time.Millisecond, time.Minute)
+	if got := mean(sojourn, 1000); got > 4*target {
+		t.Errorf("sojourn %v, want it to converge toward the target %v", got, target)
+	}
+	// The worker serves at most one job per service time, the excess has to be dropped.
+	if max := int(time.Minute / (10 * time.Millisecond)); len(sojourn) > max {
+		t.Errorf("served %d jobs, want at most %d", len(sojourn), max)
+	}
+}
+
+func TestCoDelDropRate(t *testing.T) {
+	const (
+		target   = 5 * time.Millisecond
+		interval = 100 * time.Millisecond
+		above    = 10 * time.Millisecond
+	)
+	c := newCoDel(target, interval)
+	now := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Sojourn time has to stay above the target for an interval before the first drop.
+	if c.Drop(now, above) {
+		t.Fatal("job was dropped as soon as sojourn time exceeded the target")
+	}
+	now = now.Add(interval)
+	if !c.Drop(now, above) {
+		t.Fatal("job wasn't dropped after sojourn time stayed above the target for an interval")
+	}
+	if got := testutil.ToFloat64(c.dropInterval); got != interval.Seconds() {
+		t.Errorf("drop interval %v, want %v", got, interval.Seconds())
+	}
+
+	// Drops get more frequent as interval/sqrt(count).
+	if c.Drop(now.Add(interval-time.Millisecond), above) {
+		t.Error("job was dropped before the drop interval passed")
+	}
+	now = now.Add(interval)
+	if !c.Drop(now, above) {
+		t.Error("job wasn't dropped after the drop interval")
+	}
+	if got, want := testutil.ToFloat64(c.dropInterval), time.Duration(float64(c.interval)/math.Sqrt2).Seconds(); got != want {
+		t.Errorf("drop interval %v, want %v", got, want)
+	}
+
+	// Sojourn time recovered, so dropping stops.
+	if c.Drop(now.Add(time.Second), target/2) {
+		t.Error("job was dropped once sojourn time fell below the target")
+	}
+	if got := testutil.ToFloat64(c.droppingGauge); got != 0 {
+		t.Errorf("dropping gauge %v, want 0", got)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+)
+
+// connKey is a context key of connection state.
+type connKey struct{}
+
+// connState tracks whether a connection has already served its first request.
+type connState struct {
+	served int32
+}
+
+// withConnState adds a new connection state to the connection's context.
+// It is meant to be used as http.Server.ConnContext.
+func withConnState(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, &connState{})
+}
+
+// isFirstRequest reports whether a request with the given context is the first one on its connection.
+func isFirstRequest(ctx context.Context) bool {
+	s, ok := ctx.Value(connKey{}).(*connState)
+	if !ok {
+		return false
+	}
+	return atomic.CompareAndSwapInt32(&s.served, 0, 1)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConnSetupDelay(t *testing.T) {
+	const delay = 100 * time.Millisecond
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isFirstRequest(r.Context()) {
+			time.Sleep(delay)
+		}
+	}))
+	srv.Config.ConnContext = withConnState
+	srv.Start()
+	defer srv.Close()
+
+	c := srv.Client()
+	get := func() time.Duration {
+		t.Helper()
+		begun := time.Now()
+		resp, err := c.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return time.Since(begun)
+	}
+
+	if d := get(); d < delay {
+		t.Errorf("first request on a new connection took %v, want at least %v", d, delay)
+	}
+	if d := get(); d >= delay {
+		t.Errorf("second request on the same connection took %v, want less than %v", d, delay)
+	}
+	c.CloseIdleConnections()
+	if d := get(); d < delay {
+		t.Errorf("first request on another connection took %v, want at least %v", d, delay)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import "time"
+
+// parseDeadline parses X-Request-Deadline header value h in RFC 3339 format, e.g., 2021-06-01T10:00:00.5Z.
+// It returns false if the value is empty or can't be parsed.
+func parseDeadline(h string) (time.Time, bool) {
+	if h == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, h)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// expiredBy reports whether the job's deadline passes by time t.
+func (j job) expiredBy(t time.Time) bool {
+	return !j.deadline.IsZero() && !t.Before(j.deadline)
+}
+
+// do spends work time on the job unless its deadline passes first.
+// It reports whether the work was completed.
+func (j job) do(work time.Duration) bool {
+	if j.deadline.IsZero() || time.Until(j.deadline) >= work {
+		time.Sleep(work)
+		return true
+	}
+	time.Sleep(time.Until(j.deadline))
+	return false
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJobExpiredBy(t *testing.T) {
+	now := time.Now()
+	tests := map[string]struct {
+		deadline time.Time
+		want     bool
+	}{
+		"no deadline": {},
+		"passed":      {deadline: now.Add(-time.Millisecond), want: true},
+		"now":         {deadline: now, want: true},
+		"ahead":       {deadline: now.Add(time.Millisecond)},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := (job{deadline: tc.deadline}).expiredBy(now); got != tc.want {
+				t.Errorf("got %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestJobDo(t *testing.T) {
+	const work = 200 * time.Millisecond
+	tests := map[string]struct {
+		deadline time.Duration
+		want     bool
+		wantTook time.Duration
+	}{
+		"no deadline":    {want: true, wantTook: work},
+		"enough time":    {deadline: time.Second, want: true, wantTook: work},
+		"short deadline": {deadline: 20 * time.Millisecond, wantTook: 20 * time.Millisecond},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var j job
+			if tc.deadline > 0 {
+				j.deadline = time.Now().Add(tc.deadline)
+			}
+			start := time.Now()
+			if got := j.do(work); got != tc.want {
+				t.Errorf("completed %t, want %t", got, tc.want)
+			}
+			// The worker is freed up once the deadline passes instead of sleeping through the work.
+			if took := time.Since(start); took < tc.wantTook || took > tc.wantTook+50*time.Millisecond {
+				t.Errorf("took %v, want %v", took, tc.wantTook)
+			}
+		})
+	}
+}
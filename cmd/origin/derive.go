@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/marselester/capacity/internal/derive"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// derivedGauges are computed from origin's raw metrics, so that a dashboard doesn't need PromQL recording rules.
+type derivedGauges struct {
+	rejectionRatio prometheus.Gauge
+	goodputRate    prometheus.Gauge
+	utilization    prometheus.Gauge
+	// workers is how many workers process requests.
+	workers int
+}
+
+// newDerivedGauges creates and registers derived gauges of origin with the given number of workers.
+func newDerivedGauges(workers int) *derivedGauges {
+	d := derivedGauges{
+		rejectionRatio: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "origin_derived_rejection_ratio",
+			Help: "Fraction of requests shed with 429 or 503 since the previous derivation.",
+		}),
+		goodputRate: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "origin_derived_goodput_per_second",
+			Help: "How many requests per second succeeded with 2xx since the previous derivation.",
+		}),
+		utilization: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "origin_derived_utilization",
+			Help: "Fraction of time workers were busy since the previous derivation.",
+		}),
+		workers: workers,
+	}
+	prometheus.MustRegister(d.rejectionRatio, d.goodputRate, d.utilization)
+	return &d
+}
+
+// Update sets the gauges based on how origin_requests_total and origin_worker_busy_seconds_total changed in elapsed time.
+func (d *derivedGauges) Update(prev, cur derive.Totals, elapsed time.Duration) {
+	total, _ := derive.Delta(prev, cur, "origin_requests_total", nil)
+	shed, _ := derive.Delta(prev, cur, "origin_requests_total", func(labels map[string]string) bool {
+		return labels["status"] == "429" || labels["status"] == "503"
+	})
+	ok, _ := derive.Delta(prev, cur, "origin_requests_total", func(labels map[string]string) bool {
+		return strings.HasPrefix(labels["status"], "2")
+	})
+	busy, _ := derive.Delta(prev, cur, "origin_worker_busy_seconds_total", nil)
+
+	d.rejectionRatio.Set(derive.Ratio(shed, total))
+	d.goodputRate.Set(derive.Ratio(ok, elapsed.Seconds()))
+	d.utilization.Set(derive.Ratio(busy, elapsed.Seconds()*float64(d.workers)))
+}
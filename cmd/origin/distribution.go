@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Distribution samples how long it takes to process a request.
+type Distribution interface {
+	Sample() time.Duration
+}
+
+// newDistribution creates a distribution by name with the given mean and standard deviation.
+// Standard deviation is only applicable to normal and lognormal distributions.
+func newDistribution(name string, mean, stddev time.Duration) (Distribution, error) {
+	if mean < 0 || stddev < 0 {
+		return nil, fmt.Errorf("%s distribution: mean %v and stddev %v must not be negative", name, mean, stddev)
+	}
+
+	switch name {
+	case "constant":
+		if stddev != 0 {
+			return nil, fmt.Errorf("constant distribution: stddev %v is not applicable", stddev)
+		}
+		return constantDistribution{mean: mean}, nil
+	case "normal":
+		return normalDistribution{mean: mean, stddev: stddev}, nil
+	case "exponential":
+		if stddev != 0 {
+			return nil, fmt.Errorf("exponential distribution: stddev %v is not applicable, it equals the mean", stddev)
+		}
+		return exponentialDistribution{mean: mean}, nil
+	case "lognormal":
+		if mean == 0 || stddev == 0 {
+			return nil, fmt.Errorf("lognormal distribution: mean %v and stddev %v must be positive", mean, stddev)
+		}
+		return newLognormalDistribution(mean, stddev), nil
+	}
+	return nil, fmt.Errorf("unknown distribution %q: want constant, normal, exponential, or lognormal", name)
+}
+
+// constantDistribution always takes the same time.
+type constantDistribution struct {
+	mean time.Duration
+}
+
+// Sample returns the mean.
+func (d constantDistribution) Sample() time.Duration {
+	return d.mean
+}
+
+// normalDistribution is a normal distribution clamped at zero.
+type normalDistribution struct {
+	mean   time.Duration
+	stddev time.Duration
+}
+
+// Sample returns a normally distributed duration.
+// Negative durations are clamped to zero.
+func (d normalDistribution) Sample() time.Duration {
+	s := time.Duration(rand.NormFloat64()*float64(d.stddev)) + d.mean
+	if s < 0 {
+		return 0
+	}
+	return s
+}
+
+// exponentialDistribution is memoryless, e.g., service time in M/M/c queueing models.
+type exponentialDistribution struct {
+	mean time.Duration
+}
+
+// Sample returns an exponentially distributed duration.
+func (d exponentialDistribution) Sample() time.Duration {
+	return time.Duration(rand.ExpFloat64() * float64(d.mean))
+}
+
+// lognormalDistribution has a long tail, e.g., a few requests take much longer than most.
+type lognormalDistribution struct {
+	// mu and sigma are the mean and standard deviation of the duration's natural logarithm.
+	mu    float64
+	sigma float64
+}
+
+// newLognormalDistribution creates a lognormal distribution with the given mean and standard deviation of durations.
+func newLognormalDistribution(mean, stddev time.Duration) lognormalDistribution {
+	m, s := float64(mean), float64(stddev)
+	sigma2 := math.Log(1 + s*s/(m*m))
+	return lognormalDistribution{
+		mu:    math.Log(m) - sigma2/2,
+		sigma: math.Sqrt(sigma2),
+	}
+}
+
+// Sample returns a lognormally distributed duration.
+func (d lognormalDistribution) Sample() time.Duration {
+	return time.Duration(math.Exp(d.mu + d.sigma*rand.NormFloat64()))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// moments returns the empirical mean and standard deviation of n samples of d in milliseconds,
+// and the least sample.
+func moments(d Distribution, n int) (mean, stddev float64, min time.Duration) {
+	var sum, sumSq float64
+	min = time.Duration(math.MaxInt64)
+	for i := 0; i < n; i++ {
+		s := d.Sample()
+		if s < min {
+			min = s
+		}
+		ms := float64(s) / float64(time.Millisecond)
+		sum += ms
+		sumSq += ms * ms
+	}
+	mean = sum / float64(n)
+	return mean, math.Sqrt(sumSq/float64(n) - mean*mean), min
+}
+
+func TestNormalDistribution(t *testing.T) {
+	d, err := newDistribution("normal", 100*time.Millisecond, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mean, stddev, _ := moments(d, 100000)
+	if math.Abs(mean-100) > 1 {
+		t.Errorf("mean %.2fms, want about 100ms", mean)
+	}
+	if math.Abs(stddev-20) > 1 {
+		t.Errorf("stddev %.2fms, want about 20ms", stddev)
+	}
+
+	// The standard deviation is so large that many samples would be negative.
+	d, _ = newDistribution("normal", 10*time.Millisecond, 50*time.Millisecond)
+	if _, _, min := moments(d, 10000); min != 0 {
+		t.Errorf("min %v, want negative samples clamped to 0", min)
+	}
+}
+
+func TestDistributions(t *testing.T) {
+	tests := map[string]struct {
+		mean, stddev time.Duration
+		// wantStddev is the expected standard deviation in milliseconds.
+		wantStddev float64
+	}{
+		"constant":    {mean: 100 * time.Millisecond, wantStddev: 0},
+		"normal":      {mean: 100 * time.Millisecond, stddev: 30 * time.Millisecond, wantStddev: 30},
+		"exponential": {mean: 100 * time.Millisecond, wantStddev: 100},
+		"lognormal":   {mean: 100 * time.Millisecond, stddev: 50 * time.Millisecond, wantStddev: 50},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			d, err := newDistribution(name, tc.mean, tc.stddev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mean, stddev, min := moments(d, 200000)
+			if math.Abs(mean-100) > 2 {
+				t.Errorf("mean %.2fms, want about 100ms", mean)
+			}
+			if math.Abs(stddev-tc.wantStddev) > 0.05*tc.wantStddev+0.01 {
+				t.Errorf("stddev %.2fms, want about %.2fms", stddev, tc.wantStddev)
+			}
+			if min < 0 {
+				t.Errorf("min %v, want no negative samples", min)
+			}
+		})
+	}
+}
+
+func TestNewDistributionError(t *testing.T) {
+	tests := map[string]struct {
+		name         string
+		mean, stddev time.Duration
+	}{
+		"unknown":                  {name: "uniform", mean: time.Second},
+		"negative mean":            {name: "normal", mean: -time.Second},
+		"negative stddev":          {name: "normal", mean: time.Second, stddev: -time.Second},
+		"constant with stddev":     {name: "constant", mean: time.Second, stddev: time.Second},
+		"exponential with stddev":  {name: "exponential", mean: time.Second, stddev: time.Second},
+		"lognormal without stddev": {name: "lognormal", mean: time.Second},
+		"lognormal without mean":   {name: "lognormal", stddev: time.Second},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := newDistribution(tc.name, tc.mean, tc.stddev); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// faultInjector makes a fraction of requests fail or take longer, e.g., to test the proxy's adaptive back-off.
+type faultInjector struct {
+	// errorRate is a fraction of requests which fail with errorStatus.
+	errorRate   float64
+	errorStatus int
+	// slowRate is a fraction of requests which take slowFactor times longer to process.
+	slowRate   float64
+	slowFactor float64
+	// truncateRate is a fraction of processed requests whose response body is cut short.
+	truncateRate float64
+}
+
+// validate checks that rates are fractions and the status is an error.
+func (f faultInjector) validate() error {
+	if f.errorRate < 0 || f.errorRate > 1 {
+		return fmt.Errorf("error rate %v must be in [0, 1]", f.errorRate)
+	}
+	if f.errorStatus < 400 || f.errorStatus > 599 {
+		return fmt.Errorf("error status %d must be 4xx or 5xx", f.errorStatus)
+	}
+	if f.slowRate < 0 || f.slowRate > 1 {
+		return fmt.Errorf("slow rate %v must be in [0, 1]", f.slowRate)
+	}
+	if f.slowFactor <= 0 {
+		return fmt.Errorf("slow factor %v must be positive", f.slowFactor)
+	}
+	if f.truncateRate < 0 || f.truncateRate > 1 {
+		return fmt.Errorf("truncate rate %v must be in [0, 1]", f.truncateRate)
+	}
+	return nil
+}
+
+// Fail reports whether a request should fail with the error status.
+func (f faultInjector) Fail() bool {
+	return f.errorRate > 0 && rand.Float64() < f.errorRate
+}
+
+// WorkFactor returns how many times longer a request should take to process, 1 means as usual.
+func (f faultInjector) WorkFactor() float64 {
+	if f.slowRate > 0 && rand.Float64() < f.slowRate {
+		return f.slowFactor
+	}
+	return 1
+}
+
+// Truncate reports whether a response body should be cut short.
+func (f faultInjector) Truncate() bool {
+	return f.truncateRate > 0 && rand.Float64() < f.truncateRate
+}
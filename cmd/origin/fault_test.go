@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestFaultInjectorRates(t *testing.T) {
+	f := faultInjector{
+		errorRate:    0.1,
+		errorStatus:  503,
+		slowRate:     0.25,
+		slowFactor:   3,
+		truncateRate: 0.05,
+	}
+	if err := f.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 100000
+	var failed, slow, truncated int
+	for i := 0; i < n; i++ {
+		if f.Fail() {
+			failed++
+		}
+		switch w := f.WorkFactor(); w {
+		case 3:
+			slow++
+		case 1:
+		default:
+			t.Fatalf("work factor %v, want 1 or 3", w)
+		}
+		if f.Truncate() {
+			truncated++
+		}
+	}
+	tests := map[string]struct {
+		got  int
+		want float64
+	}{
+		"error":    {got: failed, want: 0.1},
+		"slow":     {got: slow, want: 0.25},
+		"truncate": {got: truncated, want: 0.05},
+	}
+	for name, tc := range tests {
+		if rate := float64(tc.got) / n; math.Abs(rate-tc.want) > 0.01 {
+			t.Errorf("%s rate %.3f, want %.3f", name, rate, tc.want)
+		}
+	}
+}
+
+func TestFaultInjectorSeed(t *testing.T) {
+	f := faultInjector{errorRate: 0.5, errorStatus: 503, slowFactor: 1}
+	pattern := func(seed int64) []bool {
+		rand.Seed(seed)
+		p := make([]bool, 100)
+		for i := range p {
+			p[i] = f.Fail()
+		}
+		return p
+	}
+
+	a, b := pattern(42), pattern(42)
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("request %d: failure patterns of the same seed differ", i)
+		}
+	}
+}
+
+func TestFaultInjectorValidate(t *testing.T) {
+	ok := faultInjector{errorStatus: 503, slowFactor: 1}
+	tests := map[string]func(f *faultInjector){
+		"error rate":    func(f *faultInjector) { f.errorRate = 1.5 },
+		"error status":  func(f *faultInjector) { f.errorStatus = 200 },
+		"slow rate":     func(f *faultInjector) { f.slowRate = -0.1 },
+		"slow factor":   func(f *faultInjector) { f.slowFactor = 0 },
+		"truncate rate": func(f *faultInjector) { f.truncateRate = 2 },
+	}
+	for name, breakIt := range tests {
+		t.Run(name, func(t *testing.T) {
+			f := ok
+			breakIt(&f)
+			if err := f.validate(); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
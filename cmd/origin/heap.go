@@ -0,0 +1,42 @@
+package main
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// heapGuard reports whether heap in use exceeded a limit, e.g., to shed requests before running out of memory.
+// Heap is sampled periodically, because runtime.ReadMemStats stops the world.
+type heapGuard struct {
+	max int64
+	// inuse is heap in use in bytes as of the most recent sample.
+	inuse int64
+	// gauge reports heap in use.
+	gauge prometheus.Gauge
+}
+
+// Run samples heap in use every interval.
+func (g *heapGuard) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		g.sample()
+	}
+}
+
+// sample records current heap in use.
+func (g *heapGuard) sample() {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	atomic.StoreInt64(&g.inuse, int64(m.HeapInuse))
+	g.gauge.Set(float64(m.HeapInuse))
+}
+
+// Exceeded reports whether heap in use is above the limit.
+func (g *heapGuard) Exceeded() bool {
+	return atomic.LoadInt64(&g.inuse) > g.max
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHeapGuard(t *testing.T) {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	const allocated = 64 << 20
+	g := heapGuard{
+		max:   int64(m.HeapInuse) + allocated/2,
+		gauge: prometheus.NewGauge(prometheus.GaugeOpts{Name: "heap"}),
+	}
+	g.sample()
+	if g.Exceeded() {
+		t.Fatalf("heap in use %d exceeded %d before allocating", g.inuse, g.max)
+	}
+
+	buf := make([]byte, allocated)
+	for i := range buf {
+		buf[i] = 1
+	}
+	g.sample()
+	if !g.Exceeded() {
+		t.Errorf("heap in use %d didn't exceed %d after allocating %d bytes", g.inuse, g.max, allocated)
+	}
+	if got := testutil.ToFloat64(g.gauge); got != float64(g.inuse) {
+		t.Errorf("gauge %v, want %d", got, g.inuse)
+	}
+	runtime.KeepAlive(buf)
+
+	// Memory was freed, so requests are served again.
+	runtime.GC()
+	g.sample()
+	if g.Exceeded() {
+		t.Errorf("heap in use %d exceeded %d after memory was freed", g.inuse, g.max)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ipConnLimiter closes new connections of a client IP which already has max connections open,
+// so a single abusive client can't exhaust the accept loop while the others are unaffected.
+type ipConnLimiter struct {
+	max      int
+	rejected prometheus.Counter
+
+	mu sync.Mutex
+	// open is how many connections are open per client IP.
+	open map[string]int
+}
+
+// newIPConnLimiter creates a limiter allowing max open connections per client IP.
+func newIPConnLimiter(max int, rejected prometheus.Counter) *ipConnLimiter {
+	l := ipConnLimiter{
+		max:      max,
+		rejected: rejected,
+		open:     make(map[string]int),
+	}
+	return &l
+}
+
+// ConnState tracks open connections per client IP and closes the new ones over the limit.
+// It is meant to be used as http.Server.ConnState.
+func (l *ipConnLimiter) ConnState(c net.Conn, state http.ConnState) {
+	ip, _, err := net.SplitHostPort(c.RemoteAddr().String())
+	if err != nil {
+		ip = c.RemoteAddr().String()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch state {
+	case http.StateNew:
+		// A closed connection is still reported as closed, so it's counted until then.
+		l.open[ip]++
+		if l.open[ip] > l.max {
+			l.rejected.Inc()
+			c.Close()
+		}
+	case http.StateHijacked, http.StateClosed:
+		if l.open[ip]--; l.open[ip] <= 0 {
+			delete(l.open, ip)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// dialFrom opens a connection to addr from the given local IP.
+func dialFrom(t *testing.T, ip, addr string) net.Conn {
+	t.Helper()
+
+	d := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(ip)}}
+	c, err := d.Dial("tcp", addr)
+	if err != nil {
+		t.Skipf("can't dial from %s: %v", ip, err)
+	}
+	return c
+}
+
+// get sends a request over connection c and returns the response status, or an error if the connection was refused.
+func get(c net.Conn) (int, error) {
+	if _, err := fmt.Fprint(c, "GET / HTTP/1.1\r\nHost: origin\r\n\r\n"); err != nil {
+		return 0, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func TestIPConnLimiter(t *testing.T) {
+	rejected := prometheus.NewCounter(prometheus.CounterOpts{Name: "rejected"})
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Config.ConnState = newIPConnLimiter(2, rejected).ConnState
+	srv.Start()
+	defer srv.Close()
+	addr := srv.Listener.Addr().String()
+
+	// The abusive client holds two connections open.
+	for i := 0; i < 2; i++ {
+		c := dialFrom(t, "127.0.0.2", addr)
+		defer c.Close()
+		if status, err := get(c); err != nil || status != http.StatusOK {
+			t.Fatalf("connection %d: got %d %v, want 200", i, status, err)
+		}
+	}
+	c := dialFrom(t, "127.0.0.2", addr)
+	defer c.Close()
+	if status, err := get(c); err == nil {
+		t.Errorf("connection over the limit got %d, want it closed", status)
+	}
+	if got := testutil.ToFloat64(rejected); got != 1 {
+		t.Errorf("rejected %v connections, want 1", got)
+	}
+
+	// Another client is unaffected.
+	c = dialFrom(t, "127.0.0.3", addr)
+	defer c.Close()
+	if status, err := get(c); err != nil || status != http.StatusOK {
+		t.Errorf("another client got %d %v, want 200", status, err)
+	}
+}
@@ -1,96 +1,417 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
+	"log"
 	"math/rand"
 	"net/http"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/marselester/capacity/internal/derive"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// jobOutcome describes how a job ended.
+type jobOutcome int
+
+const (
+	// jobDone means the job was processed.
+	jobDone jobOutcome = iota
+	// jobDropped means the job was discarded from the queue, e.g., by CoDel.
+	jobDropped
+	// jobExpired means the job's deadline passed, so it wasn't worth finishing.
+	jobExpired
+)
+
 type job struct {
-	result chan struct{}
+	// result is sent once the job is done, dropped, or expired.
+	result chan jobOutcome
+	// enqueued is when the job was put into the queue.
+	enqueued time.Time
+	// deadline is when the client gives up on the job, zero means no deadline.
+	deadline time.Time
+	// workFactor scales how long it takes to process the job, e.g., 10 for a slow request.
+	workFactor float64
 }
 
 func main() {
 	addr := flag.String("addr", ":8000", "address to listen to")
 	workerNum := flag.Int("worker", 7, "number of workers to process requests")
-	worktime := flag.Duration("worktime", time.Second, "how long it takes to process a request")
+	worktime := flag.Duration("worktime", time.Second, "how long it takes to process a request on average")
+	worktimeStddev := flag.Duration("worktime-stddev", 0, "standard deviation of time it takes to process a request with normal and lognormal distributions")
+	distributionName := flag.String("distribution", "normal", "distribution of time it takes to process a request: constant, normal, exponential, lognormal; its mean is -worktime")
 	queueSize := flag.Int("queue", 0, "how many requests to keep in a queue if workers are busy")
+	codelEnabled := flag.Bool("codel", false, "drop queued requests with 429 at an increasing rate while their time in the queue stays above -codel-target (CoDel)")
+	codelTarget := flag.Duration("codel-target", 5*time.Millisecond, "acceptable time a request waits in the queue with -codel")
+	codelInterval := flag.Duration("codel-interval", 100*time.Millisecond, "how long time in the queue can stay above -codel-target before requests are dropped")
+	queueDiscipline := flag.String("queue-discipline", "fifo", "order in which workers pick up queued requests: fifo (the oldest first) or lifo (the newest first)")
+	maxConnsPerIP := flag.Int("max-conns-per-ip", 0, "how many connections a client IP can have open, new connections over the limit are closed, 0 means no limit")
+	clientLimit := flag.Int64("client-quota", 0, "how many requests a client (X-Client-ID header) can have in-flight, 0 means no limit")
+	statusMixSpec := flag.String("status-mix", "", "weighted mix of response status codes, e.g., 200:90,500:5,503:5; error statuses are returned without processing a request")
+	connSetupDelay := flag.Duration("conn-setup-delay", 0, "how long it takes to respond to the first request on a new connection, e.g., to simulate TLS handshake")
+	startupGracePeriod := flag.Duration("startup-grace", 0, "how long after start requests wait in a full queue instead of being discarded")
+	shedVerbose := flag.Bool("shed-verbose", false, "respond to discarded requests with JSON queue stats instead of 🚦")
+	errorRate := flag.Float64("error-rate", 0, "fraction of requests which fail with -error-status without processing")
+	errorStatus := flag.Int("error-status", http.StatusServiceUnavailable, "status code of requests failed by -error-rate")
+	slowRate := flag.Float64("slow-rate", 0, "fraction of requests which take -slow-factor times longer to process")
+	slowFactor := flag.Float64("slow-factor", 10, "how many times longer slow requests take to process")
+	truncateRate := flag.Float64("truncate-rate", 0, "fraction of processed requests whose response declares a longer Content-Length than its body, so the connection is closed mid-body")
+	seed := flag.Int64("seed", 0, "seed of the random number generator to reproduce failure patterns and work times, 0 means a random seed")
+	maxHeapBytes := flag.Int64("max-heap-bytes", 0, "heap in use above which requests are discarded with 429 to avoid running out of memory, 0 means no limit")
+	heapSampleInterval := flag.Duration("heap-sample-interval", 100*time.Millisecond, "how often heap in use is sampled with -max-heap-bytes")
+	deriveInterval := flag.Duration("derive-interval", 0, "how often rejection ratio, goodput and utilization gauges are derived from raw metrics, 0 disables them")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "how long to wait for in-flight requests to finish on SIGINT/SIGTERM")
+	slowRead := flag.Int("slow-read", 0, "how many bytes per second of a request body to read before processing the request, 0 means the body isn't read")
+	pickupBucketsSpec := flag.String("pickup-interval-buckets", "0.01,0.05,0.1,0.5,0.95,1,1.05,1.5,2,5", "comma-separated histogram buckets (seconds) of intervals between successive job pickups by a worker")
 	flag.Parse()
 
-	requestTotal := prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "origin_requests_total",
-			Help: "How many HTTP requests processed, partitioned by status code.",
+	var mix *statusMix
+	faults := faultInjector{
+		errorRate:    *errorRate,
+		errorStatus:  *errorStatus,
+		slowRate:     *slowRate,
+		slowFactor:   *slowFactor,
+		truncateRate: *truncateRate,
+	}
+	if err := faults.validate(); err != nil {
+		log.Fatalf("origin: %v", err)
+	}
+	if *maxHeapBytes < 0 {
+		log.Fatalf("origin: max heap bytes must not be negative: %d", *maxHeapBytes)
+	}
+	if *slowRead < 0 {
+		log.Fatalf("origin: slow read rate must not be negative: %d", *slowRead)
+	}
+	worktimes, err := newDistribution(*distributionName, *worktime, *worktimeStddev)
+	if err != nil {
+		log.Fatalf("origin: %v", err)
+	}
+	if *statusMixSpec != "" {
+		var err error
+		if mix, err = parseStatusMix(*statusMixSpec); err != nil {
+			log.Fatalf("origin: %v", err)
+		}
+	}
+	pickupBuckets, err := parseBuckets(*pickupBucketsSpec)
+	if err != nil {
+		log.Fatalf("origin: %v", err)
+	}
+
+	requestTotal := &boundedCounterVec{
+		CounterVec: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "origin_requests_total",
+				Help: "How many HTTP requests processed, partitioned by status code; injected failures have injected status.",
+			},
+			[]string{"status"},
+		),
+		limits: map[string]*labelLimit{
+			"status": newLabelLimit(20),
 		},
-		[]string{"status"},
-	)
+	}
 	requestLatency := prometheus.NewHistogram(prometheus.HistogramOpts{
 		Name:    "origin_request_duration_seconds",
 		Help:    "Total duration of HTTP requests in seconds.",
 		Buckets: []float64{0.95, 1, 1.05, 1.1, 1.5, 1.95, 2, 2.05, 2.1, 2.5, 3, 4},
 	})
+	// Evenly spaced pickups while workers aren't busy hint
+	// that the load generator is the bottleneck (coordinated omission).
+	pickupInterval := &boundedHistogramVec{
+		HistogramVec: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "origin_worker_pickup_interval_seconds",
+				Help:    "Time between successive job pickups by a worker in seconds, partitioned by worker; workers beyond the first 50 are reported as other.",
+				Buckets: pickupBuckets,
+			},
+			[]string{"worker"},
+		),
+		limits: map[string]*labelLimit{
+			"worker": newLabelLimit(50),
+		},
+	}
+	queueFull := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "origin_queue_full_total",
+		Help: "How many requests were discarded because workers were busy and queue was full.",
+	})
+	queueDepth := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "origin_queue_depth",
+		Help: "How many jobs were waiting in the queue when a request was enqueued.",
+	})
 	prometheus.MustRegister(requestLatency)
 	prometheus.MustRegister(requestTotal)
+	prometheus.MustRegister(queueFull)
+	prometheus.MustRegister(queueDepth)
+	prometheus.MustRegister(pickupInterval)
+	var codel *coDel
+	if *codelEnabled {
+		codel = &coDel{
+			target:   *codelTarget,
+			interval: *codelInterval,
+			droppingGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+				Name: "origin_codel_dropping",
+				Help: "Whether CoDel drops queued requests: 1 dropping, 0 not.",
+			}),
+			dropInterval: prometheus.NewGauge(prometheus.GaugeOpts{
+				Name: "origin_codel_drop_interval_seconds",
+				Help: "Current interval between CoDel drops in seconds.",
+			}),
+		}
+		prometheus.MustRegister(codel.droppingGauge)
+		prometheus.MustRegister(codel.dropInterval)
+	}
+	sojourn := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "origin_queue_sojourn_seconds",
+		Help:    "How long jobs waited in the queue before a worker picked them up in seconds.",
+		Buckets: []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+	})
+	prometheus.MustRegister(sojourn)
+	enqueueDepth := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "origin_enqueue_depth",
+		Help:    "How many jobs were in the queue (including the new one) when a job was enqueued.",
+		Buckets: depthBuckets(*queueSize),
+	})
+	prometheus.MustRegister(enqueueDepth)
+	queueStats := queueMetrics{
+		full:   queueFull,
+		depth:  queueDepth,
+		depths: enqueueDepth,
+	}
+	expiredTotal := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "origin_expired_requests_total",
+			Help: "How many requests were abandoned because their X-Request-Deadline passed, partitioned by stage: queued or processing.",
+		},
+		[]string{"stage"},
+	)
+	prometheus.MustRegister(expiredTotal)
+	busySeconds := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "origin_worker_busy_seconds_total",
+		Help: "How long in seconds workers spent processing requests.",
+	})
+	prometheus.MustRegister(busySeconds)
+	if *deriveInterval > 0 {
+		go derive.Run(time.NewTicker(*deriveInterval).C, prometheus.DefaultGatherer, newDerivedGauges(*workerNum).Update, func(err error) {
+			log.Printf("origin: failed to gather metrics: %v", err)
+		})
+	}
+	var heap *heapGuard
+	if *maxHeapBytes > 0 {
+		heap = &heapGuard{
+			max: *maxHeapBytes,
+			gauge: prometheus.NewGauge(prometheus.GaugeOpts{
+				Name: "origin_heap_inuse_bytes",
+				Help: "Heap in use in bytes as of the most recent sample.",
+			}),
+		}
+		prometheus.MustRegister(heap.gauge)
+		go heap.Run(*heapSampleInterval)
+	}
 	http.Handle("/metrics", promhttp.Handler())
 
 	// Initialize the default source of uniformly-distributed pseudo-random ints.
-	rand.Seed(time.Now().UnixNano())
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	rand.Seed(*seed)
+	fmt.Printf("random seed %d\n", *seed)
 
-	jobs := make(chan job, *queueSize)
+	grace := startupGrace{
+		started: time.Now(),
+		period:  *startupGracePeriod,
+	}
+	jobs, err := newJobQueue(*queueDiscipline, *queueSize)
+	if err != nil {
+		log.Fatalf("origin: %v", err)
+	}
+	clients := newClientQuota(*clientLimit)
+
+	// shed discards a request with 429 and optionally tells a client how busy origin is.
+	shed := func(rw http.ResponseWriter) {
+		writeShed(rw, *shedVerbose, jobs, *workerNum)
+	}
 
 	http.HandleFunc("/", func(rw http.ResponseWriter, r *http.Request) {
 		var status int
+		// injected is set when a request was failed on purpose.
+		var injected bool
 		defer func(begun time.Time) {
 			took := time.Since(begun)
 			requestLatency.Observe(took.Seconds())
 			fmt.Printf("request took %v\n", took)
 
+			label := fmt.Sprint(status)
+			if injected {
+				label = "injected"
+			}
 			requestTotal.With(prometheus.Labels{
-				"status": fmt.Sprint(status),
+				"status": label,
 			}).Inc()
 		}(time.Now())
 
-		j := job{
-			result: make(chan struct{}),
+		if *connSetupDelay > 0 && isFirstRequest(r.Context()) {
+			time.Sleep(*connSetupDelay)
 		}
-		select {
-		case jobs <- j:
-			<-j.result
-			status = http.StatusOK
+
+		// Discard requests if origin is about to run out of memory.
+		if heap != nil && heap.Exceeded() {
+			status = http.StatusTooManyRequests
+			shed(rw)
+			return
+		}
+
+		// Discard requests if a client has too many requests in-flight.
+		if clientID := r.Header.Get("X-Client-ID"); *clientLimit > 0 && clientID != "" {
+			if !clients.Receive(clientID) {
+				status = http.StatusTooManyRequests
+				shed(rw)
+				return
+			}
+			defer clients.Release(clientID)
+		}
+
+		okStatus := http.StatusOK
+		// Fail a request right away if an error status was chosen.
+		if mix != nil {
+			if okStatus = mix.Choose(); okStatus >= http.StatusBadRequest {
+				status = okStatus
+				rw.WriteHeader(status)
+				fmt.Fprint(rw, "💥\n")
+				return
+			}
+		}
+
+		// The body is consumed slowly, so the client gets backpressure once TCP buffers fill up.
+		if *slowRead > 0 {
+			if _, err := io.Copy(io.Discard, newThrottledReader(r.Context(), r.Body, *slowRead)); err != nil {
+				status = http.StatusBadRequest
+				rw.WriteHeader(status)
+				return
+			}
+		}
+
+		if faults.Fail() {
+			status = faults.errorStatus
+			injected = true
 			rw.WriteHeader(status)
-			fmt.Fprint(rw, "🐈\n")
+			fmt.Fprint(rw, "💉\n")
+			return
+		}
+
+		j := job{
+			result:     make(chan jobOutcome),
+			enqueued:   time.Now(),
+			workFactor: faults.WorkFactor(),
+		}
+		if deadline, ok := parseDeadline(r.Header.Get("X-Request-Deadline")); ok {
+			j.deadline = deadline
+		}
+		// wait responds once the job ended.
+		wait := func() {
+			switch <-j.result {
+			case jobDropped:
+				status = http.StatusTooManyRequests
+				shed(rw)
+			case jobExpired:
+				status = http.StatusGatewayTimeout
+				rw.WriteHeader(status)
+				fmt.Fprint(rw, "⌛\n")
+			default:
+				status = okStatus
+				// The server closes the connection since fewer bytes were written than declared.
+				if faults.Truncate() {
+					injected = true
+					rw.Header().Set("Content-Length", "1024")
+				}
+				rw.WriteHeader(status)
+				fmt.Fprint(rw, "🐈\n")
+			}
+		}
 		// Discard requests if workers are busy and queue is full.
-		default:
+		if !queueStats.Enqueue(jobs, j, grace.Active()) {
 			status = http.StatusTooManyRequests
-			rw.WriteHeader(status)
-			fmt.Fprint(rw, "🚦\n")
+			shed(rw)
+			return
 		}
+		wait()
 	})
-	go http.ListenAndServe(*addr, nil)
+	srv := http.Server{
+		Addr:        *addr,
+		ConnContext: withConnState,
+	}
+	if *maxConnsPerIP > 0 {
+		connsRejected := prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "origin_conns_rejected_total",
+			Help: "How many connections were closed because their client IP had -max-conns-per-ip connections open.",
+		})
+		prometheus.MustRegister(connsRejected)
+		srv.ConnState = newIPConnLimiter(*maxConnsPerIP, connsRejected).ConnState
+	}
+	go srv.ListenAndServe()
 
 	fmt.Printf("starting %d workers\n", *workerNum)
 	var wg sync.WaitGroup
 	for i := 0; i < *workerNum; i++ {
 		wg.Add(1)
 		go func(workerID int) {
-			for j := range jobs {
+			defer wg.Done()
+			pickups := pickupIntervals{
+				interval: pickupInterval.With(prometheus.Labels{"worker": fmt.Sprint(workerID)}),
+			}
+			for {
+				j, ok := jobs.Pop()
+				if !ok {
+					return
+				}
 				begun := time.Now()
-				time.Sleep(randDuration(*worktime))
-				j.result <- struct{}{}
-				fmt.Printf("worker #%d completed job in %v, %d left\n", workerID, time.Since(begun), len(jobs))
+				waited := begun.Sub(j.enqueued)
+				sojourn.Observe(waited.Seconds())
+				if codel != nil && codel.Drop(begun, waited) {
+					j.result <- jobDropped
+					continue
+				}
+				// The client has given up while the job was queued, so a worker isn't wasted on it.
+				if j.expiredBy(begun) {
+					expiredTotal.WithLabelValues("queued").Inc()
+					j.result <- jobExpired
+					continue
+				}
+				pickups.Pickup(begun)
+				work := time.Duration(j.workFactor * float64(worktimes.Sample()))
+				// The work is abandoned once the deadline passes.
+				if !j.do(work) {
+					busySeconds.Add(time.Since(begun).Seconds())
+					expiredTotal.WithLabelValues("processing").Inc()
+					j.result <- jobExpired
+					fmt.Printf("worker #%d abandoned job after %v, %d left\n", workerID, time.Since(begun), jobs.Len())
+					continue
+				}
+				busySeconds.Add(time.Since(begun).Seconds())
+				j.result <- jobDone
+				fmt.Printf("worker #%d completed job in %v, %d left\n", workerID, time.Since(begun), jobs.Len())
 			}
-			wg.Done()
 		}(i)
 	}
-	wg.Wait()
-}
 
-func randDuration(mean time.Duration) time.Duration {
-	return time.Duration(rand.NormFloat64() + float64(mean))
+	// On shutdown, in-flight requests are allowed to finish while new connections aren't accepted.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Fatalf("origin: failed to drain in-flight requests: %v", err)
+	}
+	// No handler can enqueue a job anymore, so workers are stopped once the queue is empty.
+	jobs.Close()
+	wg.Wait()
+	fmt.Println("workers stopped")
 }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestGracefulShutdown(t *testing.T) {
+	if testing.Short() {
+		t.Skip("origin binary is built and run")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command isn't found")
+	}
+	bin := filepath.Join(t.TempDir(), "origin")
+	if out, err := exec.Command(goBin, "build", "-o", bin, ".").CombinedOutput(); err != nil {
+		t.Fatalf("failed to build origin: %v\n%s", err, out)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	var out bytes.Buffer
+	cmd := exec.Command(bin, "-addr", addr, "-worker", "2", "-worktime", "300ms", "-shutdown-timeout", "5s")
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err = cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	// Connections aren't reused, otherwise the transport could leave a spare connection open
+	// which the server doesn't consider idle during shutdown since no request was sent over it.
+	client := http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	// The origin is ready once it serves metrics.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := client.Get("http://" + addr + "/metrics")
+		if err == nil {
+			resp.Body.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("origin didn't start: %v\n%s", err, out.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// SIGTERM arrives while the request is being processed.
+	status := make(chan int, 1)
+	go func() {
+		resp, err := client.Get("http://" + addr + "/")
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if err = cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = cmd.Wait(); err != nil {
+		t.Errorf("origin didn't exit cleanly: %v\n%s", err, out.String())
+	}
+	if s := <-status; s != http.StatusOK {
+		t.Errorf("in-flight request got status %d, want 200", s)
+	}
+	if got := out.String(); strings.Contains(got, "panic") || !strings.Contains(got, "workers stopped") {
+		t.Errorf("workers didn't stop cleanly:\n%s", got)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// otherLabelValue is reported instead of label values which exceeded the cardinality limit.
+const otherLabelValue = "other"
+
+// labelLimit caps the number of distinct values of a metric label
+// to protect Prometheus from cardinality explosion, e.g., when a label is a client ID.
+type labelLimit struct {
+	max int
+
+	mu   sync.Mutex
+	seen map[string]struct{}
+}
+
+// newLabelLimit creates a limit which allows max distinct label values.
+func newLabelLimit(max int) *labelLimit {
+	l := labelLimit{
+		max:  max,
+		seen: make(map[string]struct{}),
+	}
+	return &l
+}
+
+// Value returns v if it was seen before or the limit isn't reached yet, otherwise it returns "other".
+func (l *labelLimit) Value(v string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.seen[v]; ok {
+		return v
+	}
+	if len(l.seen) >= l.max {
+		return otherLabelValue
+	}
+	l.seen[v] = struct{}{}
+	return v
+}
+
+// limitLabels returns labels with values capped by their limits.
+func limitLabels(labels prometheus.Labels, limits map[string]*labelLimit) prometheus.Labels {
+	capped := make(prometheus.Labels, len(labels))
+	for name, v := range labels {
+		if l, ok := limits[name]; ok {
+			v = l.Value(v)
+		}
+		capped[name] = v
+	}
+	return capped
+}
+
+// boundedCounterVec is a CounterVec with capped cardinality of its labels.
+type boundedCounterVec struct {
+	*prometheus.CounterVec
+	limits map[string]*labelLimit
+}
+
+// With returns a counter for the given labels where overflowing label values are replaced with "other".
+func (v *boundedCounterVec) With(labels prometheus.Labels) prometheus.Counter {
+	return v.CounterVec.With(limitLabels(labels, v.limits))
+}
+
+// boundedHistogramVec is a HistogramVec with capped cardinality of its labels.
+type boundedHistogramVec struct {
+	*prometheus.HistogramVec
+	limits map[string]*labelLimit
+}
+
+// With returns an observer for the given labels where overflowing label values are replaced with "other".
+func (v *boundedHistogramVec) With(labels prometheus.Labels) prometheus.Observer {
+	return v.HistogramVec.With(limitLabels(labels, v.limits))
+}
+
+// parseBuckets parses a comma-separated list of histogram buckets in seconds, e.g., 0.01,0.1,1.
+func parseBuckets(spec string) ([]float64, error) {
+	var bb []float64
+	for _, s := range strings.Split(spec, ",") {
+		b, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil || b <= 0 {
+			return nil, fmt.Errorf("bucket %q: want positive number of seconds", s)
+		}
+		bb = append(bb, b)
+	}
+	if !sort.Float64sAreSorted(bb) {
+		return nil, fmt.Errorf("buckets %q: must be listed in increasing order", spec)
+	}
+	return bb, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLabelLimit(t *testing.T) {
+	l := newLabelLimit(3)
+	for i := 0; i < 3; i++ {
+		if v := l.Value(strconv.Itoa(i)); v != strconv.Itoa(i) {
+			t.Errorf("value %d became %q before the limit", i, v)
+		}
+	}
+	if v := l.Value("3"); v != otherLabelValue {
+		t.Errorf("the 4th distinct value became %q, want %q", v, otherLabelValue)
+	}
+	// Values seen before the limit was reached are kept.
+	if v := l.Value("1"); v != "1" {
+		t.Errorf("seen value became %q, want 1", v)
+	}
+}
+
+func TestBoundedCounterVec(t *testing.T) {
+	v := &boundedCounterVec{
+		CounterVec: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests_total"}, []string{"status", "method"}),
+		limits: map[string]*labelLimit{
+			"status": newLabelLimit(2),
+		},
+	}
+	for _, status := range []string{"200", "500", "503", "504", "200"} {
+		v.With(prometheus.Labels{"status": status, "method": "GET"}).Inc()
+	}
+
+	want := map[string]float64{"200": 2, "500": 1, otherLabelValue: 2}
+	if n := testutil.CollectAndCount(v); n != len(want) {
+		t.Errorf("%d series, want %d", n, len(want))
+	}
+	for status, count := range want {
+		if got := testutil.ToFloat64(v.CounterVec.WithLabelValues(status, "GET")); got != count {
+			t.Errorf("status %s counted %v, want %v", status, got, count)
+		}
+	}
+}
+
+func TestBoundedHistogramVec(t *testing.T) {
+	v := &boundedHistogramVec{
+		HistogramVec: prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "interval_seconds"}, []string{"worker"}),
+		limits: map[string]*labelLimit{
+			"worker": newLabelLimit(2),
+		},
+	}
+	for i := 0; i < 5; i++ {
+		v.With(prometheus.Labels{"worker": strconv.Itoa(i)}).Observe(1)
+	}
+
+	if n := testutil.CollectAndCount(v); n != 3 {
+		t.Errorf("%d series, want 3: worker 0, 1, and other", n)
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// pickupIntervals observes time between successive job pickups by a worker.
+type pickupIntervals struct {
+	interval prometheus.Observer
+	// last is when the worker picked up its previous job, zero means it hasn't picked up any.
+	last time.Time
+}
+
+// Pickup records that the worker picked up a job at the given time.
+func (p *pickupIntervals) Pickup(at time.Time) {
+	if !p.last.IsZero() {
+		p.interval.Observe(at.Sub(p.last).Seconds())
+	}
+	p.last = at
+}
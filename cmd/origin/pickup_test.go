@@ -0,0 +1,50 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/marselester/capacity/internal/derive"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestPickupIntervals(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "pickup_interval_seconds",
+		Buckets: []float64{0.5, 1, 1.5},
+	})
+	reg.MustRegister(h)
+
+	// A load generator bottlenecked by coordinated omission sends evenly spaced requests.
+	p := pickupIntervals{interval: h}
+	begun := time.Now()
+	for _, at := range []time.Duration{0, time.Second, 2 * time.Second, 3 * time.Second, 3500 * time.Millisecond} {
+		p.Pickup(begun.Add(at))
+	}
+
+	tt, err := derive.Gather(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first pickup has no predecessor, so it's not an interval.
+	count, sum := tt.Sum("pickup_interval_seconds", nil)
+	if count != 4 {
+		t.Errorf("observed %v intervals, want 4", count)
+	}
+	if math.Abs(sum-3.5) > 1e-9 {
+		t.Errorf("intervals sum %v, want 3.5", sum)
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[float64]uint64{0.5: 1, 1: 4, 1.5: 4}
+	for _, b := range mfs[0].GetMetric()[0].GetHistogram().GetBucket() {
+		if got := b.GetCumulativeCount(); got != want[b.GetUpperBound()] {
+			t.Errorf("bucket le=%v has %d intervals, want %d", b.GetUpperBound(), got, want[b.GetUpperBound()])
+		}
+	}
+}
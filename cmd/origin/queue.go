@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// jobQueue is a queue of jobs waiting for workers.
+type jobQueue interface {
+	// TryPush enqueues a job unless the queue is full.
+	TryPush(j job) bool
+	// Push enqueues a job waiting for a room in the queue.
+	Push(j job)
+	// Pop dequeues a job waiting for one if the queue is empty.
+	// It returns false once the queue is closed and empty.
+	Pop() (job, bool)
+	// Close stops workers once the queue is empty.
+	Close()
+	// Len returns how many jobs wait in the queue.
+	Len() int
+	// Cap returns how many jobs can wait in the queue.
+	Cap() int
+}
+
+// newJobQueue creates a queue of size jobs served in the order of the discipline: fifo or lifo.
+func newJobQueue(discipline string, size int) (jobQueue, error) {
+	switch discipline {
+	case "fifo":
+		return fifoQueue(make(chan job, size)), nil
+	case "lifo":
+		return newLIFOQueue(size), nil
+	}
+	return nil, fmt.Errorf("unknown queue discipline %q: want fifo or lifo", discipline)
+}
+
+// startupGrace is a period after origin started when requests aren't discarded,
+// they wait for a room in the queue instead while workers warm up.
+type startupGrace struct {
+	started time.Time
+	period  time.Duration
+}
+
+// Active reports whether the grace period hasn't ended yet.
+func (g startupGrace) Active() bool {
+	return time.Since(g.started) < g.period
+}
+
+// enqueue pushes a job to the queue unless it's full, or waits for a room in the queue during grace period.
+func enqueue(q jobQueue, j job, grace bool) bool {
+	if grace {
+		q.Push(j)
+		return true
+	}
+	return q.TryPush(j)
+}
+
+// queueMetrics quantify shedding: how often the queue was full and how deep it was when jobs were enqueued.
+type queueMetrics struct {
+	full   prometheus.Counter
+	depth  prometheus.Gauge
+	depths prometheus.Observer
+}
+
+// Enqueue enqueues a job like enqueue does and records the outcome.
+func (m queueMetrics) Enqueue(q jobQueue, j job, grace bool) bool {
+	if !enqueue(q, j, grace) {
+		m.full.Inc()
+		return false
+	}
+	depth := float64(q.Len())
+	m.depth.Set(depth)
+	m.depths.Observe(depth)
+	return true
+}
+
+// depthBuckets split a queue of the given size into tenths (at least one job wide),
+// so it's seen how full the queue typically is.
+func depthBuckets(size int) []float64 {
+	step := float64(size) / 10
+	if step < 1 {
+		step = 1
+	}
+	return prometheus.LinearBuckets(0, step, 11)
+}
+
+// fifoQueue serves the oldest jobs first.
+type fifoQueue chan job
+
+func (q fifoQueue) TryPush(j job) bool {
+	select {
+	case q <- j:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q fifoQueue) Push(j job) {
+	q <- j
+}
+
+func (q fifoQueue) Pop() (job, bool) {
+	j, ok := <-q
+	return j, ok
+}
+
+func (q fifoQueue) Close() {
+	close(q)
+}
+
+func (q fifoQueue) Len() int {
+	return len(q)
+}
+
+func (q fifoQueue) Cap() int {
+	return cap(q)
+}
+
+// lifoQueue serves the newest jobs first,
+// so that under overload workers don't waste time on jobs whose clients likely gave up.
+type lifoQueue struct {
+	mu sync.Mutex
+	// changed is signalled when a job is pushed or popped, or the queue is closed.
+	changed *sync.Cond
+	jobs    []job
+	size    int
+	// idle is how many workers wait for a job.
+	// Like an unbuffered channel, the queue accepts a job without a room when a worker is idle.
+	idle   int
+	closed bool
+}
+
+func newLIFOQueue(size int) *lifoQueue {
+	q := lifoQueue{size: size}
+	q.changed = sync.NewCond(&q.mu)
+	return &q
+}
+
+func (q *lifoQueue) TryPush(j job) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.jobs) >= q.size+q.idle {
+		return false
+	}
+	q.jobs = append(q.jobs, j)
+	q.changed.Broadcast()
+	return true
+}
+
+func (q *lifoQueue) Push(j job) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.jobs) >= q.size+q.idle {
+		q.changed.Wait()
+	}
+	q.jobs = append(q.jobs, j)
+	q.changed.Broadcast()
+}
+
+func (q *lifoQueue) Pop() (job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.idle++
+	for len(q.jobs) == 0 && !q.closed {
+		q.changed.Wait()
+	}
+	q.idle--
+	if len(q.jobs) == 0 {
+		return job{}, false
+	}
+
+	j := q.jobs[len(q.jobs)-1]
+	q.jobs = q.jobs[:len(q.jobs)-1]
+	q.changed.Broadcast()
+	return j, true
+}
+
+func (q *lifoQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.changed.Broadcast()
+}
+
+func (q *lifoQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.jobs)
+}
+
+func (q *lifoQueue) Cap() int {
+	return q.size
+}
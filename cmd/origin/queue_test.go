@@ -0,0 +1,230 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/marselester/capacity/internal/derive"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestEnqueueStartupGrace(t *testing.T) {
+	grace := startupGrace{started: time.Now(), period: time.Hour}
+	if !grace.Active() {
+		t.Fatal("grace period is over right after start")
+	}
+
+	// A slow worker can't keep up with the burst, so the queue of one job overflows.
+	jobs, err := newJobQueue("fifo", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			if _, ok := jobs.Pop(); !ok {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	defer jobs.Close()
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		shed int
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !enqueue(jobs, job{}, grace.Active()) {
+				mu.Lock()
+				shed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if shed != 0 {
+		t.Errorf("%d jobs were discarded during grace period", shed)
+	}
+
+	// Once grace period is over, a job is discarded when the queue is full.
+	grace.started = grace.started.Add(-2 * time.Hour)
+	if grace.Active() {
+		t.Fatal("grace period hasn't ended")
+	}
+	full, _ := newJobQueue("fifo", 1)
+	full.TryPush(job{})
+	if enqueue(full, job{}, grace.Active()) {
+		t.Error("job was enqueued into a full queue after grace period")
+	}
+}
+
+func TestQueueMetricsFull(t *testing.T) {
+	m := queueMetrics{
+		full:   prometheus.NewCounter(prometheus.CounterOpts{Name: "full"}),
+		depth:  prometheus.NewGauge(prometheus.GaugeOpts{Name: "depth"}),
+		depths: prometheus.NewHistogram(prometheus.HistogramOpts{Name: "depths"}),
+	}
+	// Nobody works on the jobs, so the queue of three jobs is saturated.
+	jobs, err := newJobQueue("fifo", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 5; i++ {
+		enqueued := m.Enqueue(jobs, job{}, false)
+		if want := i <= 3; enqueued != want {
+			t.Fatalf("job %d: enqueued %t, want %t", i, enqueued, want)
+		}
+	}
+
+	if got := testutil.ToFloat64(m.full); got != 2 {
+		t.Errorf("queue was full %v times, want 2", got)
+	}
+	if got := testutil.ToFloat64(m.depth); got != 3 {
+		t.Errorf("depth %v, want 3", got)
+	}
+}
+
+func TestQueueDiscipline(t *testing.T) {
+	tests := map[string][]float64{
+		"fifo": {0, 1, 2, 3, 4},
+		"lifo": {0, 4, 3, 2, 1},
+	}
+	for discipline, want := range tests {
+		t.Run(discipline, func(t *testing.T) {
+			jobs, err := newJobQueue(discipline, 4)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The worker stalls on the first job, jobs are told apart by their work factor.
+			stall := make(chan struct{})
+			done := make(chan float64, 5)
+			go func() {
+				for {
+					j, ok := jobs.Pop()
+					if !ok {
+						close(done)
+						return
+					}
+					<-stall
+					done <- j.workFactor
+				}
+			}()
+			if !jobs.TryPush(job{workFactor: 0}) {
+				t.Fatal("first job wasn't enqueued")
+			}
+			deadline := time.Now().Add(time.Second)
+			for jobs.Len() > 0 {
+				if time.Now().After(deadline) {
+					t.Fatal("worker didn't pick up the first job")
+				}
+				time.Sleep(time.Millisecond)
+			}
+			for i := 1; i <= 4; i++ {
+				if !jobs.TryPush(job{workFactor: float64(i)}) {
+					t.Fatalf("job %d wasn't enqueued", i)
+				}
+			}
+			if jobs.TryPush(job{workFactor: 5}) {
+				t.Error("job was enqueued into a full queue")
+			}
+
+			close(stall)
+			jobs.Close()
+			var got []float64
+			for f := range done {
+				got = append(got, f)
+			}
+			if len(got) != len(want) {
+				t.Fatalf("completed %v, want %v", got, want)
+			}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Fatalf("completed %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewJobQueueError(t *testing.T) {
+	if _, err := newJobQueue("random", 1); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestDepthBuckets(t *testing.T) {
+	tests := map[int][]float64{
+		20: {0, 2, 4, 6, 8, 10, 12, 14, 16, 18, 20},
+		5:  {0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+	}
+	for size, want := range tests {
+		got := depthBuckets(size)
+		if len(got) != len(want) {
+			t.Fatalf("queue %d: got %v, want %v", size, got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("queue %d: got %v, want %v", size, got, want)
+			}
+		}
+	}
+}
+
+func TestQueueMetricsSustainedLoad(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := queueMetrics{
+		full:  prometheus.NewCounter(prometheus.CounterOpts{Name: "full"}),
+		depth: prometheus.NewGauge(prometheus.GaugeOpts{Name: "depth"}),
+		depths: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "enqueue_depth",
+			Buckets: depthBuckets(20),
+		}),
+	}
+	reg.MustRegister(m.depths.(prometheus.Histogram))
+
+	// Jobs arrive twice as fast as the worker takes them.
+	jobs, err := newJobQueue("fifo", 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		m.Enqueue(jobs, job{}, false)
+		m.Enqueue(jobs, job{}, false)
+		jobs.Pop()
+	}
+
+	tt, err := derive.Gather(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, sum := tt.Sum("enqueue_depth", nil)
+	if enqueued := 400 - testutil.ToFloat64(m.full); count != enqueued {
+		t.Errorf("observed %v depths, want %v enqueued jobs", count, enqueued)
+	}
+	// The queue stays nearly full once the load has built it up.
+	if mean := sum / count; mean < 18 {
+		t.Errorf("mean depth %.1f, want the queue of 20 to be nearly full", mean)
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var belowFull uint64
+	for _, b := range mfs[0].GetMetric()[0].GetHistogram().GetBucket() {
+		if b.GetUpperBound() == 18 {
+			belowFull = b.GetCumulativeCount()
+		}
+	}
+	// Only the jobs enqueued while the queue was building up (two per depth) saw it at most 90% full.
+	if belowFull > 36 {
+		t.Errorf("%d of %v jobs saw at most 18 jobs in the queue, want at most 36", belowFull, count)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// shedStats describes how busy origin was when it discarded a request.
+type shedStats struct {
+	QueueLength   int `json:"queue_length"`
+	QueueCapacity int `json:"queue_capacity"`
+	Workers       int `json:"workers"`
+}
+
+// writeShed responds to a discarded request with 429.
+// When verbose, the response tells a client how busy origin is: stats are taken from the job queue at rejection time.
+func writeShed(rw http.ResponseWriter, verbose bool, jobs jobQueue, workers int) {
+	if !verbose {
+		rw.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(rw, "🚦\n")
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(rw).Encode(shedStats{
+		QueueLength:   jobs.Len(),
+		QueueCapacity: jobs.Cap(),
+		Workers:       workers,
+	})
+}
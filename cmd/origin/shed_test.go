@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteShed(t *testing.T) {
+	jobs, err := newJobQueue("fifo", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		jobs.TryPush(job{})
+	}
+
+	rec := httptest.NewRecorder()
+	writeShed(rec, true, jobs, 7)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("status %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("content type %q, want application/json", ct)
+	}
+	var got shedStats
+	if err = json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := shedStats{QueueLength: 3, QueueCapacity: 5, Workers: 7}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	writeShed(rec, false, jobs, 7)
+	if rec.Code != http.StatusTooManyRequests || rec.Body.String() != "🚦\n" {
+		t.Errorf("got %d %q, want a terse 429", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// throttledReader reads no faster than its limiter allows,
+// e.g., to apply TCP backpressure to a client uploading a request body.
+type throttledReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+// newThrottledReader creates a reader of r limited to bytesPerSec.
+// It stops waiting for the limiter when ctx is done.
+func newThrottledReader(ctx context.Context, r io.Reader, bytesPerSec int) *throttledReader {
+	// A small burst keeps reads smooth rather than letting a large chunk through at once.
+	burst := 1024
+	if bytesPerSec < burst {
+		burst = bytesPerSec
+	}
+	t := throttledReader{
+		ctx:     ctx,
+		r:       r,
+		limiter: rate.NewLimiter(rate.Limit(bytesPerSec), burst),
+	}
+	return &t
+}
+
+// Read reads up to the limiter's burst and waits until those bytes are allowed.
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > t.limiter.Burst() {
+		p = p[:t.limiter.Burst()]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if werr := t.limiter.WaitN(t.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestThrottledReader(t *testing.T) {
+	type upload struct {
+		n    int64
+		took time.Duration
+	}
+	uploads := make(chan upload, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		begun := time.Now()
+		n, _ := io.Copy(io.Discard, newThrottledReader(r.Context(), r.Body, 16*1024))
+		uploads <- upload{n: n, took: time.Since(begun)}
+	}))
+	defer srv.Close()
+
+	body := bytes.Repeat([]byte("🐈"), 2048)
+	resp, err := http.Post(srv.URL, "text/plain", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// The first 1KB burst is read right away, the rest 7KB take 7/16 of a second.
+	u := <-uploads
+	if u.n != int64(len(body)) {
+		t.Errorf("read %d bytes, want %d", u.n, len(body))
+	}
+	if u.took < 400*time.Millisecond || u.took > 2*time.Second {
+		t.Errorf("%d bytes were read in %v, want about 440ms at 16KB/s", u.n, u.took)
+	}
+}
+
+func TestThrottledReaderCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := newThrottledReader(ctx, bytes.NewReader(make([]byte, 4096)), 10)
+	if _, err := io.Copy(io.Discard, r); err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+}
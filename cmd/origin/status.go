@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// statusMix picks HTTP status codes at random according to their weights.
+type statusMix struct {
+	statuses []int
+	// cumWeights are cumulative weights of statuses, e.g., [90 95 100] for 200:90,500:5,503:5.
+	cumWeights []int
+}
+
+// parseStatusMix parses a comma-separated list of status:weight pairs, e.g., 200:90,500:5,503:5.
+func parseStatusMix(spec string) (*statusMix, error) {
+	var m statusMix
+	total := 0
+	for _, pair := range strings.Split(spec, ",") {
+		kv := strings.Split(strings.TrimSpace(pair), ":")
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("status mix %q: want status:weight", pair)
+		}
+
+		status, err := strconv.Atoi(kv[0])
+		if err != nil || status < 100 || status > 599 {
+			return nil, fmt.Errorf("status mix %q: invalid status code", pair)
+		}
+		weight, err := strconv.Atoi(kv[1])
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("status mix %q: invalid weight", pair)
+		}
+
+		total += weight
+		m.statuses = append(m.statuses, status)
+		m.cumWeights = append(m.cumWeights, total)
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("status mix %q: weights sum up to zero", spec)
+	}
+	return &m, nil
+}
+
+// Choose returns a random status code, e.g., 200 is chosen in 90% of cases with 200:90,500:5,503:5.
+func (m *statusMix) Choose() int {
+	n := rand.Intn(m.cumWeights[len(m.cumWeights)-1])
+	for i, w := range m.cumWeights {
+		if n < w {
+			return m.statuses[i]
+		}
+	}
+	return m.statuses[len(m.statuses)-1]
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestStatusMixDistribution(t *testing.T) {
+	m, err := parseStatusMix("200:90, 500:5,503:5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 100000
+	counts := make(map[int]int)
+	for i := 0; i < n; i++ {
+		counts[m.Choose()]++
+	}
+	want := map[int]float64{200: 0.9, 500: 0.05, 503: 0.05}
+	if len(counts) != len(want) {
+		t.Errorf("got statuses %v, want %v", counts, want)
+	}
+	for status, share := range want {
+		if got := float64(counts[status]) / n; math.Abs(got-share) > 0.01 {
+			t.Errorf("status %d share %.3f, want %.2f", status, got, share)
+		}
+	}
+}
+
+func TestParseStatusMixError(t *testing.T) {
+	tests := map[string]string{
+		"no weight":      "200",
+		"bad status":     "200:90,5000:10",
+		"negative":       "200:-1",
+		"zero weights":   "200:0,500:0",
+		"non-numeric":    "ok:1",
+		"too many parts": "200:1:2",
+	}
+	for name, spec := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseStatusMix(spec); err == nil {
+				t.Errorf("%q: expected an error", spec)
+			}
+		})
+	}
+}
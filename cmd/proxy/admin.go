@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/marselester/capacity"
+)
+
+// backoffHandler backs off quota to a fraction specified in a request body, e.g., 0.75.
+// It responds with the resulting target concurrency.
+func backoffHandler(q *capacity.Quota) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			rw.Header().Set("Allow", http.MethodPost)
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		b, err := io.ReadAll(io.LimitReader(r.Body, 64))
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p, err := strconv.ParseFloat(strings.TrimSpace(string(b)), 64)
+		if err != nil || p < 0 || p > 1 {
+			http.Error(rw, "backoff fraction must be a number in [0, 1]", http.StatusBadRequest)
+			return
+		}
+
+		q.Backoff(p)
+		fmt.Fprintln(rw, q.Max())
+	}
+}
+
+// increaseHandler lifts quota by one.
+// It responds with the resulting target concurrency.
+func increaseHandler(q *capacity.Quota) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			rw.Header().Set("Allow", http.MethodPost)
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		q.Inc()
+		fmt.Fprintln(rw, q.Max())
+	}
+}
+
+// backendsHandler responds with a JSON list of origins and their quotas, e.g., ["http://localhost:8000=5"].
+// The list of origins is replaced when a new list is posted.
+func backendsHandler(pool *backendPool) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var specs []string
+			if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&specs); err != nil {
+				http.Error(rw, "origins must be a JSON list of URLs", http.StatusBadRequest)
+				return
+			}
+			if err := pool.Update(specs); err != nil {
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			rw.Header().Set("Allow", "GET, POST")
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(pool.Specs())
+	}
+}
+
+// snapshotHandler responds with a JSON snapshot of capacity control state.
+func snapshotHandler(algorithm string, q *capacity.Quota, l Limiter, pool *backendPool) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			rw.Header().Set("Allow", http.MethodGet)
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(takeSnapshot(algorithm, q, l, pool))
+	}
+}
+
+// decisionsHandler responds with the most recent quota decisions in JSON, the oldest first.
+func decisionsHandler(l *capacity.DecisionLog) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			rw.Header().Set("Allow", http.MethodGet)
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(l.Decisions())
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marselester/capacity"
+)
+
+func TestBackoffHandler(t *testing.T) {
+	tests := map[string]struct {
+		method     string
+		body       string
+		wantStatus int
+		wantMax    int64
+	}{
+		"backoff":      {method: http.MethodPost, body: "0.5\n", wantStatus: http.StatusOK, wantMax: 5},
+		"get":          {method: http.MethodGet, wantStatus: http.StatusMethodNotAllowed, wantMax: 10},
+		"not a number": {method: http.MethodPost, body: "half", wantStatus: http.StatusBadRequest, wantMax: 10},
+		"above one":    {method: http.MethodPost, body: "1.5", wantStatus: http.StatusBadRequest, wantMax: 10},
+		"negative":     {method: http.MethodPost, body: "-0.5", wantStatus: http.StatusBadRequest, wantMax: 10},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := capacity.NewQuota(10)
+			rw := httptest.NewRecorder()
+			backoffHandler(q)(rw, httptest.NewRequest(tc.method, "/admin/backoff", strings.NewReader(tc.body)))
+
+			if rw.Code != tc.wantStatus {
+				t.Errorf("status %d, want %d", rw.Code, tc.wantStatus)
+			}
+			if q.Max() != tc.wantMax {
+				t.Errorf("max %d, want %d", q.Max(), tc.wantMax)
+			}
+			if tc.wantStatus == http.StatusOK && rw.Body.String() != "5\n" {
+				t.Errorf("body %q, want the resulting max", rw.Body.String())
+			}
+		})
+	}
+}
+
+func TestIncreaseHandler(t *testing.T) {
+	q := capacity.NewQuota(10)
+	h := increaseHandler(q)
+
+	rw := httptest.NewRecorder()
+	h(rw, httptest.NewRequest(http.MethodPost, "/admin/increase", nil))
+	if rw.Code != http.StatusOK || rw.Body.String() != "11\n" {
+		t.Errorf("got %d %q, want 200 with the resulting max", rw.Code, rw.Body.String())
+	}
+	if q.Max() != 11 {
+		t.Errorf("max %d, want 11", q.Max())
+	}
+
+	rw = httptest.NewRecorder()
+	h(rw, httptest.NewRequest(http.MethodGet, "/admin/increase", nil))
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status %d, want 405", rw.Code)
+	}
+	if q.Max() != 11 {
+		t.Errorf("max %d, want 11", q.Max())
+	}
+}
+
+func TestDecisionsHandler(t *testing.T) {
+	decisions := capacity.NewDecisionLog(3)
+	q := capacity.NewQuota(10, capacity.WithDecisionLog(decisions), capacity.WithFloor(4), capacity.WithCeiling(12))
+	begun := time.Now()
+	q.Inc()
+	q.Backoff(0.5)
+	q.IncN(10)
+	q.Backoff(0.1)
+
+	rw := httptest.NewRecorder()
+	decisionsHandler(decisions)(rw, httptest.NewRequest(http.MethodGet, "/admin/decisions", nil))
+	if ct := rw.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("content type %q, want application/json", ct)
+	}
+	var got []capacity.Decision
+	if err := json.NewDecoder(rw.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	// The oldest decision was evicted from the log of three.
+	want := []capacity.Decision{
+		{Action: "backoff", Trigger: "fraction=0.5", OldMax: 11, NewMax: 6},
+		{Action: "inc", Trigger: "step=10,ceiling", OldMax: 6, NewMax: 12},
+		{Action: "backoff", Trigger: "fraction=0.1,floor", OldMax: 12, NewMax: 4},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i].Time.Before(begun) || (i > 0 && got[i].Time.Before(got[i-1].Time)) {
+			t.Errorf("decision %d at %v is out of order", i, got[i].Time)
+		}
+		got[i].Time = time.Time{}
+		if got[i] != want[i] {
+			t.Errorf("decision %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	rw = httptest.NewRecorder()
+	decisionsHandler(decisions)(rw, httptest.NewRequest(http.MethodPost, "/admin/decisions", nil))
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status %d, want %d", rw.Code, http.StatusMethodNotAllowed)
+	}
+}
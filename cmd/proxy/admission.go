@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/marselester/capacity"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// breakerAdmission settles who wins when circuit breaker is open while the limiter has quota to spare.
+type breakerAdmission struct {
+	breaker *CircuitBreaker
+	// precedence is who wins a disagreement: breaker (fast-fail) or limiter (forward the request).
+	precedence string
+	// disagreements counts disagreements partitioned by who won.
+	disagreements *prometheus.CounterVec
+}
+
+// Allow reports whether request r to quota q is let through the circuit breaker.
+// It returns the request with a breaker ticket attached.
+// Requests forwarded against the breaker have no ticket, so their outcomes don't settle the circuit.
+func (a breakerAdmission) Allow(r *http.Request, q *capacity.Quota) (*http.Request, bool) {
+	ticket, ok := a.breaker.Allow()
+	r = r.WithContext(withBreakerTicket(r.Context(), ticket))
+	if ok {
+		return r, true
+	}
+
+	disagree := q.Used() < q.Max()
+	if disagree {
+		a.disagreements.WithLabelValues(a.precedence).Inc()
+	}
+	return r, disagree && a.precedence == "limiter"
+}
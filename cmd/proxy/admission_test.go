@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/marselester/capacity"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// openBreaker returns a circuit breaker which has just opened.
+func openBreaker(t *testing.T) *CircuitBreaker {
+	t.Helper()
+
+	b := NewCircuitBreaker(1, 0, time.Minute, nil)
+	ticket, _ := b.Allow()
+	b.Record(ticket, false)
+	if s := b.State(); s != "open" {
+		t.Fatalf("state %s, want open", s)
+	}
+	return b
+}
+
+func TestBreakerAdmissionPrecedence(t *testing.T) {
+	tests := map[string]struct {
+		precedence   string
+		used         int64
+		want         bool
+		wantDisagree float64
+	}{
+		"breaker wins":        {precedence: "breaker", used: 1, want: false, wantDisagree: 1},
+		"limiter wins":        {precedence: "limiter", used: 1, want: true, wantDisagree: 1},
+		"limiter has no room": {precedence: "limiter", used: 10, want: false},
+		"breaker agrees":      {precedence: "breaker", used: 10, want: false},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			a := breakerAdmission{
+				breaker:       openBreaker(t),
+				precedence:    tc.precedence,
+				disagreements: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "disagreements"}, []string{"winner"}),
+			}
+			q := capacity.NewQuota(10)
+			for i := int64(0); i < tc.used; i++ {
+				q.Receive()
+			}
+
+			r, ok := a.Allow(httptest.NewRequest(http.MethodGet, "/", nil), q)
+			if ok != tc.want {
+				t.Errorf("allowed %t, want %t", ok, tc.want)
+			}
+			if got := testutil.ToFloat64(a.disagreements.WithLabelValues(tc.precedence)); got != tc.wantDisagree {
+				t.Errorf("disagreements %v, want %v", got, tc.wantDisagree)
+			}
+			// A request forwarded against the breaker doesn't settle the circuit.
+			if ticket := breakerTicketFrom(r.Context()); ticket != 0 {
+				t.Errorf("ticket %d, want none", ticket)
+			}
+		})
+	}
+}
+
+func TestBreakerAdmissionClosed(t *testing.T) {
+	a := breakerAdmission{
+		breaker:       NewCircuitBreaker(10, 0.5, time.Minute, nil),
+		precedence:    "breaker",
+		disagreements: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "disagreements"}, []string{"winner"}),
+	}
+	r, ok := a.Allow(httptest.NewRequest(http.MethodGet, "/", nil), capacity.NewQuota(10))
+	if !ok {
+		t.Error("closed circuit didn't allow a request")
+	}
+	if ticket := breakerTicketFrom(r.Context()); ticket == 0 {
+		t.Error("allowed request has no ticket")
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/marselester/capacity"
+)
+
+// admitHandler proxies a request to origin if the proxy's overload protection allows it:
+// origin isn't struggling with in-flight requests, circuit breaker is closed,
+// the limiter admits the request, the request isn't shed, and there is quota for it.
+type admitHandler struct {
+	// classOf returns quota and limiter of a request.
+	classOf func(*http.Request) (*capacity.Quota, Limiter)
+	// receive admits a request into quota, possibly waiting in a queue.
+	receive func(context.Context, *capacity.Quota) bool
+	events  quotaEvents
+	// minSlotHold is the least time a quota slot is held, see releaseHeld.
+	minSlotHold time.Duration
+	// expectContinue defers acquiring quota for requests with Expect: 100-continue header
+	// until origin agrees to receive their bodies.
+	expectContinue bool
+
+	// ages tracks when requests were forwarded,
+	// and maxOldestAge is how long the oldest of them can be in-flight, 0 means no limit.
+	ages         *inflightAges
+	maxOldestAge time.Duration
+	// admission lets requests through circuit breaker, the breaker is nil when it's disabled.
+	admission breakerAdmission
+	// shedder drops less important requests first, nil means nothing is shed.
+	shedder *weightedShedder
+
+	balancer Balancer
+	backends *backendPool
+	// proxy forwards a request to the backend attached to its context.
+	proxy http.Handler
+
+	// problems writes application/problem+json bodies, nil means a plain body.
+	problems *problemWriter
+	// unavailable responds when all origins are ejected, reject responds when a request isn't admitted.
+	unavailable http.HandlerFunc
+	reject      http.HandlerFunc
+}
+
+// ServeHTTP proxies a request to origin or rejects it.
+func (h *admitHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	// A request stuck in-flight for too long indicates that origin is already struggling.
+	if h.ages.Exceeds(h.maxOldestAge) {
+		if h.problems != nil {
+			h.problems.Write(rw, http.StatusServiceUnavailable, "origin-struggling", "Origin is slow to complete in-flight requests.")
+			return
+		}
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(rw, "🐢\n")
+		return
+	}
+
+	// Origin is given a break when too many recent requests failed,
+	// unless the limiter takes precedence and it still has quota to spare.
+	if h.admission.breaker != nil {
+		q, _ := h.classOf(r)
+		var ok bool
+		if r, ok = h.admission.Allow(r, q); !ok {
+			if h.problems != nil {
+				h.problems.Write(rw, http.StatusServiceUnavailable, "circuit-open", "Too many recent requests to origin failed.")
+				return
+			}
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(rw, "🔌\n")
+			return
+		}
+	}
+
+	q, l := h.classOf(r)
+	if a, ok := l.(admitter); ok && !a.Admit(r.Context()) {
+		h.reject(rw, r)
+		return
+	}
+	// Less important requests are dropped first as quota is about to run out.
+	if h.shedder != nil && h.shedder.Shed(r, q) {
+		h.reject(rw, r)
+		return
+	}
+
+	// Origin is asked whether it accepts a request body before quota is acquired.
+	// Note, the transport waits for 100 Continue from origin no longer than a second,
+	// then it sends the body anyway.
+	if h.expectContinue && expectsContinue(r) {
+		var acquired time.Time
+		body := &continueBody{
+			ReadCloser: r.Body,
+			acquire: func() bool {
+				acquired = time.Now()
+				return receiveQuota(h.events, r, q, h.receive)
+			},
+			release: func() {
+				releaseQuota(h.events, r, q, acquired, h.minSlotHold)
+			},
+		}
+		r.Body = body
+		h.pick(rw, r)
+		body.Release()
+		return
+	}
+
+	if !receiveQuota(h.events, r, q, h.receive) {
+		h.reject(rw, r)
+		return
+	}
+	acquired := time.Now()
+	h.pick(rw, r)
+	releaseQuota(h.events, r, q, acquired, h.minSlotHold)
+}
+
+// pick forwards a request to a backend chosen by the balancer,
+// or responds without origin when no backend can accept the request.
+func (h *admitHandler) pick(rw http.ResponseWriter, r *http.Request) {
+	switch b := h.balancer.Pick(); {
+	case b != nil:
+		h.forward(rw, r, b)
+	case allEjected(h.backends.Backends()):
+		h.unavailable(rw, r)
+	default:
+		h.reject(rw, r)
+	}
+}
+
+// forward proxies a request to backend b.
+func (h *admitHandler) forward(rw http.ResponseWriter, r *http.Request, b *backend) {
+	ctx := withBackend(r.Context(), b)
+	ctx = withStart(ctx, time.Now())
+	ctx = withFirstByteTrace(ctx)
+	e := h.ages.Add()
+	h.proxy.ServeHTTP(rw, r.WithContext(ctx))
+	h.ages.Remove(e)
+	b.quota.Release()
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/marselester/capacity"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// backend is an origin server where requests are proxied to.
+type backend struct {
+	url *url.URL
+	// director rewrites a request to be sent to the backend.
+	director func(*http.Request)
+	// quota limits in-flight requests to the backend.
+	quota *capacity.Quota
+	// rtt observes round-trip times of requests sent to the backend including response bodies.
+	rtt prometheus.Observer
+	// ttfb observes time to the first byte of the backend's responses.
+	ttfb prometheus.Observer
+	// outlier ejects the backend from balancing when it keeps failing.
+	outlier *outlierDetector
+	// added is when the backend was added to the pool, it's zero for the initial backends.
+	added time.Time
+	// warmup is how long the backend's share of quota ramps up after it was added or re-admitted.
+	warmup time.Duration
+}
+
+// receive admits a request into the backend's quota unless the backend is ejected.
+// A warming up backend admits only a fraction of its quota.
+func (b *backend) receive() bool {
+	if b.outlier.Ejected() {
+		return false
+	}
+	if w := b.weight(); w < 1 && float64(b.quota.Used()) >= math.Max(1, w*float64(b.quota.Max())) {
+		return false
+	}
+	return b.quota.Receive()
+}
+
+// weight returns a fraction of quota (0 < weight <= 1) the backend can use.
+// It grows linearly during warmup since the backend was added or re-admitted after ejection,
+// so a cold backend isn't overwhelmed with a full load right away.
+func (b *backend) weight() float64 {
+	if b.warmup == 0 {
+		return 1
+	}
+
+	since := b.added
+	if t := b.outlier.EjectedUntil(); t.After(since) {
+		since = t
+	}
+	if since.IsZero() {
+		return 1
+	}
+	w := float64(time.Since(since)) / float64(b.warmup)
+	if w > 1 {
+		return 1
+	}
+	return w
+}
+
+// backendMetrics are metrics of backends partitioned by backend label.
+type backendMetrics struct {
+	rtt            *prometheus.HistogramVec
+	ttfb           *prometheus.HistogramVec
+	inflight       *prometheus.GaugeVec
+	targetInflight *prometheus.GaugeVec
+}
+
+// parseBackend parses an origin URL which can be followed by its quota, e.g., http://localhost:8000=10.
+// If quota isn't specified, it's zero.
+// An origin URL with a query or fragment can't be followed by a quota,
+// so that a query value such as http://localhost:8000/?v=2 isn't mistaken for it.
+func parseBackend(spec string) (*url.URL, int64, error) {
+	spec = strings.TrimSpace(spec)
+	var quota int64
+	if i := strings.LastIndex(spec, "="); i != -1 && !strings.ContainsAny(spec[:i], "?#") {
+		if q, err := strconv.ParseInt(spec[i+1:], 10, 64); err == nil {
+			if q < 1 {
+				return nil, 0, fmt.Errorf("origin %q quota must be positive", spec)
+			}
+			spec, quota = spec[:i], q
+		}
+	}
+
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to parse origin url: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, 0, fmt.Errorf("origin url %q must have scheme and host", spec)
+	}
+	return u, quota, nil
+}
+
+// Balancer picks a backend to proxy a request to.
+type Balancer interface {
+	// Pick returns a backend which admitted a request into its quota,
+	// or nil if none of the backends can accept the request, e.g., they are ejected.
+	// The caller must release the backend's quota once the request is done.
+	Pick() *backend
+}
+
+// roundRobin picks backends in turn skipping those which are ejected or whose quota is exhausted.
+type roundRobin struct {
+	pool *backendPool
+	next uint64
+}
+
+// Next returns the next backend regardless of its quota.
+func (rr *roundRobin) Next() *backend {
+	backends := rr.pool.Backends()
+	n := atomic.AddUint64(&rr.next, 1) - 1
+	return backends[n%uint64(len(backends))]
+}
+
+// Pick returns the next backend which isn't ejected and has quota available.
+func (rr *roundRobin) Pick() *backend {
+	backends := rr.pool.Backends()
+	n := atomic.AddUint64(&rr.next, 1) - 1
+	for i := range backends {
+		b := backends[(n+uint64(i))%uint64(len(backends))]
+		if b.receive() {
+			return b
+		}
+	}
+	return nil
+}
+
+// leastConn picks a backend with the lowest ratio of in-flight requests to its quota,
+// so that less busy backends get more requests.
+type leastConn struct {
+	pool *backendPool
+}
+
+// Pick returns the least loaded backend which isn't ejected and has quota available.
+func (lc *leastConn) Pick() *backend {
+	backends := lc.pool.Backends()
+	tried := make([]bool, len(backends))
+	for range backends {
+		best := -1
+		var bestLoad float64
+		for i, b := range backends {
+			if tried[i] {
+				continue
+			}
+
+			used, max := b.quota.Used(), b.quota.Max()
+			if used >= max || b.outlier.Ejected() {
+				tried[i] = true
+				continue
+			}
+			if load := float64(used) / float64(max); best == -1 || load < bestLoad {
+				best, bestLoad = i, load
+			}
+		}
+		if best == -1 {
+			return nil
+		}
+
+		// Quota could have been taken by a concurrent request since the load was checked.
+		if b := backends[best]; b.receive() {
+			return b
+		}
+		tried[best] = true
+	}
+	return nil
+}
+
+// backendKey is a context key of a backend chosen for a request.
+type backendKey struct{}
+
+// withBackend returns a copy of ctx with the backend b.
+func withBackend(ctx context.Context, b *backend) context.Context {
+	return context.WithValue(ctx, backendKey{}, b)
+}
+
+// backendFrom returns a backend chosen for a request with the given ctx.
+func backendFrom(ctx context.Context) *backend {
+	b, _ := ctx.Value(backendKey{}).(*backend)
+	return b
+}
+
+// allEjected reports whether none of the backends can receive requests because they are ejected.
+func allEjected(bb []*backend) bool {
+	for _, b := range bb {
+		if !b.outlier.Ejected() {
+			return false
+		}
+	}
+	return true
+}
+
+// direct rewrites a request to be sent to its chosen backend.
+// It is meant to be used as httputil.ReverseProxy.Director.
+func direct(r *http.Request) {
+	backendFrom(r.Context()).director(r)
+}
+
+// powerOfTwo picks two random backends and chooses the one with fewer in-flight requests.
+// It's nearly as effective as least-connections without checking all the backends.
+type powerOfTwo struct {
+	pool *backendPool
+}
+
+// Pick returns the less loaded of two random backends which isn't ejected and has quota available.
+// When both are exhausted, the rest of the backends are tried in turn.
+func (p *powerOfTwo) Pick() *backend {
+	backends := p.pool.Backends()
+	n := len(backends)
+	i := rand.Intn(n)
+	j := i
+	if n > 1 {
+		// The second choice is distinct from the first one.
+		j = (i + 1 + rand.Intn(n-1)) % n
+	}
+
+	first, second := backends[i], backends[j]
+	if second.quota.Used() < first.quota.Used() {
+		first, second = second, first
+	}
+	if first.receive() {
+		return first
+	}
+	if second.receive() {
+		return second
+	}
+
+	for k := 1; k < n; k++ {
+		if b := backends[(i+k)%n]; b != second && b.receive() {
+			return b
+		}
+	}
+	return nil
+}
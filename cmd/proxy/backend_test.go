@@ -0,0 +1,231 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/marselester/capacity/internal/derive"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// newTestMetrics creates backend metrics which aren't registered.
+func newTestMetrics() backendMetrics {
+	return backendMetrics{
+		rtt:            prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "rtt"}, []string{"backend"}),
+		ttfb:           prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "ttfb"}, []string{"backend"}),
+		inflight:       prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "inflight"}, []string{"backend"}),
+		targetInflight: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "target"}, []string{"backend"}),
+	}
+}
+
+// newTestPool creates a pool of origins whose metrics aren't registered.
+// Backends are ejected after the given number of consecutive failures, 0 means never.
+func newTestPool(t *testing.T, quota int64, ejectAfter int, specs ...string) *backendPool {
+	t.Helper()
+
+	p := newBackendPool(quota, newTestMetrics(), ejectionPolicy{
+		failures: ejectAfter,
+		baseTime: time.Minute,
+		maxTime:  time.Hour,
+	}, 0)
+	if err := p.Update(specs); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestParseBackend(t *testing.T) {
+	tests := map[string]struct {
+		spec  string
+		url   string
+		quota int64
+	}{
+		"no quota":         {spec: "http://localhost:8000", url: "http://localhost:8000"},
+		"quota":            {spec: "http://localhost:8000=10", url: "http://localhost:8000", quota: 10},
+		"path and quota":   {spec: " http://localhost:8000/api=3 ", url: "http://localhost:8000/api", quota: 3},
+		"query":            {spec: "http://localhost:8000/?v=2", url: "http://localhost:8000/?v=2"},
+		"query and equals": {spec: "http://localhost:8000/?v=2=5", url: "http://localhost:8000/?v=2=5"},
+		"fragment":         {spec: "http://localhost:8000/#v=2", url: "http://localhost:8000/#v=2"},
+		"not a quota":      {spec: "http://localhost:8000/a=b", url: "http://localhost:8000/a=b"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			u, quota, err := parseBackend(tc.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.String() != tc.url {
+				t.Errorf("url %q, want %q", u, tc.url)
+			}
+			if quota != tc.quota {
+				t.Errorf("quota %d, want %d", quota, tc.quota)
+			}
+		})
+	}
+}
+
+func TestParseBackendError(t *testing.T) {
+	for _, spec := range []string{"localhost:8000", "http://localhost:8000=0", "http://localhost:8000=-1", "/api"} {
+		if _, _, err := parseBackend(spec); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
+
+func TestBackendRTT(t *testing.T) {
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer fast.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	m := newTestMetrics()
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(m.rtt)
+	pool := newBackendPool(1, m, ejectionPolicy{}, 0)
+	if err := pool.Update([]string{fast.URL, slow.URL}); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := roundRobin{pool: pool}
+	for i := 0; i < 10; i++ {
+		b := rr.Pick()
+		begun := time.Now()
+		resp, err := http.Get(b.url.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		b.rtt.Observe(time.Since(begun).Seconds())
+		b.quota.Release()
+	}
+
+	totals, err := derive.Gather(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rtt := func(u string) (count, mean float64) {
+		count, sum := totals.Sum("rtt", func(labels map[string]string) bool {
+			return "http://"+labels["backend"] == u
+		})
+		return count, sum / count
+	}
+	fastCount, fastMean := rtt(fast.URL)
+	slowCount, slowMean := rtt(slow.URL)
+	if fastCount != 5 || slowCount != 5 {
+		t.Fatalf("observed %v fast and %v slow round trips, want 5 each", fastCount, slowCount)
+	}
+	if fastMean >= 0.02 || slowMean < 0.02 {
+		t.Errorf("mean rtt of fast backend %.3fs, slow backend %.3fs", fastMean, slowMean)
+	}
+}
+
+func TestLeastConn(t *testing.T) {
+	pool := newTestPool(t, 1, 0, "http://small=10", "http://large=30")
+	lc := leastConn{pool: pool}
+
+	// Requests are still in-flight, so the backends' load is proportional to their quotas.
+	picks := make(map[string]int)
+	for i := 0; i < 20; i++ {
+		b := lc.Pick()
+		if b == nil {
+			t.Fatalf("request %d: no backend was picked", i)
+		}
+		picks[b.url.Host]++
+	}
+	if picks["small"] != 5 || picks["large"] != 15 {
+		t.Errorf("got %v, want 5 requests to small and 15 to large backend", picks)
+	}
+
+	// Once a backend is exhausted, traffic goes to the other one.
+	for i := 0; i < 20; i++ {
+		lc.Pick()
+	}
+	if b := lc.Pick(); b != nil {
+		t.Errorf("picked %s, want none when all quota is used", b.url)
+	}
+}
+
+func TestPowerOfTwo(t *testing.T) {
+	pool := newTestPool(t, 100, 0, "http://a", "http://b", "http://busy")
+	// The busy backend already has requests in-flight.
+	busy := pool.Backends()[2]
+	for i := 0; i < 50; i++ {
+		busy.quota.Receive()
+	}
+	p2c := powerOfTwo{pool: pool}
+
+	// Requests are done right away, so the busy backend stays more loaded than the others.
+	picks := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		b := p2c.Pick()
+		picks[b.url.Host]++
+		b.quota.Release()
+	}
+	// The busy backend loses every comparison it's chosen for.
+	if picks["busy"] != 0 {
+		t.Errorf("got %v, want no requests to the busy backend", picks)
+	}
+	for _, host := range []string{"a", "b"} {
+		if picks[host] < 1200 {
+			t.Errorf("got %v, want about 1500 requests to %s", picks, host)
+		}
+	}
+
+	// When the less loaded choice is exhausted, the other one is picked.
+	pool = newTestPool(t, 1, 0, "http://a", "http://b")
+	p2c = powerOfTwo{pool: pool}
+	if a, b := p2c.Pick(), p2c.Pick(); a == nil || b == nil || a == b {
+		t.Errorf("got %v and %v, want both backends", a, b)
+	}
+}
+
+func TestBackendWarmup(t *testing.T) {
+	p := newBackendPool(20, newTestMetrics(), ejectionPolicy{}, time.Minute)
+	if err := p.Update([]string{"http://a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Update([]string{"http://a", "http://b"}); err != nil {
+		t.Fatal(err)
+	}
+	bb := p.Backends()
+	initial, added := bb[0], bb[1]
+
+	// admitted fills the backend's quota as far as it admits requests, then releases it.
+	admitted := func(b *backend) int {
+		var n int
+		for b.receive() {
+			n++
+		}
+		for i := 0; i < n; i++ {
+			b.quota.Release()
+		}
+		return n
+	}
+	if n := admitted(initial); n != 20 {
+		t.Errorf("initial backend admitted %d, want full quota 20", n)
+	}
+
+	// The freshly added backend gets gradually more traffic as it warms up.
+	began := added.added
+	want := []struct {
+		since time.Duration
+		n     int
+	}{
+		{since: 0, n: 1},
+		{since: 15 * time.Second, n: 5},
+		{since: 30 * time.Second, n: 10},
+		{since: 45 * time.Second, n: 15},
+		{since: 2 * time.Minute, n: 20},
+	}
+	for _, w := range want {
+		added.added = began.Add(-w.since)
+		// The backend's weight keeps growing while requests are admitted, so a request may slip through.
+		if n := admitted(added); n < w.n || n > w.n+1 {
+			t.Errorf("%v since added: admitted %d, want %d", w.since, n, w.n)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states.
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// breakerTicket identifies when a request was allowed through the circuit breaker,
+// so that only outcomes of requests allowed in the current state are recorded.
+// Zero ticket is given to requests the breaker didn't allow.
+type breakerTicket uint64
+
+// CircuitBreaker fast-fails requests for a cooldown once the error rate of recent requests exceeds a threshold.
+// After the cooldown the circuit is half-open: a probe request is let through,
+// and the circuit closes if the probe succeeds or opens again if it fails.
+type CircuitBreaker struct {
+	mu    sync.Mutex
+	state int
+	// ticket changes on every transition and every probe,
+	// e.g., requests admitted before the circuit opened can't settle a half-open circuit.
+	ticket breakerTicket
+	// window is how many recent outcomes are kept.
+	window   int
+	outcomes *outcomeWindow
+	// threshold is an error rate above which the circuit opens.
+	threshold float64
+	// cooldown is how long the circuit stays open before it's half-open.
+	cooldown time.Duration
+	// changed is when the circuit was opened or a probe was let through.
+	changed time.Time
+	// probing is set when a probe request is in-flight while the circuit is half-open.
+	probing bool
+	// onChange is called with a new state of the circuit.
+	onChange func(state int)
+}
+
+// NewCircuitBreaker creates a closed circuit breaker which opens for cooldown
+// when the error rate of window most recent requests exceeds threshold.
+func NewCircuitBreaker(window int, threshold float64, cooldown time.Duration, onChange func(state int)) *CircuitBreaker {
+	b := CircuitBreaker{
+		window:    window,
+		outcomes:  newOutcomeWindow(window),
+		threshold: threshold,
+		cooldown:  cooldown,
+		onChange:  onChange,
+		ticket:    1,
+	}
+	return &b
+}
+
+// Allow reports whether a request can be forwarded to origin.
+// The returned ticket must be passed to Record along with the request's outcome.
+// When the circuit is half-open, only one probe is allowed at a time.
+// A probe which wasn't recorded within the cooldown is assumed lost, so another one is allowed.
+func (b *CircuitBreaker) Allow() (breakerTicket, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	switch b.state {
+	case breakerOpen:
+		if now.Sub(b.changed) < b.cooldown {
+			return 0, false
+		}
+		b.transition(breakerHalfOpen)
+	case breakerHalfOpen:
+		if b.probing && now.Sub(b.changed) < b.cooldown {
+			return 0, false
+		}
+		// The lost probe can't settle the circuit anymore.
+		b.ticket++
+	default:
+		return b.ticket, true
+	}
+
+	b.probing = true
+	b.changed = now
+	return b.ticket, true
+}
+
+// Record adds an outcome of a forwarded request which was allowed with the given ticket.
+// Outcomes of requests allowed in another state are ignored,
+// e.g., only the probe settles a half-open circuit.
+func (b *CircuitBreaker) Record(ticket breakerTicket, success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ticket != b.ticket {
+		return
+	}
+	switch b.state {
+	case breakerHalfOpen:
+		b.probing = false
+		if success {
+			b.outcomes = newOutcomeWindow(b.window)
+			b.transition(breakerClosed)
+		} else {
+			b.changed = time.Now()
+			b.transition(breakerOpen)
+		}
+	case breakerClosed:
+		// The circuit doesn't open until the window is full,
+		// so that a couple of errors right after start don't trip it.
+		errorRate := b.outcomes.Record(!success)
+		if b.outcomes.Len() == b.window && errorRate > b.threshold {
+			b.changed = time.Now()
+			b.transition(breakerOpen)
+		}
+	}
+}
+
+// transition changes the state of the circuit.
+func (b *CircuitBreaker) transition(state int) {
+	b.state = state
+	b.ticket++
+	if b.onChange != nil {
+		b.onChange(state)
+	}
+}
+
+// State returns the circuit state: closed, open, or half-open.
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// breakerTicketKey is a context key of a ticket the circuit breaker allowed a request with.
+type breakerTicketKey struct{}
+
+// withBreakerTicket returns a copy of ctx with the circuit breaker ticket t.
+func withBreakerTicket(ctx context.Context, t breakerTicket) context.Context {
+	return context.WithValue(ctx, breakerTicketKey{}, t)
+}
+
+// breakerTicketFrom returns a circuit breaker ticket of a request with the given ctx,
+// or zero if the breaker didn't allow the request.
+func breakerTicketFrom(ctx context.Context) breakerTicket {
+	t, _ := ctx.Value(breakerTicketKey{}).(breakerTicket)
+	return t
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// expireCooldown makes the breaker behave as if its cooldown has passed.
+func expireCooldown(b *CircuitBreaker) {
+	b.mu.Lock()
+	b.changed = b.changed.Add(-b.cooldown)
+	b.mu.Unlock()
+}
+
+func TestCircuitBreakerStates(t *testing.T) {
+	var states []int
+	b := NewCircuitBreaker(4, 0.5, time.Minute, func(state int) {
+		states = append(states, state)
+	})
+
+	// Two errors out of four don't exceed the threshold.
+	for _, success := range []bool{true, false, true, false} {
+		ticket, ok := b.Allow()
+		if !ok {
+			t.Fatal("closed circuit didn't allow a request")
+		}
+		b.Record(ticket, success)
+	}
+	if s := b.State(); s != "closed" {
+		t.Fatalf("state %s, want closed", s)
+	}
+
+	ticket, _ := b.Allow()
+	b.Record(ticket, false)
+	if s := b.State(); s != "open" {
+		t.Fatalf("state %s, want open after 3 errors out of 4", s)
+	}
+	if _, ok := b.Allow(); ok {
+		t.Fatal("open circuit allowed a request")
+	}
+
+	// The probe fails, so the circuit opens again.
+	expireCooldown(b)
+	probe, ok := b.Allow()
+	if !ok {
+		t.Fatal("circuit didn't allow a probe after cooldown")
+	}
+	if s := b.State(); s != "half-open" {
+		t.Fatalf("state %s, want half-open", s)
+	}
+	if _, ok = b.Allow(); ok {
+		t.Fatal("half-open circuit allowed a second probe")
+	}
+	b.Record(probe, false)
+	if s := b.State(); s != "open" {
+		t.Fatalf("state %s, want open after the probe failed", s)
+	}
+
+	// The probe succeeds, so the circuit closes.
+	expireCooldown(b)
+	probe, _ = b.Allow()
+	b.Record(probe, true)
+	if s := b.State(); s != "closed" {
+		t.Fatalf("state %s, want closed after the probe succeeded", s)
+	}
+
+	want := []int{breakerOpen, breakerHalfOpen, breakerOpen, breakerHalfOpen, breakerClosed}
+	if len(states) != len(want) {
+		t.Fatalf("transitions %v, want %v", states, want)
+	}
+	for i := range want {
+		if states[i] != want[i] {
+			t.Fatalf("transitions %v, want %v", states, want)
+		}
+	}
+}
+
+func TestCircuitBreakerOnlyProbeSettles(t *testing.T) {
+	b := NewCircuitBreaker(2, 0.4, time.Minute, nil)
+	// This request was allowed before the circuit opened and it's still in-flight.
+	stale, _ := b.Allow()
+	for i := 0; i < 2; i++ {
+		ticket, _ := b.Allow()
+		b.Record(ticket, false)
+	}
+	if s := b.State(); s != "open" {
+		t.Fatalf("state %s, want open", s)
+	}
+
+	expireCooldown(b)
+	probe, _ := b.Allow()
+	// Neither the stale request nor a request forwarded against the breaker settles the circuit.
+	b.Record(stale, true)
+	b.Record(0, true)
+	if s := b.State(); s != "half-open" {
+		t.Fatalf("state %s, want half-open until the probe is done", s)
+	}
+
+	b.Record(probe, true)
+	if s := b.State(); s != "closed" {
+		t.Fatalf("state %s, want closed", s)
+	}
+	// The probe's outcome is recorded once.
+	b.Record(probe, false)
+	if s := b.State(); s != "closed" {
+		t.Fatalf("state %s, want closed", s)
+	}
+}
+
+func TestCircuitBreakerLostProbe(t *testing.T) {
+	b := NewCircuitBreaker(1, 0, time.Minute, nil)
+	ticket, _ := b.Allow()
+	b.Record(ticket, false)
+
+	expireCooldown(b)
+	lost, _ := b.Allow()
+	// The probe wasn't recorded within the cooldown, so another one is allowed.
+	expireCooldown(b)
+	probe, ok := b.Allow()
+	if !ok {
+		t.Fatal("circuit didn't allow another probe")
+	}
+
+	b.Record(lost, true)
+	if s := b.State(); s != "half-open" {
+		t.Fatalf("state %s, want half-open: the lost probe can't settle the circuit", s)
+	}
+	b.Record(probe, true)
+	if s := b.State(); s != "closed" {
+		t.Fatalf("state %s, want closed", s)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// pathMatcher matches request paths against prefixes, e.g., /health,
+// or glob patterns, e.g., /static/*.css.
+type pathMatcher []string
+
+// parsePathMatcher parses a comma-separated list of path prefixes and glob patterns.
+func parsePathMatcher(spec string) pathMatcher {
+	var m pathMatcher
+	for _, p := range strings.Split(spec, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			m = append(m, p)
+		}
+	}
+	return m
+}
+
+// Match reports whether path p matches any of the prefixes or patterns.
+func (m pathMatcher) Match(p string) bool {
+	for _, pattern := range m {
+		if strings.ContainsAny(pattern, "*?[") {
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
+			}
+			continue
+		}
+		if strings.HasPrefix(p, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// bypassHandler proxies requests whose paths match m with bypass handler which doesn't consume quota,
+// other requests are served by next.
+func bypassHandler(m pathMatcher, bypass, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if m.Match(r.URL.Path) {
+			bypass.ServeHTTP(rw, r)
+			return
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
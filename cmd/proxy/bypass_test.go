@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/marselester/capacity"
+)
+
+func TestPathMatcher(t *testing.T) {
+	m := parsePathMatcher("/health, /static/*.css,,/favicon.ico")
+	tests := map[string]bool{
+		"/health":          true,
+		"/healthz":         true,
+		"/static/main.css": true,
+		"/static/main.js":  false,
+		"/favicon.ico":     true,
+		"/api/health":      false,
+		"/":                false,
+	}
+	for p, want := range tests {
+		if got := m.Match(p); got != want {
+			t.Errorf("%s: got %t, want %t", p, got, want)
+		}
+	}
+}
+
+func TestBypassHandler(t *testing.T) {
+	// Quota is fully used, so requests which consume it are rejected.
+	q := capacity.NewQuota(1)
+	q.Receive()
+	admit := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if !q.Receive() {
+			rw.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		defer q.Release()
+		rw.WriteHeader(http.StatusOK)
+	})
+	bypass := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+	h := bypassHandler(parsePathMatcher("/health,/favicon.ico"), bypass, admit)
+
+	tests := map[string]int{
+		"/health":      http.StatusOK,
+		"/favicon.ico": http.StatusOK,
+		"/api":         http.StatusTooManyRequests,
+	}
+	for p, want := range tests {
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, p, nil))
+		if rw.Code != want {
+			t.Errorf("%s: status %d, want %d", p, rw.Code, want)
+		}
+	}
+	if q.Used() != 1 {
+		t.Errorf("used %d, want 1: bypassed requests must not consume quota", q.Used())
+	}
+}
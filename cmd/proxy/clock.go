@@ -0,0 +1,69 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// sampleClock creates tickers of periodic metric sampling and control loops.
+// When jitter is positive, every tick is delayed by a random duration up to jitter,
+// so that sampling is irregular on purpose, e.g., to check that rates are computed
+// from the actual elapsed time rather than the assumed interval.
+type sampleClock struct {
+	jitter time.Duration
+}
+
+// sampleTicker delivers ticks the same way time.Ticker does.
+type sampleTicker struct {
+	C    <-chan time.Time
+	stop func()
+}
+
+// NewTicker returns a ticker which delivers the current time every interval, plus jitter if any.
+// Like time.Ticker, it drops ticks when the receiver is slow.
+func (c sampleClock) NewTicker(interval time.Duration) *sampleTicker {
+	if c.jitter <= 0 {
+		t := time.NewTicker(interval)
+		return &sampleTicker{C: t.C, stop: t.Stop}
+	}
+
+	ticks := make(chan time.Time, 1)
+	done := make(chan struct{})
+	go func() {
+		timer := time.NewTimer(c.delay(interval))
+		defer timer.Stop()
+
+		for {
+			select {
+			case now := <-timer.C:
+				select {
+				case ticks <- now:
+				default:
+				}
+				timer.Reset(c.delay(interval))
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	t := sampleTicker{
+		C: ticks,
+		stop: func() {
+			once.Do(func() { close(done) })
+		},
+	}
+	return &t
+}
+
+// delay returns how long to wait for the next tick.
+func (c sampleClock) delay(interval time.Duration) time.Duration {
+	return interval + time.Duration(rand.Int63n(int64(c.jitter)+1))
+}
+
+// Stop turns off the ticker.
+func (t *sampleTicker) Stop() {
+	t.stop()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marselester/capacity"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSampleClockJitter(t *testing.T) {
+	const (
+		interval = 10 * time.Millisecond
+		jitter   = 20 * time.Millisecond
+	)
+	ticker := sampleClock{jitter: jitter}.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for i := 0; i < 10; i++ {
+		now := <-ticker.C
+		// Every tick is delayed by up to jitter, the upper bound allows for the scheduler.
+		if gap := now.Sub(last); gap < interval || gap > interval+jitter+20*time.Millisecond {
+			t.Errorf("tick %d came %v after the previous one, want within [%v, %v]", i, gap, interval, interval+jitter)
+		}
+		last = now
+	}
+
+	ticker.Stop()
+	// Stop can be called more than once.
+	ticker.Stop()
+	time.Sleep(interval + jitter)
+	select {
+	case <-ticker.C:
+	default:
+	}
+	select {
+	case <-ticker.C:
+		t.Error("ticker ticked after it was stopped")
+	case <-time.After(2 * (interval + jitter)):
+	}
+}
+
+func TestSampleClockWithoutJitter(t *testing.T) {
+	ticker := sampleClock{}.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	last := <-ticker.C
+	now := <-ticker.C
+	if gap := now.Sub(last); gap < 5*time.Millisecond || gap > 30*time.Millisecond {
+		t.Errorf("ticks came %v apart, want about 10ms", gap)
+	}
+}
+
+// Time over and under target is the time which actually elapsed between samples, even when they're irregular.
+func TestTrackTargetIrregularTicks(t *testing.T) {
+	q := capacity.NewQuota(10)
+	over := prometheus.NewCounter(prometheus.CounterOpts{Name: "over"})
+	under := prometheus.NewCounter(prometheus.CounterOpts{Name: "under"})
+
+	ticks := make(chan time.Time)
+	stopped := make(chan struct{})
+	ticker := sampleTicker{C: ticks, stop: func() { close(stopped) }}
+	go trackTarget(q, &ticker, over, under)
+
+	// tick waits until the tracker has sampled quota:
+	// a repeated tick adds no time, and it's received only after the previous sample was taken.
+	tick := func(now time.Time) {
+		ticks <- now
+		ticks <- now
+	}
+	// Quota is on target until the first tick, so the time before it isn't counted.
+	for i := 0; i < 10; i++ {
+		q.Receive()
+	}
+	now := time.Now().Add(time.Second)
+	tick(now)
+
+	// Quota is sampled on a tick which comes after a delay since the previous one.
+	samples := []struct {
+		used  int64
+		max   int64
+		delay time.Duration
+	}{
+		{used: 5, max: 10, delay: time.Second},
+		{used: 5, max: 10, delay: 3 * time.Second},
+		{used: 10, max: 8, delay: 500 * time.Millisecond},
+		{used: 8, max: 8, delay: 2 * time.Second},
+	}
+	for _, s := range samples {
+		q.Set(10)
+		for q.Used() < s.used {
+			q.Receive()
+		}
+		for q.Used() > s.used {
+			q.Release()
+		}
+		q.Set(s.max)
+		now = now.Add(s.delay)
+		tick(now)
+	}
+	close(ticks)
+	<-stopped
+
+	// The quota was on target during the last 2s, so they're neither over nor under.
+	if got := testutil.ToFloat64(under); got != 4 {
+		t.Errorf("under target %vs, want 4s", got)
+	}
+	if got := testutil.ToFloat64(over); got != 0.5 {
+		t.Errorf("over target %vs, want 0.5s", got)
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/marselester/capacity"
+)
+
+// markCongestion sets X-Congestion: high header on a successful response
+// when utilization (in-flight / target) of quota q is at or above the threshold, 0 means no marks.
+// Cooperative clients are asked to slow down before their requests have to be rejected.
+func markCongestion(resp *http.Response, q *capacity.Quota, threshold float64) {
+	if threshold <= 0 || resp.StatusCode != http.StatusOK {
+		return
+	}
+	if float64(q.Used()) >= threshold*float64(q.Max()) {
+		resp.Header.Set("X-Congestion", "high")
+	}
+}
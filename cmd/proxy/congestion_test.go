@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/marselester/capacity"
+)
+
+func TestMarkCongestion(t *testing.T) {
+	tests := map[string]struct {
+		used      int64
+		status    int
+		threshold float64
+		want      string
+	}{
+		"below threshold": {used: 7, status: http.StatusOK, threshold: 0.8},
+		"at threshold":    {used: 8, status: http.StatusOK, threshold: 0.8, want: "high"},
+		"above threshold": {used: 10, status: http.StatusOK, threshold: 0.8, want: "high"},
+		"error response":  {used: 10, status: http.StatusServiceUnavailable, threshold: 0.8},
+		"no marks":        {used: 10, status: http.StatusOK},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := capacity.NewQuota(10)
+			for i := int64(0); i < tc.used; i++ {
+				q.Receive()
+			}
+			resp := http.Response{StatusCode: tc.status, Header: http.Header{}}
+			markCongestion(&resp, q, tc.threshold)
+			if got := resp.Header.Get("X-Congestion"); got != tc.want {
+				t.Errorf("X-Congestion %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// errConnPoolExhausted is returned when a request waited too long for a connection to origin.
+var errConnPoolExhausted = errors.New("connection pool exhausted")
+
+const (
+	connWaiting int32 = iota
+	connGot
+	connTimedOut
+)
+
+// connPoolTransport detects requests waiting longer than waitTimeout for a connection to origin,
+// e.g., when all connections allowed by http.Transport.MaxConnsPerHost are busy.
+// Such requests stall in the proxy itself which looks like origin's latency rather than rejection.
+type connPoolTransport struct {
+	http.RoundTripper
+	waitTimeout time.Duration
+	// shed cancels requests which waited too long instead of letting them queue in the transport.
+	shed      bool
+	exhausted prometheus.Counter
+}
+
+// RoundTrip sends a request to origin and reports errConnPoolExhausted
+// if the request was shed while waiting for a connection.
+func (t *connPoolTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if t.waitTimeout == 0 {
+		return t.RoundTripper.RoundTrip(r)
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	state := connWaiting
+	trace := httptrace.ClientTrace{
+		GotConn: func(httptrace.GotConnInfo) {
+			atomic.CompareAndSwapInt32(&state, connWaiting, connGot)
+		},
+	}
+	timer := time.AfterFunc(t.waitTimeout, func() {
+		if !atomic.CompareAndSwapInt32(&state, connWaiting, connTimedOut) {
+			return
+		}
+		t.exhausted.Inc()
+		if t.shed {
+			cancel()
+		}
+	})
+
+	resp, err := t.RoundTripper.RoundTrip(r.WithContext(httptrace.WithClientTrace(ctx, &trace)))
+	timer.Stop()
+	if err != nil {
+		cancel()
+		if t.shed && atomic.LoadInt32(&state) == connTimedOut {
+			return nil, errConnPoolExhausted
+		}
+		return nil, err
+	}
+
+	// The context must outlive the round trip since the response body is read afterwards.
+	resp.Body = &closeHookBody{ReadCloser: resp.Body, onClose: cancel}
+	return resp, nil
+}
+
+// closeHookBody calls onClose when a response body is closed, e.g., to cancel a request's context.
+type closeHookBody struct {
+	io.ReadCloser
+	onClose func()
+	once    sync.Once
+	// err is the first error other than io.EOF the body was read with.
+	err error
+}
+
+// Read reads the body and remembers the first read error, e.g., when a response was truncated.
+func (b *closeHookBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+// ReadErr returns the first error other than io.EOF the body was read with.
+func (b *closeHookBody) ReadErr() error {
+	return b.err
+}
+
+// Truncated reports whether the body was cut short by origin,
+// unlike a client that went away before the body was copied.
+func (b *closeHookBody) Truncated() bool {
+	return b.err != nil && !errors.Is(b.err, context.Canceled)
+}
+
+// Close closes the body and calls the hook once.
+func (b *closeHookBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.onClose)
+	return err
+}
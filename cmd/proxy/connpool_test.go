@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestConnPoolTransportExhausted(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer origin.Close()
+
+	tests := map[string]struct {
+		shed     bool
+		failures int
+	}{
+		"queue": {},
+		"shed":  {shed: true, failures: 1},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			tr := connPoolTransport{
+				RoundTripper: &http.Transport{MaxConnsPerHost: 1},
+				waitTimeout:  50 * time.Millisecond,
+				shed:         tc.shed,
+				exhausted:    prometheus.NewCounter(prometheus.CounterOpts{Name: "exhausted"}),
+			}
+			defer tr.RoundTripper.(*http.Transport).CloseIdleConnections()
+
+			// The second request waits for the only connection while the first one is served by a slow origin.
+			var (
+				wg   sync.WaitGroup
+				mu   sync.Mutex
+				errs []error
+			)
+			for i := 0; i < 2; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					req, _ := http.NewRequest(http.MethodGet, origin.URL, nil)
+					resp, err := tr.RoundTrip(req)
+					if err == nil {
+						io.Copy(io.Discard, resp.Body)
+						resp.Body.Close()
+					}
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}()
+				time.Sleep(10 * time.Millisecond)
+			}
+			wg.Wait()
+
+			if got := testutil.ToFloat64(tr.exhausted); got != 1 {
+				t.Errorf("exhausted %v, want 1", got)
+			}
+			var failures int
+			for _, err := range errs {
+				if err == nil {
+					continue
+				}
+				failures++
+				if !errors.Is(err, errConnPoolExhausted) {
+					t.Errorf("got %v, want %v", err, errConnPoolExhausted)
+				}
+			}
+			if failures != tc.failures {
+				t.Errorf("failed %d requests, want %d", failures, tc.failures)
+			}
+		})
+	}
+}
+
+func TestCloseHookBodyTruncated(t *testing.T) {
+	tests := map[string]struct {
+		length string
+		want   bool
+	}{
+		"complete": {want: false},
+		// Origin declares a longer body than it writes, so the connection is closed mid-body.
+		"truncated": {length: "1024", want: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tc.length != "" {
+					w.Header().Set("Content-Length", tc.length)
+				}
+				fmt.Fprint(w, "🐈\n")
+			}))
+			defer origin.Close()
+
+			u, _ := url.Parse(origin.URL)
+			truncated := make(chan bool, 1)
+			proxy := httputil.NewSingleHostReverseProxy(u)
+			proxy.ErrorLog = log.New(io.Discard, "", 0)
+			proxy.ModifyResponse = func(resp *http.Response) error {
+				body := &closeHookBody{ReadCloser: resp.Body}
+				body.onClose = func() {
+					truncated <- body.Truncated()
+				}
+				resp.Body = body
+				return nil
+			}
+			srv := httptest.NewServer(proxy)
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL)
+			if err == nil {
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+			if got := <-truncated; got != tc.want {
+				t.Errorf("truncated %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// connLimiter closes new downstream connections when too many of them are open (max > 0).
+// Connections are closed right after they're accepted before any request is parsed,
+// so a connection flood doesn't spawn request handling goroutines.
+type connLimiter struct {
+	max    int64
+	active int64
+
+	open     prometheus.Gauge
+	rejected prometheus.Counter
+}
+
+// ConnState tracks open connections and closes the new ones over the limit.
+// It is meant to be used as http.Server.ConnState.
+func (l *connLimiter) ConnState(c net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		l.open.Inc()
+		if n := atomic.AddInt64(&l.active, 1); l.max > 0 && n > l.max {
+			l.rejected.Inc()
+			c.Close()
+		}
+	case http.StateHijacked, http.StateClosed:
+		l.open.Dec()
+		atomic.AddInt64(&l.active, -1)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestConnLimiter(t *testing.T) {
+	l := connLimiter{
+		max:      2,
+		open:     prometheus.NewGauge(prometheus.GaugeOpts{Name: "open"}),
+		rejected: prometheus.NewCounter(prometheus.CounterOpts{Name: "rejected"}),
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Config.ConnState = l.ConnState
+	srv.Start()
+	defer srv.Close()
+
+	var conns []net.Conn
+	for i := 0; i < 3; i++ {
+		c, err := net.Dial("tcp", srv.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		conns = append(conns, c)
+	}
+
+	// The connection over the limit is closed before its request is parsed.
+	over := conns[2]
+	over.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := over.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("connection over the limit got %v, want %v", err, io.EOF)
+	}
+	if got := testutil.ToFloat64(l.rejected); got != 1 {
+		t.Errorf("rejected %v, want 1", got)
+	}
+
+	// Connections within the limit are served.
+	for i, c := range conns[:2] {
+		c.SetDeadline(time.Now().Add(time.Second))
+		if _, err := io.WriteString(c, "GET / HTTP/1.1\r\nHost: proxy\r\n\r\n"); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+		if err != nil {
+			t.Fatalf("connection %d: %v", i, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("connection %d: status %d, want 200", i, resp.StatusCode)
+		}
+	}
+	if got := testutil.ToFloat64(l.open); got != 2 {
+		t.Errorf("open %v, want 2", got)
+	}
+}
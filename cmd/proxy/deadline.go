@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// parseDeadline parses X-Request-Deadline header value h in RFC 3339 format, e.g., 2021-06-01T10:00:00.5Z.
+// It returns false if the value is empty or can't be parsed.
+func parseDeadline(h string) (time.Time, bool) {
+	if h == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, h)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// setDeadlineHeader forwards the request's context deadline to origin in X-Request-Deadline header.
+func setDeadlineHeader(r *http.Request) {
+	if deadline, ok := r.Context().Deadline(); ok {
+		r.Header.Set("X-Request-Deadline", deadline.UTC().Format(time.RFC3339Nano))
+	}
+}
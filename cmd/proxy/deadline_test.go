@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseDeadline(t *testing.T) {
+	tests := map[string]struct {
+		h      string
+		want   time.Time
+		wantOK bool
+	}{
+		"fraction": {h: "2021-06-01T10:00:00.5Z", want: time.Date(2021, 6, 1, 10, 0, 0, 5e8, time.UTC), wantOK: true},
+		"offset":   {h: "2021-06-01T12:00:00+02:00", want: time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC), wantOK: true},
+		"absent":   {},
+		"garbage":  {h: "in 5s"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, ok := parseDeadline(tc.h)
+			if ok != tc.wantOK || !got.Equal(tc.want) {
+				t.Errorf("got %v %t, want %v %t", got, ok, tc.want, tc.wantOK)
+			}
+		})
+	}
+}
+
+func TestSetDeadlineHeader(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	setDeadlineHeader(r)
+	if h := r.Header.Get("X-Request-Deadline"); h != "" {
+		t.Errorf("got %q, want no header without a deadline", h)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	r = r.WithContext(ctx)
+	setDeadlineHeader(r)
+	got, ok := parseDeadline(r.Header.Get("X-Request-Deadline"))
+	if !ok || !got.Equal(deadline) {
+		t.Errorf("origin got deadline %v, want %v", got, deadline)
+	}
+}
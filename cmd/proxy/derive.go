@@ -0,0 +1,68 @@
+package main
+
+import (
+	"time"
+
+	"github.com/marselester/capacity/internal/derive"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// derivedGauges are computed from proxy's raw metrics, so that a dashboard doesn't need PromQL recording rules.
+type derivedGauges struct {
+	rejectionRatio     prometheus.Gauge
+	goodputRate        prometheus.Gauge
+	utilization        prometheus.Gauge
+	optimalConcurrency prometheus.Gauge
+}
+
+// newDerivedGauges creates and registers derived gauges of the proxy.
+func newDerivedGauges() *derivedGauges {
+	d := derivedGauges{
+		rejectionRatio: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "proxy_derived_rejection_ratio",
+			Help: "Fraction of requests rejected by the proxy since the previous derivation.",
+		}),
+		goodputRate: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "proxy_derived_goodput_per_second",
+			Help: "How many requests per second origin served with 2xx since the previous derivation.",
+		}),
+		utilization: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "proxy_derived_utilization",
+			Help: "Ratio of in-flight requests to target concurrency.",
+		}),
+		optimalConcurrency: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "proxy_derived_optimal_concurrency",
+			Help: "Concurrency which sustains the goodput without queueing: goodput times baseline round-trip time (or mean response time without probing).",
+		}),
+	}
+	prometheus.MustRegister(d.rejectionRatio, d.goodputRate, d.utilization, d.optimalConcurrency)
+	return &d
+}
+
+// Update sets the gauges based on how proxy_request_duration_seconds changed in elapsed time,
+// and the current in-flight, target and baseline round-trip time gauges.
+func (d *derivedGauges) Update(prev, cur derive.Totals, elapsed time.Duration) {
+	outcome := func(name string) derive.Match {
+		return func(labels map[string]string) bool {
+			return labels["outcome"] == name
+		}
+	}
+	total, _ := derive.Delta(prev, cur, "proxy_request_duration_seconds", nil)
+	rejected, _ := derive.Delta(prev, cur, "proxy_request_duration_seconds", outcome("rejected"))
+	ok, okSeconds := derive.Delta(prev, cur, "proxy_request_duration_seconds", outcome("ok"))
+	inflight, _ := cur.Sum("proxy_inflight_requests", nil)
+	target, _ := cur.Sum("proxy_target_inflight_requests", nil)
+	baseline, _ := cur.Sum("proxy_baseline_rtt_seconds", nil)
+
+	goodput := derive.Ratio(ok, elapsed.Seconds())
+	// By Little's law, concurrency is throughput times latency,
+	// and the least latency is when requests don't queue at origin.
+	if baseline == 0 {
+		baseline = derive.Ratio(okSeconds, ok)
+	}
+
+	d.rejectionRatio.Set(derive.Ratio(rejected, total))
+	d.goodputRate.Set(goodput)
+	d.utilization.Set(derive.Ratio(inflight, target))
+	d.optimalConcurrency.Set(goodput * baseline)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/marselester/capacity/internal/derive"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDerivedGauges(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	requestDuration := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "proxy_request_duration_seconds"}, []string{"outcome"})
+	inflight := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "proxy_inflight_requests"}, []string{"method_class"})
+	target := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "proxy_target_inflight_requests"}, []string{"method_class"})
+	reg.MustRegister(requestDuration, inflight, target)
+
+	gather := func() derive.Totals {
+		t.Helper()
+		totals, err := derive.Gather(reg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return totals
+	}
+	// Requests served before the previous derivation don't count.
+	requestDuration.WithLabelValues("ok").Observe(1)
+	prev := gather()
+
+	for i := 0; i < 30; i++ {
+		requestDuration.WithLabelValues("ok").Observe(0.1)
+	}
+	for i := 0; i < 10; i++ {
+		requestDuration.WithLabelValues("rejected").Observe(0)
+	}
+	inflight.WithLabelValues("all").Set(8)
+	target.WithLabelValues("all").Set(10)
+
+	d := newDerivedGauges()
+	d.Update(prev, gather(), 2*time.Second)
+
+	tests := map[string]struct {
+		gauge prometheus.Gauge
+		want  float64
+	}{
+		// 10 out of 40 requests were rejected.
+		"rejection ratio": {d.rejectionRatio, 0.25},
+		// 30 requests succeeded in 2 seconds.
+		"goodput":     {d.goodputRate, 15},
+		"utilization": {d.utilization, 0.8},
+		// Without probing, the baseline is the mean response time 0.1s, so 15 rps * 0.1s.
+		"optimal concurrency": {d.optimalConcurrency, 1.5},
+	}
+	for name, tc := range tests {
+		if got := testutil.ToFloat64(tc.gauge); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("%s: got %v, want %v", name, got, tc.want)
+		}
+	}
+}
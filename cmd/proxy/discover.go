@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// capacityStep is goodput (successful responses per second) observed at a fixed concurrency.
+type capacityStep struct {
+	concurrency int64
+	goodput     float64
+}
+
+// capacitySearch discovers origin's capacity by doubling concurrency of requests
+// until goodput stops growing, e.g., 1, 2, 4, 8 concurrent requests.
+type capacitySearch struct {
+	url    *url.URL
+	client *http.Client
+	// step is how long every concurrency level is measured.
+	step time.Duration
+	// max caps the concurrency the search can reach.
+	max int64
+	// tolerance is a fraction of the peak goodput that can be sacrificed for lower concurrency.
+	tolerance float64
+}
+
+// Run returns the concurrency at the goodput knee,
+// i.e., the least concurrency that achieves nearly the peak goodput.
+func (s *capacitySearch) Run(ctx context.Context) int64 {
+	var (
+		steps []capacityStep
+		peak  float64
+	)
+	for c := int64(1); c <= s.max && ctx.Err() == nil; c *= 2 {
+		goodput := s.measure(ctx, c)
+		log.Printf("proxy: capacity search: concurrency %d, goodput %.2f rps", c, goodput)
+		steps = append(steps, capacityStep{concurrency: c, goodput: goodput})
+
+		// Origin is saturated once doubling concurrency doesn't add goodput.
+		if goodput < (1+s.tolerance)*peak {
+			break
+		}
+		peak = goodput
+	}
+
+	for _, st := range steps {
+		if st.goodput > peak {
+			peak = st.goodput
+		}
+	}
+	for _, st := range steps {
+		if st.goodput >= (1-s.tolerance)*peak {
+			return st.concurrency
+		}
+	}
+	return 1
+}
+
+// measure sends requests from n concurrent workers for a step duration and returns goodput.
+func (s *capacitySearch) measure(ctx context.Context, n int64) float64 {
+	ctx, cancel := context.WithTimeout(ctx, s.step)
+	defer cancel()
+
+	var (
+		ok int64
+		wg sync.WaitGroup
+	)
+	begun := time.Now()
+	for i := int64(0); i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for ctx.Err() == nil {
+				req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url.String(), nil)
+				if err != nil {
+					return
+				}
+				resp, err := s.client.Do(req)
+				if err != nil {
+					continue
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+
+				// Responses cut off by the end of the step aren't counted.
+				if resp.StatusCode == http.StatusOK && ctx.Err() == nil {
+					atomic.AddInt64(&ok, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return float64(ok) / time.Since(begun).Seconds()
+}
+
+// quotaOfCapacity returns a fraction of the discovered capacity, but no less than min.
+func quotaOfCapacity(capacity int64, fraction float64, min int64) int64 {
+	q := int64(fraction * float64(capacity))
+	if q < min {
+		q = min
+	}
+	return q
+}
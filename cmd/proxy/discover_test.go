@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestCapacitySearch(t *testing.T) {
+	// Origin has 4 workers, the excess requests wait in its queue.
+	workers := make(chan struct{}, 4)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		workers <- struct{}{}
+		time.Sleep(20 * time.Millisecond)
+		<-workers
+	}))
+	defer origin.Close()
+
+	u, _ := url.Parse(origin.URL)
+	s := capacitySearch{
+		url:       u,
+		client:    origin.Client(),
+		step:      300 * time.Millisecond,
+		max:       64,
+		tolerance: 0.05,
+	}
+	knee := s.Run(context.Background())
+	if knee != 4 {
+		t.Fatalf("knee %d, want 4", knee)
+	}
+
+	tests := map[string]struct {
+		fraction float64
+		min      int64
+		want     int64
+	}{
+		"fraction": {fraction: 0.8, min: 1, want: 3},
+		"whole":    {fraction: 1, min: 1, want: 4},
+		"min":      {fraction: 0.1, min: 2, want: 2},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := quotaOfCapacity(knee, tc.fraction, tc.min); got != tc.want {
+				t.Errorf("got %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
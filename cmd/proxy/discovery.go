@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"time"
+)
+
+// lookupFunc resolves a host name to its IP addresses, e.g., net.DefaultResolver.LookupHost.
+type lookupFunc func(ctx context.Context, host string) ([]string, error)
+
+// dnsDiscovery treats every IP address of a host name (A/AAAA records) as a backend,
+// e.g., pods of a headless Kubernetes service.
+type dnsDiscovery struct {
+	pool *backendPool
+	// addr is host:port of the service.
+	addr   string
+	lookup lookupFunc
+}
+
+// Refresh resolves the service's host name and updates the pool with a backend per IP address.
+func (d *dnsDiscovery) Refresh(ctx context.Context) error {
+	host, port, err := net.SplitHostPort(d.addr)
+	if err != nil {
+		return fmt.Errorf("invalid origin dns address: %w", err)
+	}
+
+	ips, err := d.lookup(ctx, host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve origin: %w", err)
+	}
+	sort.Strings(ips)
+
+	specs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		specs = append(specs, "http://"+net.JoinHostPort(ip, port))
+	}
+	return d.pool.Update(specs)
+}
+
+// Run refreshes backends every interval.
+// When DNS can't be resolved, the backends stay the same until the next refresh.
+func (d *dnsDiscovery) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		if err := d.Refresh(ctx); err != nil {
+			log.Printf("proxy: %v", err)
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDNSDiscovery(t *testing.T) {
+	var (
+		ips []string
+		err error
+	)
+	d := dnsDiscovery{
+		pool: newTestPool(t, 10, 0, "http://localhost:8000"),
+		addr: "service.local:8000",
+		lookup: func(ctx context.Context, host string) ([]string, error) {
+			if host != "service.local" {
+				t.Errorf("resolved %q, want service.local", host)
+			}
+			return ips, err
+		},
+	}
+
+	tests := []struct {
+		ips   []string
+		err   error
+		specs string
+	}{
+		{ips: []string{"10.0.0.2", "10.0.0.1"}, specs: "http://10.0.0.1:8000=10,http://10.0.0.2:8000=10"},
+		{ips: []string{"10.0.0.3", "10.0.0.1", "::1"}, specs: "http://10.0.0.1:8000=10,http://10.0.0.3:8000=10,http://[::1]:8000=10"},
+		// Backends stay the same when DNS can't be resolved or it has no records.
+		{err: errors.New("no such host"), specs: "http://10.0.0.1:8000=10,http://10.0.0.3:8000=10,http://[::1]:8000=10"},
+		{ips: []string{}, specs: "http://10.0.0.1:8000=10,http://10.0.0.3:8000=10,http://[::1]:8000=10"},
+		{ips: []string{"10.0.0.3"}, specs: "http://10.0.0.3:8000=10"},
+	}
+	for i, tc := range tests {
+		ips, err = tc.ips, tc.err
+		refreshErr := d.Refresh(context.Background())
+		if (refreshErr != nil) != (tc.err != nil || len(tc.ips) == 0) {
+			t.Errorf("refresh %d: unexpected error %v", i, refreshErr)
+		}
+		if got := strings.Join(d.pool.Specs(), ","); got != tc.specs {
+			t.Errorf("refresh %d: got %s, want %s", i, got, tc.specs)
+		}
+	}
+}
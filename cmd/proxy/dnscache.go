@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// dnsCacheEntry is a resolved host name which is valid until it expires.
+type dnsCacheEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+// dnsCache caches resolved host names of origins for ttl,
+// so that requests don't wait for a DNS lookup every time a connection is dialed.
+type dnsCache struct {
+	ttl    time.Duration
+	lookup lookupFunc
+	dialer net.Dialer
+
+	mu      sync.Mutex
+	entries map[string]dnsCacheEntry
+}
+
+// newDNSCache creates a cache which resolves host names with lookup.
+func newDNSCache(ttl time.Duration, lookup lookupFunc) *dnsCache {
+	c := dnsCache{
+		ttl:    ttl,
+		lookup: lookup,
+		// The dialer is configured the same way as in http.DefaultTransport.
+		dialer: net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		},
+		entries: make(map[string]dnsCacheEntry),
+	}
+	return &c
+}
+
+// Lookup returns cached IP addresses of the host, or resolves them if they expired.
+func (c *dnsCache) Lookup(ctx context.Context, host string) ([]string, error) {
+	c.mu.Lock()
+	e, ok := c.entries[host]
+	c.mu.Unlock()
+	if ok && time.Now().Before(e.expires) {
+		return e.addrs, nil
+	}
+
+	addrs, err := c.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.entries[host] = dnsCacheEntry{
+		addrs:   addrs,
+		expires: time.Now().Add(c.ttl),
+	}
+	c.mu.Unlock()
+	return addrs, nil
+}
+
+// DialContext connects to the address trying the host's cached IP addresses in turn.
+// It is meant to be used as http.Transport.DialContext.
+func (c *dnsCache) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return c.dialer.DialContext(ctx, network, addr)
+	}
+
+	addrs, err := c.Lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("no addresses found for " + host)
+	}
+
+	for _, a := range addrs {
+		var conn net.Conn
+		if conn, err = c.dialer.DialContext(ctx, network, net.JoinHostPort(a, port)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
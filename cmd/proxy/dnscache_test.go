@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDNSCache(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer origin.Close()
+	u, _ := url.Parse(origin.URL)
+	_, port, _ := net.SplitHostPort(u.Host)
+
+	var lookups int64
+	c := newDNSCache(100*time.Millisecond, func(ctx context.Context, host string) ([]string, error) {
+		atomic.AddInt64(&lookups, 1)
+		if host != "origin.test" {
+			t.Errorf("resolved %q, want origin.test", host)
+		}
+		return []string{"127.0.0.1"}, nil
+	})
+	// Every request dials a new connection.
+	client := http.Client{Transport: &http.Transport{
+		DialContext:       c.DialContext,
+		DisableKeepAlives: true,
+	}}
+	get := func() {
+		t.Helper()
+		resp, err := client.Get("http://origin.test:" + port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	for i := 0; i < 5; i++ {
+		get()
+	}
+	if n := atomic.LoadInt64(&lookups); n != 1 {
+		t.Errorf("looked up %d times within TTL, want 1", n)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	get()
+	if n := atomic.LoadInt64(&lookups); n != 2 {
+		t.Errorf("looked up %d times, want 2 once TTL expired", n)
+	}
+
+	// IP addresses aren't looked up.
+	resp, err := client.Get(origin.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if n := atomic.LoadInt64(&lookups); n != 2 {
+		t.Errorf("looked up %d times, want 2", n)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/marselester/capacity"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// quotaEvents records when a request acquired, released, or was rejected quota,
+// so it's clear where the request spent time relative to the limiter.
+type quotaEvents interface {
+	// Event records a named event, e.g., quota_acquired, with quota usage at that moment.
+	Event(r *http.Request, name string, q *capacity.Quota)
+}
+
+// nopQuotaEvents discards events.
+type nopQuotaEvents struct{}
+
+// Event does nothing.
+func (nopQuotaEvents) Event(*http.Request, string, *capacity.Quota) {}
+
+// spanQuotaEvents adds events to a request's trace span.
+type spanQuotaEvents struct{}
+
+// Event adds the event with used and max attributes to the span found in the request's context.
+func (spanQuotaEvents) Event(r *http.Request, name string, q *capacity.Quota) {
+	trace.SpanFromContext(r.Context()).AddEvent(name, trace.WithAttributes(
+		attribute.Int64("used", q.Used()),
+		attribute.Int64("max", q.Max()),
+	))
+}
+
+// receiveQuota fills quota q for request r with receive and records whether the request acquired quota or was rejected.
+func receiveQuota(events quotaEvents, r *http.Request, q *capacity.Quota, receive func(context.Context, *capacity.Quota) bool) bool {
+	if !receive(r.Context(), q) {
+		events.Event(r, "quota_rejected", q)
+		return false
+	}
+	events.Event(r, "quota_acquired", q)
+	return true
+}
+
+// releaseQuota frees up quota q held by request r since acquired, see releaseHeld, and records the release
+// once the slot is actually freed up.
+// The request's span doesn't end until then.
+func releaseQuota(events quotaEvents, r *http.Request, q *capacity.Quota, acquired time.Time, hold time.Duration) {
+	done := holdSpan(r.Context())
+	releaseHeld(func() {
+		q.Release()
+		events.Event(r, "quota_released", q)
+		done()
+	}, acquired, hold)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/marselester/capacity"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// quotaEvent is an event recorded by memQuotaEvents.
+type quotaEvent struct {
+	path string
+	name string
+	used int64
+	max  int64
+}
+
+// memQuotaEvents records events in memory.
+type memQuotaEvents []quotaEvent
+
+func (ee *memQuotaEvents) Event(r *http.Request, name string, q *capacity.Quota) {
+	*ee = append(*ee, quotaEvent{path: r.URL.Path, name: name, used: q.Used(), max: q.Max()})
+}
+
+func TestQuotaEvents(t *testing.T) {
+	var events memQuotaEvents
+	q := capacity.NewQuota(1)
+	receive := func(_ context.Context, q *capacity.Quota) bool {
+		return q.Receive()
+	}
+	a := httptest.NewRequest(http.MethodGet, "/a", nil)
+	b := httptest.NewRequest(http.MethodGet, "/b", nil)
+
+	if !receiveQuota(&events, a, q, receive) {
+		t.Fatal("/a wasn't admitted")
+	}
+	if receiveQuota(&events, b, q, receive) {
+		t.Fatal("/b was admitted while quota was full")
+	}
+	releaseQuota(&events, a, q, time.Now(), 0)
+
+	want := []quotaEvent{
+		{path: "/a", name: "quota_acquired", used: 1, max: 1},
+		{path: "/b", name: "quota_rejected", used: 1, max: 1},
+		{path: "/a", name: "quota_released", used: 0, max: 1},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %v, want %v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("event %d is %v, want %v", i, events[i], want[i])
+		}
+	}
+}
+
+func TestSpanQuotaEvents(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	tracer := tp.Tracer("test")
+	q := capacity.NewQuota(1)
+	receive := func(_ context.Context, q *capacity.Quota) bool {
+		return q.Receive()
+	}
+	const hold = 50 * time.Millisecond
+
+	// The slot of /a is held after its response was sent.
+	ra := httptest.NewRequest(http.MethodGet, "/a", nil)
+	ctx, a := startRequestSpan(ra.Context(), tracer, ra)
+	ra = ra.WithContext(ctx)
+	if !receiveQuota(spanQuotaEvents{}, ra, q, receive) {
+		t.Fatal("/a wasn't admitted")
+	}
+	rb := httptest.NewRequest(http.MethodPost, "/b", nil)
+	ctx, b := startRequestSpan(rb.Context(), tracer, rb)
+	rb = rb.WithContext(ctx)
+	if receiveQuota(spanQuotaEvents{}, rb, q, receive) {
+		t.Fatal("/b was admitted while quota was full")
+	}
+	b.Done()
+	releaseQuota(spanQuotaEvents{}, ra, q, time.Now(), hold)
+	a.Done()
+	if spans := exporter.GetSpans(); len(spans) != 1 {
+		t.Fatalf("got %d spans, want only /b to end before the slot of /a is freed up", len(spans))
+	}
+	time.Sleep(2 * hold)
+
+	// spanEvents returns events of the span with the given name.
+	spanEvents := func(name string) []quotaEvent {
+		var ee []quotaEvent
+		for _, s := range exporter.GetSpans() {
+			if s.Name != name {
+				continue
+			}
+			for _, e := range s.Events {
+				qe := quotaEvent{name: e.Name}
+				for _, kv := range e.Attributes {
+					switch kv.Key {
+					case "used":
+						qe.used = kv.Value.AsInt64()
+					case "max":
+						qe.max = kv.Value.AsInt64()
+					}
+				}
+				ee = append(ee, qe)
+			}
+		}
+		return ee
+	}
+	tests := map[string][]quotaEvent{
+		"proxy GET": {
+			{name: "quota_acquired", used: 1, max: 1},
+			{name: "quota_released", used: 0, max: 1},
+		},
+		"proxy POST": {
+			{name: "quota_rejected", used: 1, max: 1},
+		},
+	}
+	for span, want := range tests {
+		got := spanEvents(span)
+		if len(got) != len(want) {
+			t.Errorf("span %q: got events %v, want %v", span, got, want)
+			continue
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("span %q: event %d is %v, want %v", span, i, got[i], want[i])
+			}
+		}
+	}
+}
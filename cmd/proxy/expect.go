@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// errQuotaUnavailable is returned when a request body can't be sent to origin because quota is exhausted.
+var errQuotaUnavailable = errors.New("quota unavailable")
+
+const (
+	bodyPending int32 = iota
+	bodyAcquired
+	bodyRejected
+	bodyReleased
+)
+
+// continueBody is a request body which acquires quota only when origin agrees to receive it,
+// i.e., when the transport starts reading the body after origin responded with 100 Continue.
+// Uploads rejected by origin don't consume quota this way.
+type continueBody struct {
+	io.ReadCloser
+	// acquire admits the request into quota, release frees up the acquired quota.
+	acquire func() bool
+	release func()
+	state   int32
+}
+
+// expectsContinue reports whether a client waits for 100 Continue before sending a request body.
+func expectsContinue(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Expect"), "100-continue") && r.ContentLength != 0
+}
+
+// uploadRejected reports whether request r's body wasn't sent to origin because quota was exhausted.
+func uploadRejected(r *http.Request) bool {
+	b, ok := r.Body.(*continueBody)
+	return ok && b.Rejected()
+}
+
+// Read acquires quota on the first call and reads the body,
+// or returns errQuotaUnavailable if quota is exhausted.
+// It's not safe to call Read concurrently, though it can run concurrently with Release.
+func (b *continueBody) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(&b.state) == bodyPending {
+		switch {
+		case !b.acquire():
+			atomic.CompareAndSwapInt32(&b.state, bodyPending, bodyRejected)
+		// The body was released while quota was being acquired,
+		// so nobody else is going to free it up.
+		case !atomic.CompareAndSwapInt32(&b.state, bodyPending, bodyAcquired):
+			b.release()
+		}
+	}
+
+	switch atomic.LoadInt32(&b.state) {
+	case bodyAcquired:
+		return b.ReadCloser.Read(p)
+	case bodyRejected:
+		return 0, errQuotaUnavailable
+	}
+	return 0, io.ErrClosedPipe
+}
+
+// Rejected reports whether the body wasn't sent to origin because quota was exhausted.
+func (b *continueBody) Rejected() bool {
+	return atomic.LoadInt32(&b.state) == bodyRejected
+}
+
+// Release frees up quota if it was acquired by the body.
+// The body can't acquire quota after it was released.
+func (b *continueBody) Release() {
+	if atomic.SwapInt32(&b.state, bodyReleased) == bodyAcquired {
+		b.release()
+	}
+}
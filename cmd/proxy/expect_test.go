@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/marselester/capacity"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestContinueBody(t *testing.T) {
+	// usedDuringBody is how much quota was used while origin was reading the body.
+	var usedDuringBody int64 = -1
+	var q *capacity.Quota
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Origin rejects the upload without asking for the body.
+		if r.URL.Path == "/reject" {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		// The proxy abandons the body it didn't have quota for, so the read fails.
+		if _, err := io.ReadAll(r.Body); err != nil {
+			return
+		}
+		atomic.StoreInt64(&usedDuringBody, q.Used())
+	}))
+	defer origin.Close()
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ExpectContinueTimeout = time.Minute
+	rp := &httputil.ReverseProxy{
+		Director:  direct,
+		Transport: transport,
+		ErrorHandler: func(rw http.ResponseWriter, r *http.Request, err error) {
+			if uploadRejected(r) {
+				rw.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			rw.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	backends := newTestPool(t, 10, 0, origin.URL)
+	var breaker *CircuitBreaker
+	admit := &admitHandler{
+		classOf: func(*http.Request) (*capacity.Quota, Limiter) {
+			return q, nopLimiter{}
+		},
+		receive: func(_ context.Context, q *capacity.Quota) bool {
+			return q.Receive()
+		},
+		events:         nopQuotaEvents{},
+		expectContinue: true,
+		ages:           newInflightAges(),
+		balancer:       &roundRobin{pool: backends},
+		backends:       backends,
+		proxy:          rp,
+		unavailable: func(rw http.ResponseWriter, r *http.Request) {
+			rw.WriteHeader(http.StatusBadGateway)
+		},
+		reject: func(rw http.ResponseWriter, r *http.Request) {
+			rw.WriteHeader(http.StatusTooManyRequests)
+		},
+	}
+	proxy := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if !expectsContinue(r) {
+			t.Errorf("request doesn't expect 100 Continue")
+		}
+		admit.admission = breakerAdmission{
+			breaker:       breaker,
+			precedence:    "breaker",
+			disagreements: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "disagreements"}, []string{"winner"}),
+		}
+		admit.ServeHTTP(rw, r)
+	}))
+	defer proxy.Close()
+
+	tests := map[string]struct {
+		quota          int64
+		breakerOpen    bool
+		path           string
+		wantStatus     int
+		usedDuringBody int64
+	}{
+		"origin accepts": {quota: 1, path: "/upload", wantStatus: http.StatusOK, usedDuringBody: 1},
+		// Quota isn't consulted, otherwise the proxy would respond with 429.
+		"origin rejects": {quota: 0, path: "/reject", wantStatus: http.StatusRequestEntityTooLarge, usedDuringBody: -1},
+		"no quota":       {quota: 0, path: "/upload", wantStatus: http.StatusTooManyRequests, usedDuringBody: -1},
+		// Deferred quota doesn't let the upload bypass the rest of the admission.
+		"breaker open": {quota: 1, breakerOpen: true, path: "/upload", wantStatus: http.StatusServiceUnavailable, usedDuringBody: -1},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			q = capacity.NewQuota(tc.quota)
+			breaker = nil
+			if tc.breakerOpen {
+				breaker = openBreaker(t)
+			}
+			atomic.StoreInt64(&usedDuringBody, -1)
+
+			req, err := http.NewRequest(http.MethodPost, proxy.URL+tc.path, strings.NewReader(strings.Repeat("🐈", 1000)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Expect", "100-continue")
+			c := &http.Client{Transport: transport}
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tc.wantStatus {
+				t.Errorf("status %d, want %d", resp.StatusCode, tc.wantStatus)
+			}
+			if got := atomic.LoadInt64(&usedDuringBody); got != tc.usedDuringBody {
+				t.Errorf("used %d while origin read the body, want %d", got, tc.usedDuringBody)
+			}
+			if q.Used() != 0 {
+				t.Errorf("used %d, want 0 after the request", q.Used())
+			}
+		})
+	}
+}
+
+// The body is released by the proxy while the transport acquires quota for it.
+func TestContinueBodyReleasedWhileAcquiring(t *testing.T) {
+	q := capacity.NewQuota(1)
+	var body *continueBody
+	body = &continueBody{
+		ReadCloser: io.NopCloser(strings.NewReader("🐈")),
+		acquire: func() bool {
+			ok := q.Receive()
+			body.Release()
+			return ok
+		},
+		release: q.Release,
+	}
+
+	if _, err := body.Read(make([]byte, 8)); err != io.ErrClosedPipe {
+		t.Errorf("read error %v, want %v", err, io.ErrClosedPipe)
+	}
+	if q.Used() != 0 {
+		t.Errorf("used %d, want 0 once the body was released", q.Used())
+	}
+	body.Release()
+	if q.Used() != 0 {
+		t.Errorf("used %d, want 0 after the second release", q.Used())
+	}
+}
+
+func TestContinueBodyReleasedBeforeRead(t *testing.T) {
+	q := capacity.NewQuota(1)
+	body := &continueBody{
+		ReadCloser: io.NopCloser(strings.NewReader("🐈")),
+		acquire:    q.Receive,
+		release:    q.Release,
+	}
+	body.Release()
+
+	if _, err := body.Read(make([]byte, 8)); err != io.ErrClosedPipe {
+		t.Errorf("read error %v, want %v", err, io.ErrClosedPipe)
+	}
+	if q.Used() != 0 {
+		t.Errorf("used %d, want 0: a released body can't acquire quota", q.Used())
+	}
+}
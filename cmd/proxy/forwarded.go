@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// setForwardedHeaders tells origin how a client reached the proxy.
+// It must be called before Host is rewritten.
+// Note, X-Forwarded-For is appended with the client's IP by httputil.ReverseProxy itself.
+//
+// When trusted proxies are configured, the real client IP is sent in X-Real-IP,
+// and X-Forwarded-For from untrusted clients is discarded since it could be spoofed.
+func setForwardedHeaders(r *http.Request, trusted trustedProxies) {
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	r.Header.Set("X-Forwarded-Proto", proto)
+	r.Header.Set("X-Forwarded-Host", r.Host)
+
+	if len(trusted) == 0 {
+		return
+	}
+	if !trusted.Contains(remoteIP(r)) {
+		r.Header.Del("X-Forwarded-For")
+	}
+	if ip := trusted.ClientIP(r); ip != nil {
+		r.Header.Set("X-Real-IP", ip.String())
+	}
+}
+
+// omitForwardedFor stops httputil.ReverseProxy from sending X-Forwarded-For to origin.
+func omitForwardedFor(r *http.Request) {
+	r.Header["X-Forwarded-For"] = nil
+}
+
+// trustedProxies are networks of proxies whose X-Forwarded-For headers can be trusted.
+type trustedProxies []*net.IPNet
+
+// parseTrustedProxies parses a comma-separated list of CIDRs or IP addresses, e.g., 10.0.0.0/8,192.168.1.1.
+func parseTrustedProxies(spec string) (trustedProxies, error) {
+	var tp trustedProxies
+	for _, s := range strings.Split(spec, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("trusted proxy %q: invalid IP address", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			tp = append(tp, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", s, err)
+		}
+		tp = append(tp, n)
+	}
+	return tp, nil
+}
+
+// Contains reports whether ip belongs to a trusted proxy.
+func (tp trustedProxies) Contains(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range tp {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the real client IP of a request.
+// X-Forwarded-For is walked from right to left skipping trusted proxies,
+// the first untrusted address is the client, because only trusted proxies could have appended it.
+// If all the addresses are trusted, the leftmost one is returned.
+func (tp trustedProxies) ClientIP(r *http.Request) net.IP {
+	ip := remoteIP(r)
+	if !tp.Contains(ip) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		// A malformed address can't be trusted, so the last valid hop is the client.
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !tp.Contains(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// remoteIP returns the IP address of a peer which sent the request.
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+)
+
+// forwardingProxy proxies requests to an origin which responds with the request headers it received.
+// The director is applied before the request is directed to origin.
+func forwardingProxy(t *testing.T, director func(r *http.Request)) *httptest.Server {
+	t.Helper()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(r.Header)
+	}))
+	t.Cleanup(origin.Close)
+
+	u, _ := url.Parse(origin.URL)
+	proxy := httputil.NewSingleHostReverseProxy(u)
+	direct := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		direct(r)
+	}
+	srv := httptest.NewServer(proxy)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// forwardedHeader sends a request with the given X-Forwarded-For header via the proxy
+// and returns the headers origin received.
+func forwardedHeader(t *testing.T, proxyURL, xff string) http.Header {
+	t.Helper()
+
+	req, _ := http.NewRequest(http.MethodGet, proxyURL, nil)
+	req.Host = "cats.example.com"
+	if xff != "" {
+		req.Header.Set("X-Forwarded-For", xff)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var h http.Header
+	if err = json.NewDecoder(resp.Body).Decode(&h); err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestSetForwardedHeaders(t *testing.T) {
+	srv := forwardingProxy(t, func(r *http.Request) {
+		setForwardedHeaders(r, nil)
+	})
+
+	tests := map[string]struct {
+		xff  string
+		want string
+	}{
+		"direct client": {want: "127.0.0.1"},
+		"chained":       {xff: "203.0.113.7, 10.0.0.1", want: "203.0.113.7, 10.0.0.1, 127.0.0.1"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := forwardedHeader(t, srv.URL, tc.xff)
+			if got := h.Get("X-Forwarded-For"); got != tc.want {
+				t.Errorf("X-Forwarded-For %q, want %q", got, tc.want)
+			}
+			if got := h.Get("X-Forwarded-Proto"); got != "http" {
+				t.Errorf("X-Forwarded-Proto %q, want http", got)
+			}
+			if got := h.Get("X-Forwarded-Host"); got != "cats.example.com" {
+				t.Errorf("X-Forwarded-Host %q, want cats.example.com", got)
+			}
+		})
+	}
+}
+
+func TestOmitForwardedFor(t *testing.T) {
+	srv := forwardingProxy(t, omitForwardedFor)
+	h := forwardedHeader(t, srv.URL, "203.0.113.7")
+	if got := h.Values("X-Forwarded-For"); len(got) != 0 {
+		t.Errorf("X-Forwarded-For %q, want none", got)
+	}
+}
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	trusted, err := parseTrustedProxies("10.0.0.0/8, 192.168.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		remoteAddr string
+		xff        string
+		want       string
+	}{
+		"spoofed by untrusted client": {remoteAddr: "203.0.113.7:1234", xff: "1.2.3.4", want: "203.0.113.7"},
+		"behind trusted proxy":        {remoteAddr: "10.0.0.1:1234", xff: "203.0.113.7", want: "203.0.113.7"},
+		"trusted hops are skipped":    {remoteAddr: "10.0.0.1:1234", xff: "1.2.3.4, 203.0.113.7, 192.168.1.1, 10.0.0.2", want: "203.0.113.7"},
+		"all hops trusted":            {remoteAddr: "10.0.0.1:1234", xff: "10.0.0.3, 10.0.0.2", want: "10.0.0.3"},
+		"malformed hop":               {remoteAddr: "10.0.0.1:1234", xff: "203.0.113.7, garbage, 10.0.0.2", want: "10.0.0.2"},
+		"no header":                   {remoteAddr: "10.0.0.1:1234", want: "10.0.0.1"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tc.remoteAddr
+			if tc.xff != "" {
+				r.Header.Set("X-Forwarded-For", tc.xff)
+			}
+			if got := trusted.ClientIP(r).String(); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSetForwardedHeadersUntrusted(t *testing.T) {
+	trusted, err := parseTrustedProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The test client connects from 127.0.0.1 which isn't trusted, so its X-Forwarded-For is ignored.
+	srv := forwardingProxy(t, func(r *http.Request) {
+		setForwardedHeaders(r, trusted)
+	})
+	h := forwardedHeader(t, srv.URL, "1.2.3.4")
+	if got := h.Get("X-Forwarded-For"); got != "127.0.0.1" {
+		t.Errorf("X-Forwarded-For %q, want 127.0.0.1", got)
+	}
+	if got := h.Get("X-Real-IP"); got != "127.0.0.1" {
+		t.Errorf("X-Real-IP %q, want 127.0.0.1", got)
+	}
+}
+
+func TestParseTrustedProxiesError(t *testing.T) {
+	for _, spec := range []string{"10.0.0.0/33", "not-an-ip", "10.0.0.0/8,300.1.1.1"} {
+		if _, err := parseTrustedProxies(spec); err == nil {
+			t.Errorf("expected an error for %q", spec)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/marselester/capacity"
+)
+
+// GoodputOptimizer searches for target concurrency which maximizes goodput (successful responses per second).
+// It's a hill climber: target concurrency is perturbed by a step every interval,
+// and the direction of the steps is reversed when goodput drops.
+type GoodputOptimizer struct {
+	quota *capacity.Quota
+	// successes is how many successful responses were observed since the last step.
+	successes int64
+
+	// mu guards the optimizer's state.
+	mu sync.Mutex
+	// direction is either 1 or -1.
+	direction int64
+	// lastGoodput is goodput observed during the previous interval.
+	lastGoodput float64
+	// min and max bound target concurrency.
+	min int64
+	max int64
+}
+
+// NewGoodputOptimizer creates an optimizer of quota's target concurrency within [min, max] bounds.
+func NewGoodputOptimizer(q *capacity.Quota, min, max int64) *GoodputOptimizer {
+	o := GoodputOptimizer{
+		quota:     q,
+		direction: 1,
+		min:       min,
+		max:       max,
+	}
+	return &o
+}
+
+// Observe counts successful responses.
+func (o *GoodputOptimizer) Observe(_ time.Duration, overloaded bool) {
+	if !overloaded {
+		atomic.AddInt64(&o.successes, 1)
+	}
+}
+
+// Run perturbs target concurrency every interval.
+func (o *GoodputOptimizer) Run(interval time.Duration, clock sampleClock) {
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for now := range ticker.C {
+		o.Step(now.Sub(last))
+		last = now
+	}
+}
+
+// Step measures goodput over the elapsed time since the previous step
+// and moves target concurrency by one towards higher goodput.
+func (o *GoodputOptimizer) Step(elapsed time.Duration) {
+	successes := atomic.SwapInt64(&o.successes, 0)
+	// Idle periods say nothing about the goodput curve.
+	if successes == 0 || elapsed <= 0 {
+		return
+	}
+
+	goodput := float64(successes) / elapsed.Seconds()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if goodput < o.lastGoodput {
+		o.direction = -o.direction
+	}
+	o.lastGoodput = goodput
+
+	limit := o.quota.Max() + o.direction
+	switch {
+	case limit < o.min:
+		limit = o.min
+		o.direction = 1
+	case limit > o.max:
+		limit = o.max
+		o.direction = -1
+	}
+	o.quota.Set(limit)
+}
+
+// State returns the optimizer's internal state.
+func (o *GoodputOptimizer) State() map[string]interface{} {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return map[string]interface{}{
+		"direction":    o.direction,
+		"last_goodput": o.lastGoodput,
+	}
+}
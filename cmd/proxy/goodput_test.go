@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marselester/capacity"
+)
+
+func TestGoodputOptimizerConverges(t *testing.T) {
+	// Goodput grows with concurrency till origin saturates at 20 in-flight requests,
+	// then it declines because of contention.
+	curve := func(concurrency int64) int64 {
+		if concurrency <= 20 {
+			return 10 * concurrency
+		}
+		return 200 - 5*(concurrency-20)
+	}
+
+	for _, start := range []int64{5, 40} {
+		q := capacity.NewQuota(start)
+		o := NewGoodputOptimizer(q, 1, 100)
+		for i := 0; i < 200; i++ {
+			for n := curve(q.Max()); n > 0; n-- {
+				o.Observe(time.Millisecond, false)
+			}
+			o.Step(time.Second)
+			// Once it reached the peak, the optimizer keeps probing around it.
+			if i >= 100 && (q.Max() < 18 || q.Max() > 22) {
+				t.Fatalf("start %d, step %d: max %d, want about 20", start, i, q.Max())
+			}
+		}
+	}
+}
+
+func TestGoodputOptimizerIdle(t *testing.T) {
+	q := capacity.NewQuota(10)
+	o := NewGoodputOptimizer(q, 1, 100)
+	for i := 0; i < 10; i++ {
+		o.Step(time.Second)
+	}
+	if q.Max() != 10 {
+		t.Errorf("max %d, want 10 since there was no traffic", q.Max())
+	}
+}
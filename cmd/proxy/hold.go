@@ -0,0 +1,13 @@
+package main
+
+import "time"
+
+// releaseHeld calls release to free up a quota slot once it was held for at least hold since it was acquired.
+// Holding slots bounds request rate to max/hold even when origin responds quickly.
+func releaseHeld(release func(), acquired time.Time, hold time.Duration) {
+	if wait := hold - time.Since(acquired); wait > 0 {
+		time.AfterFunc(wait, release)
+		return
+	}
+	release()
+}
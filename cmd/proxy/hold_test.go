@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marselester/capacity"
+)
+
+func TestReleaseHeldBoundsRate(t *testing.T) {
+	// Two slots held for 50ms allow at most 40 requests per second.
+	q := capacity.NewQuota(2)
+	const hold = 50 * time.Millisecond
+
+	var admitted int
+	begun := time.Now()
+	for time.Since(begun) < 250*time.Millisecond {
+		if !q.Receive() {
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		admitted++
+		// Origin responds instantly.
+		releaseHeld(q.Release, time.Now(), hold)
+	}
+	if admitted > 12 || admitted < 6 {
+		t.Errorf("admitted %d requests in 250ms, want at most 12 at 40 rps", admitted)
+	}
+
+	// A slot which was held longer than the minimum is released right away.
+	for q.Used() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	q.Receive()
+	releaseHeld(q.Release, time.Now().Add(-hold), hold)
+	if q.Used() != 0 {
+		t.Errorf("used %d, want 0", q.Used())
+	}
+}
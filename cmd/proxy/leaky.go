@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/marselester/capacity"
+)
+
+// LeakyBucket shapes bursty traffic into a steady outflow of requests:
+// requests leak from the bucket to origin at a constant rate,
+// and the bucket holds up to quota's target concurrency of waiting requests.
+// Requests which don't fit into the bucket overflow, i.e., they are rejected.
+type LeakyBucket struct {
+	quota *capacity.Quota
+	// interval is time between two leaked requests, e.g., 100ms for 10 requests per second.
+	interval time.Duration
+
+	mu sync.Mutex
+	// next is when the next request leaks from the bucket.
+	next time.Time
+}
+
+// NewLeakyBucket creates a bucket which leaks rate requests per second.
+func NewLeakyBucket(q *capacity.Quota, rate float64) *LeakyBucket {
+	b := LeakyBucket{
+		quota:    q,
+		interval: time.Duration(float64(time.Second) / rate),
+	}
+	return &b
+}
+
+// Observe does nothing, the leak rate is fixed.
+func (b *LeakyBucket) Observe(time.Duration, bool) {}
+
+// Admit puts a request into the bucket and blocks until it leaks.
+// It returns false if the bucket is full or ctx is done before the request leaked.
+func (b *LeakyBucket) Admit(ctx context.Context) bool {
+	wait, ok := b.reserve()
+	if !ok {
+		return false
+	}
+	if wait == 0 {
+		return true
+	}
+
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// reserve schedules a request to leak after the ones already in the bucket
+// and returns how long the request should wait.
+func (b *LeakyBucket) reserve() (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if b.next.Before(now) {
+		b.next = now
+	}
+	wait := b.next.Sub(now)
+	// The level of the bucket is how many requests wait to leak.
+	if level := int64(wait / b.interval); level >= b.quota.Max() {
+		return 0, false
+	}
+	b.next = b.next.Add(b.interval)
+
+	return wait, true
+}
+
+// State returns the bucket's level.
+func (b *LeakyBucket) State() map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var level int64
+	if wait := time.Until(b.next); wait > 0 {
+		level = int64(wait / b.interval)
+	}
+	return map[string]interface{}{
+		"level":    level,
+		"interval": b.interval.String(),
+	}
+}
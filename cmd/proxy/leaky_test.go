@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/marselester/capacity"
+)
+
+func TestLeakyBucketSmoothsBurst(t *testing.T) {
+	// 100 requests per second leak every 10ms, and the bucket holds 5 requests.
+	b := NewLeakyBucket(capacity.NewQuota(5), 100)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		leaked   []time.Duration
+		overflow int
+	)
+	begun := time.Now()
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ok := b.Admit(context.Background())
+			mu.Lock()
+			defer mu.Unlock()
+			if !ok {
+				overflow++
+				return
+			}
+			leaked = append(leaked, time.Since(begun))
+		}()
+	}
+	wg.Wait()
+
+	// The first request leaked right away while 5 more waited in the bucket.
+	if overflow != 4 || len(leaked) != 6 {
+		t.Fatalf("leaked %d and overflowed %d requests, want 6 and 4", len(leaked), overflow)
+	}
+	// The burst leaked to origin at the steady rate.
+	sort.Slice(leaked, func(i, j int) bool { return leaked[i] < leaked[j] })
+	for i := 1; i < len(leaked); i++ {
+		if gap := leaked[i] - leaked[i-1]; gap < 8*time.Millisecond {
+			t.Errorf("requests %d and %d leaked %v apart, want about 10ms", i-1, i, gap)
+		}
+	}
+	if got := leaked[len(leaked)-1]; got < 50*time.Millisecond {
+		t.Errorf("the last request leaked after %v, want at least 50ms", got)
+	}
+
+	// The bucket is empty once the requests leaked.
+	time.Sleep(10 * time.Millisecond)
+	if !b.Admit(context.Background()) {
+		t.Error("empty bucket rejected a request")
+	}
+}
+
+func TestLeakyBucketCancel(t *testing.T) {
+	b := NewLeakyBucket(capacity.NewQuota(5), 1)
+	b.Admit(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if b.Admit(ctx) {
+		t.Error("request leaked before its turn")
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+
+	"github.com/marselester/capacity"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+// Limiter adjusts target concurrency of a quota based on responses observed from origin.
+type Limiter interface {
+	// Observe records a response from origin: how long it took and whether origin was overloaded.
+	Observe(rtt time.Duration, overloaded bool)
+}
+
+// periodicLimiter is a limiter which also adjusts quota every interval, e.g., by sampling its utilization.
+type periodicLimiter interface {
+	Limiter
+	// Run adjusts quota every interval, ticks are delivered by clock.
+	Run(interval time.Duration, clock sampleClock)
+}
+
+// admitter is a limiter which decides whether a request is admitted before it acquires quota.
+type admitter interface {
+	// Admit blocks until a request is admitted or returns false if it's rejected.
+	Admit(ctx context.Context) bool
+}
+
+// stateReporter is a limiter which can report its internal state, e.g., for debugging.
+type stateReporter interface {
+	State() map[string]interface{}
+}
+
+// limiterConfig holds parameters of capacity control algorithms.
+type limiterConfig struct {
+	quota    int64
+	minQuota int64
+	maxQuota int64
+	// incRate, incStep, incBurst, incPrewarm, and incOnDemand control additive increase of aimd and composite algorithms.
+	incRate     float64
+	incStep     int64
+	incBurst    int
+	incPrewarm  bool
+	incOnDemand bool
+	// errorWindow, errorThreshold, and backoffFloor control proportional back-off of aimd algorithm.
+	errorWindow    int
+	errorThreshold float64
+	backoffFloor   float64
+	// targetUtilization is a setpoint of utilization algorithm.
+	targetUtilization float64
+	// latencyTolerance is how many times round-trip time can exceed the baseline for utilization algorithm to raise quota.
+	latencyTolerance float64
+	// latencySetpoint, kp, and ki control pid algorithm.
+	latencySetpoint time.Duration
+	kp              float64
+	ki              float64
+	// leakRate is how many requests per second leak from the bucket of leaky algorithm.
+	leakRate float64
+	// rttWindow is how many recent round-trip times pid algorithm averages to smooth out latency noise.
+	rttWindow int
+	// shortWindow and longWindow are how many recent round-trip times gradient algorithm compares minimums of.
+	shortWindow int
+	longWindow  int
+	// signals and signalSpec are overload signals of composite algorithm.
+	signals    func() []Signal
+	signalSpec string
+}
+
+// newLimiter creates a limiter of the given algorithm which adjusts quota q.
+// If the limiter is periodic, its Run method must be called.
+func newLimiter(algorithm string, q *capacity.Quota, c limiterConfig) Limiter {
+	switch algorithm {
+	case "aimd":
+		l := aimdLimiter{
+			quota:      q,
+			incLimiter: c.newIncLimiter(),
+			incStep:    c.incStep,
+			onDemand:   c.incOnDemand,
+		}
+		if c.errorWindow > 0 {
+			l.errors = newOutcomeWindow(c.errorWindow)
+			l.errorThreshold = c.errorThreshold
+			l.backoffFloor = c.backoffFloor
+		}
+		return &l
+	case "utilization":
+		return NewUtilizationController(q, c.targetUtilization, c.minQuota, c.maxQuota, c.latencyTolerance)
+	case "goodput":
+		return NewGoodputOptimizer(q, c.minQuota, c.maxQuota)
+	case "leaky":
+		return NewLeakyBucket(q, c.leakRate)
+	case "composite":
+		l := compositeLimiter{
+			quota:      q,
+			signals:    c.signals(),
+			incLimiter: c.newIncLimiter(),
+			incStep:    c.incStep,
+			onDemand:   c.incOnDemand,
+		}
+		return &l
+	case "gradient":
+		return gradientLimiter{capacity.NewGradientLimiter(q, c.shortWindow, c.longWindow)}
+	case "pid":
+		l := pidLimiter{
+			quota: q,
+			limit: NewPIDLimit(c.quota, c.latencySetpoint, c.kp, c.ki, c.minQuota, c.maxQuota),
+		}
+		if c.rttWindow > 1 {
+			l.rtts = newRTTWindow(c.rttWindow)
+		}
+		return &l
+	}
+	return nopLimiter{}
+}
+
+// newIncLimiter creates a limiter which throttles additive increase happening on every HTTP 200 OK response.
+// Increases accumulate up to a burst while the proxy is idle,
+// so it can recover capacity in a step rather than one step per increase interval.
+func (c limiterConfig) newIncLimiter() *rate.Limiter {
+	l := rate.NewLimiter(rate.Limit(c.incRate), c.incBurst)
+	if !c.incPrewarm {
+		l.AllowN(time.Now(), c.incBurst)
+	}
+	return l
+}
+
+// params returns key parameters of the given algorithm, e.g., for proxy_limiter_info metric.
+func (c limiterConfig) params(algorithm string) string {
+	params := fmt.Sprintf("quota=%d,min=%d,max=%d", c.quota, c.minQuota, c.maxQuota)
+	switch algorithm {
+	case "static":
+		return fmt.Sprintf("quota=%d", c.quota)
+	case "aimd":
+		if c.errorWindow > 0 {
+			params += fmt.Sprintf(",error_window=%d,error_threshold=%v,backoff_floor=%v", c.errorWindow, c.errorThreshold, c.backoffFloor)
+		} else {
+			params += ",backoff=0.75"
+		}
+		params += fmt.Sprintf(",inc_rate=%v,inc_step=%d,inc_burst=%d,inc_on_demand=%t", c.incRate, c.incStep, c.incBurst, c.incOnDemand)
+	case "utilization":
+		params += fmt.Sprintf(",target=%v,latency_tolerance=%v", c.targetUtilization, c.latencyTolerance)
+	case "goodput":
+		params += ",step=1"
+	case "leaky":
+		params += fmt.Sprintf(",leak_rate=%v", c.leakRate)
+	case "composite":
+		params += fmt.Sprintf(",signals=%s,backoff=0.75,inc_rate=%v,inc_step=%d,inc_burst=%d,inc_on_demand=%t", c.signalSpec, c.incRate, c.incStep, c.incBurst, c.incOnDemand)
+	case "gradient":
+		params += fmt.Sprintf(",short_window=%d,long_window=%d", c.shortWindow, c.longWindow)
+	case "pid":
+		params += fmt.Sprintf(",setpoint=%v,kp=%v,ki=%v", c.latencySetpoint, c.kp, c.ki)
+		if c.rttWindow > 1 {
+			params += fmt.Sprintf(",rtt_window=%d", c.rttWindow)
+		}
+	}
+	return params
+}
+
+// isShed reports whether origin shed a request with the response status 429 or 503.
+func isShed(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
+
+// feedsLimiter reports whether a response with the status is observed by limiters.
+// Origin's load shedding responses are neither overload nor success signals
+// unless they are treated as overload.
+func feedsLimiter(status int, shedAsOverload bool) bool {
+	return !isShed(status) || shedAsOverload
+}
+
+// newLimiterInfo creates proxy_limiter_info metric describing the given algorithm and its parameters.
+func newLimiterInfo(algorithm string, c limiterConfig) *prometheus.GaugeVec {
+	info := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "proxy_limiter_info",
+			Help: "Capacity control algorithm and its parameters, the value is always 1.",
+		},
+		[]string{"algorithm", "params", "version"},
+	)
+	info.With(prometheus.Labels{
+		"algorithm": algorithm,
+		"params":    c.params(algorithm),
+		"version":   version,
+	}).Set(1)
+	return info
+}
+
+// nopLimiter never changes quota.
+type nopLimiter struct{}
+
+// Observe does nothing.
+func (nopLimiter) Observe(time.Duration, bool) {}
+
+// aimdLimiter adjusts quota using additive-increase/multiplicative-decrease algorithm.
+type aimdLimiter struct {
+	quota      *capacity.Quota
+	incLimiter *rate.Limiter
+	// incStep is how much quota grows by on every additive increase.
+	incStep int64
+	// onDemand allows an increase only if quota ran out since the previous one.
+	onDemand bool
+	// errors is a window of recent outcomes to calculate error rate for proportional back-off.
+	// When it's nil, quota is backed off on every error.
+	errors *outcomeWindow
+	// errorThreshold is an error rate above which quota is backed off.
+	errorThreshold float64
+	// backoffFloor is the smallest fraction quota is backed off to, e.g., 0.5.
+	backoffFloor float64
+}
+
+// Observe backs off quota when origin is overloaded,
+// otherwise it increases quota by a step at most inc-rate times a second.
+func (l *aimdLimiter) Observe(_ time.Duration, overloaded bool) {
+	switch {
+	case overloaded && l.errors == nil:
+		l.quota.Backoff(0.75)
+	case overloaded:
+		// Errors are tolerated until error rate exceeds the threshold.
+		if errorRate := l.errors.Record(true); errorRate > l.errorThreshold {
+			l.quota.Backoff(l.backoffFraction(errorRate))
+		}
+	default:
+		if l.errors != nil {
+			l.errors.Record(false)
+		}
+		// Increase target concurrency by a constant c per unit time,
+		// e.g., allow 1 more rps every second if there is a demand.
+		// Successful responses alone aren't a demand, quota must have run out,
+		// otherwise it would be inflated while the proxy is idle.
+		if l.onDemand && !l.quota.Saturated() {
+			return
+		}
+		if l.incLimiter.Allow() {
+			l.quota.IncN(l.incStep)
+		}
+	}
+}
+
+// State returns error rate within the window when back-off is proportional.
+func (l *aimdLimiter) State() map[string]interface{} {
+	if l.errors == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"error_rate": l.errors.Rate(),
+	}
+}
+
+// backoffFraction returns a fraction to back off quota to when error rate exceeds the threshold.
+// The fraction scales with the overshoot: it's close to 1 when error rate barely exceeds the threshold,
+// and it reaches the floor when all requests fail.
+func (l *aimdLimiter) backoffFraction(errorRate float64) float64 {
+	overshoot := (errorRate - l.errorThreshold) / (1 - l.errorThreshold)
+	return 1 - overshoot*(1-l.backoffFloor)
+}
+
+// pidLimiter sets quota to a PI controlled latency limit.
+type pidLimiter struct {
+	quota *capacity.Quota
+	limit *PIDLimit
+	// rtts is a window of recent round-trip times whose average is fed to the controller.
+	// When it's nil, every round-trip time is fed as is.
+	rtts *rttWindow
+}
+
+// Observe feeds round-trip time to the controller and updates quota.
+func (l *pidLimiter) Observe(rtt time.Duration, _ bool) {
+	if l.rtts != nil {
+		rtt = l.rtts.Record(rtt)
+	}
+	l.quota.Set(l.limit.Observe(rtt))
+}
+
+// State returns the controller's internal state.
+func (l *pidLimiter) State() map[string]interface{} {
+	return l.limit.State()
+}
+
+// gradientLimiter feeds round-trip times to a gradient limiter,
+// requests are considered dropped when origin is overloaded.
+type gradientLimiter struct {
+	*capacity.GradientLimiter
+}
+
+// Observe samples round-trip time of a request.
+func (l gradientLimiter) Observe(rtt time.Duration, overloaded bool) {
+	l.Sample(rtt, overloaded)
+}
+
+// ObserveBaseline feeds round-trip time of a probe request to the limiter's long-window minimum.
+func (l gradientLimiter) ObserveBaseline(rtt time.Duration) {
+	l.SampleBaseline(rtt)
+}
+
+// State returns the limiter's fractional limit.
+func (l gradientLimiter) State() map[string]interface{} {
+	return map[string]interface{}{
+		"limit": l.Limit(),
+	}
+}
+
+// startKey is a context key of time when a request was forwarded to origin.
+type startKey struct{}
+
+// withStart returns a copy of ctx with the request start time.
+func withStart(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, startKey{}, t)
+}
+
+// firstByteKey is a context key of time when the first byte of origin's response was received.
+type firstByteKey struct{}
+
+// withFirstByteTrace returns a copy of ctx which records when the first byte of origin's response is received.
+// The request start time must already be in ctx.
+func withFirstByteTrace(ctx context.Context) context.Context {
+	var firstByte int64
+	ctx = context.WithValue(ctx, firstByteKey{}, &firstByte)
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotFirstResponseByte: func() {
+			atomic.StoreInt64(&firstByte, time.Now().UnixNano())
+		},
+	})
+}
+
+// timeToFirstByte returns time elapsed since the request start time until the first byte of origin's response,
+// or time elapsed so far if the first byte wasn't received yet.
+func timeToFirstByte(ctx context.Context) time.Duration {
+	start, _ := ctx.Value(startKey{}).(time.Time)
+	firstByte, _ := ctx.Value(firstByteKey{}).(*int64)
+	if start.IsZero() || firstByte == nil {
+		return sinceStart(ctx)
+	}
+	if t := atomic.LoadInt64(firstByte); t != 0 {
+		return time.Unix(0, t).Sub(start)
+	}
+	return time.Since(start)
+}
+
+// receivedKey is a context key of time when a request was received by the proxy before it was admitted.
+type receivedKey struct{}
+
+// withReceived returns a copy of ctx with the time the request was received.
+func withReceived(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, receivedKey{}, t)
+}
+
+// sinceReceived returns time elapsed since the request was received according to ctx.
+func sinceReceived(ctx context.Context) time.Duration {
+	t, ok := ctx.Value(receivedKey{}).(time.Time)
+	if !ok {
+		return 0
+	}
+	return time.Since(t)
+}
+
+// sinceStart returns time elapsed since the request start time found in ctx.
+func sinceStart(ctx context.Context) time.Duration {
+	t, ok := ctx.Value(startKey{}).(time.Time)
+	if !ok {
+		return 0
+	}
+	return time.Since(t)
+}
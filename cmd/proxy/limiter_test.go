@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marselester/capacity"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLimiterInfo(t *testing.T) {
+	c := limiterConfig{
+		quota:       10,
+		minQuota:    1,
+		maxQuota:    100,
+		shortWindow: 10,
+		longWindow:  100,
+	}
+	tests := map[string]string{
+		"static":   `proxy_limiter_info{algorithm="static",params="quota=10",version="dev"} 1`,
+		"gradient": `proxy_limiter_info{algorithm="gradient",params="quota=10,min=1,max=100,short_window=10,long_window=100",version="dev"} 1`,
+	}
+	for algorithm, want := range tests {
+		t.Run(algorithm, func(t *testing.T) {
+			expected := `
+# HELP proxy_limiter_info Capacity control algorithm and its parameters, the value is always 1.
+# TYPE proxy_limiter_info gauge
+` + want + "\n"
+			if err := testutil.CollectAndCompare(newLimiterInfo(algorithm, c), strings.NewReader(expected)); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestLimiterIncBurst(t *testing.T) {
+	tests := map[string]struct {
+		prewarm bool
+		idle    time.Duration
+		want    int64
+	}{
+		// Increases aren't available right after start unless the limiter is pre-warmed.
+		"cold":    {want: 10},
+		"prewarm": {prewarm: true, want: 15},
+		// Increases accumulated while the proxy was idle are allowed in a step up to the burst.
+		"idle": {idle: 200 * time.Millisecond, want: 15},
+	}
+	for _, algorithm := range []string{"aimd", "composite"} {
+		for name, tc := range tests {
+			t.Run(algorithm+"/"+name, func(t *testing.T) {
+				q := capacity.NewQuota(10)
+				l := newLimiter(algorithm, q, limiterConfig{
+					incRate:    50,
+					incStep:    1,
+					incBurst:   5,
+					incPrewarm: tc.prewarm,
+					signals:    func() []Signal { return nil },
+				})
+				time.Sleep(tc.idle)
+
+				for i := 0; i < 10; i++ {
+					l.Observe(time.Millisecond, false)
+				}
+				// One more increase could have accumulated while responses were observed.
+				if got := q.Max(); got < tc.want || got > tc.want+1 {
+					t.Errorf("max %d, want %d", got, tc.want)
+				}
+			})
+		}
+	}
+}
+
+// recordingLimiter remembers observed responses.
+type recordingLimiter struct {
+	samples []sample
+}
+
+func (l *recordingLimiter) Observe(rtt time.Duration, overloaded bool) {
+	l.samples = append(l.samples, sample{rtt: rtt, overloaded: overloaded})
+}
+
+func TestShadowLimiter(t *testing.T) {
+	live, shadow := &recordingLimiter{}, &recordingLimiter{}
+	want := []sample{
+		{rtt: time.Millisecond},
+		{rtt: 2 * time.Millisecond, overloaded: true},
+		{rtt: 3 * time.Millisecond},
+	}
+	for _, s := range want {
+		observeLimiters(s, live, shadow)
+	}
+	for i := range want {
+		l, s := live.samples[i], shadow.samples[i]
+		if l.rtt != want[i].rtt || l.overloaded != want[i].overloaded || s.rtt != want[i].rtt || s.overloaded != want[i].overloaded {
+			t.Errorf("observation %d: live %+v, shadow %+v, want %+v", i, l, s, want[i])
+		}
+	}
+
+	// Shadow limiter backs off its own quota, so enforcement is up to the live one.
+	liveQuota, shadowQuota := capacity.NewQuota(10), capacity.NewQuota(10)
+	c := limiterConfig{quota: 10, minQuota: 1, maxQuota: 100, latencySetpoint: 100 * time.Millisecond, kp: 5, ki: 1}
+	liveLimiter, shadowLimiter := newLimiter("static", liveQuota, c), newLimiter("pid", shadowQuota, c)
+	for i := 0; i < 10; i++ {
+		observeLimiters(sample{rtt: time.Second}, liveLimiter, shadowLimiter)
+	}
+	if liveQuota.Max() != 10 {
+		t.Errorf("live max %d, want 10", liveQuota.Max())
+	}
+	if shadowQuota.Max() >= 10 {
+		t.Errorf("shadow max %d, want below 10 since latency exceeded the setpoint", shadowQuota.Max())
+	}
+}
+
+func TestFeedsLimiterOriginShed(t *testing.T) {
+	tests := map[string]struct {
+		status         int
+		shedAsOverload bool
+		wantMax        int64
+	}{
+		"429 as overload":   {status: http.StatusTooManyRequests, shedAsOverload: true, wantMax: 8},
+		"503 as overload":   {status: http.StatusServiceUnavailable, shedAsOverload: true, wantMax: 8},
+		"429 ignored":       {status: http.StatusTooManyRequests, wantMax: 10},
+		"503 ignored":       {status: http.StatusServiceUnavailable, wantMax: 10},
+		"500 isn't ignored": {status: http.StatusInternalServerError, wantMax: 8},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := capacity.NewQuota(10)
+			l := newLimiter("aimd", q, limiterConfig{incRate: 1, incStep: 1, incBurst: 1})
+			if feedsLimiter(tc.status, tc.shedAsOverload) {
+				l.Observe(time.Millisecond, tc.status != http.StatusOK)
+			}
+			if q.Max() != tc.wantMax {
+				t.Errorf("max %d, want %d", q.Max(), tc.wantMax)
+			}
+		})
+	}
+}
+
+func TestAIMDLimiterBackoffFraction(t *testing.T) {
+	l := aimdLimiter{errorThreshold: 0.1, backoffFloor: 0.5}
+
+	prev := 1.0
+	for _, errorRate := range []float64{0.2, 0.4, 0.6, 0.8, 1} {
+		p := l.backoffFraction(errorRate)
+		if p >= prev {
+			t.Errorf("error rate %v: fraction %.3f, want below %.3f of a smaller overshoot", errorRate, p, prev)
+		}
+		prev = p
+	}
+	if p := l.backoffFraction(0.1); p != 1 {
+		t.Errorf("fraction %v at the threshold, want 1", p)
+	}
+	if p := l.backoffFraction(1); p != 0.5 {
+		t.Errorf("fraction %v when all requests fail, want the floor 0.5", p)
+	}
+}
+
+func TestAIMDLimiterProportionalBackoff(t *testing.T) {
+	q := capacity.NewQuota(100)
+	l := newLimiter("aimd", q, limiterConfig{
+		incRate:        1,
+		incStep:        1,
+		incBurst:       1,
+		errorWindow:    10,
+		errorThreshold: 0.2,
+		backoffFloor:   0.5,
+	})
+
+	for i := 0; i < 8; i++ {
+		l.Observe(time.Millisecond, false)
+	}
+	// Errors are tolerated till error rate exceeds the threshold.
+	l.Observe(time.Millisecond, true)
+	l.Observe(time.Millisecond, true)
+	if q.Max() != 100 {
+		t.Fatalf("max %d, want 100 while errors are within the threshold", q.Max())
+	}
+	// Error rate 0.3 barely exceeds the threshold, so quota is backed off to 1-(0.3-0.2)/(1-0.2)*(1-0.5).
+	l.Observe(time.Millisecond, true)
+	if q.Max() != 94 {
+		t.Errorf("max %d, want a gentle back-off to 94", q.Max())
+	}
+}
+
+func TestTimeToFirstByte(t *testing.T) {
+	// Origin is slow to respond with headers, and then it's even slower to send the body.
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		io.WriteString(w, "🐈")
+	}))
+	defer origin.Close()
+
+	ctx := withStart(context.Background(), time.Now())
+	ctx = withFirstByteTrace(ctx)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, origin.URL, nil)
+	resp, err := origin.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	ttfb, total := timeToFirstByte(ctx), sinceStart(ctx)
+	if ttfb < 50*time.Millisecond || ttfb >= 150*time.Millisecond {
+		t.Errorf("ttfb %v, want about 50ms", ttfb)
+	}
+	if total < 150*time.Millisecond {
+		t.Errorf("total %v, want at least 150ms", total)
+	}
+	// Without the trace, time to the first byte is how long the request has taken so far.
+	ctx = withStart(context.Background(), time.Now().Add(-time.Second))
+	if got := timeToFirstByte(ctx); got < time.Second {
+		t.Errorf("ttfb %v without trace, want at least 1s", got)
+	}
+}
+
+// saturate fills quota q up and lets one more request be rejected, as clients demanding more capacity would.
+func saturate(q *capacity.Quota) {
+	for q.Receive() {
+	}
+	for q.Used() > 0 {
+		q.Release()
+	}
+}
+
+func TestAIMDLimiterIncOnDemand(t *testing.T) {
+	c := limiterConfig{incRate: 1000, incStep: 2, incBurst: 100, incPrewarm: true, incOnDemand: true}
+	q := capacity.NewQuota(10)
+	l := newLimiter("aimd", q, c)
+
+	// Successful responses flow while the proxy is idle, so quota isn't inflated.
+	for i := 0; i < 20; i++ {
+		l.Observe(time.Millisecond, false)
+	}
+	if q.Max() != 10 {
+		t.Fatalf("max %d was increased without demand", q.Max())
+	}
+
+	// Quota ran out once, so it's increased by a step once.
+	saturate(q)
+	for i := 0; i < 20; i++ {
+		l.Observe(time.Millisecond, false)
+	}
+	if q.Max() != 12 {
+		t.Errorf("max %d, want 12 after a single saturation", q.Max())
+	}
+
+	// Without the demand gate every successful response increases quota.
+	c.incOnDemand = false
+	q = capacity.NewQuota(10)
+	l = newLimiter("aimd", q, c)
+	for i := 0; i < 5; i++ {
+		l.Observe(time.Millisecond, false)
+	}
+	if q.Max() != 20 {
+		t.Errorf("max %d, want 20", q.Max())
+	}
+}
+
+func TestAIMDLimiterIncRate(t *testing.T) {
+	// One increase is allowed per 100ms.
+	c := limiterConfig{incRate: 10, incStep: 5, incBurst: 1, incPrewarm: true}
+	q := capacity.NewQuota(10)
+	l := newLimiter("aimd", q, c)
+
+	for i := 0; i < 10; i++ {
+		l.Observe(time.Millisecond, false)
+	}
+	if q.Max() != 15 {
+		t.Errorf("max %d, want 15 after a burst of one increase", q.Max())
+	}
+	time.Sleep(110 * time.Millisecond)
+	l.Observe(time.Millisecond, false)
+	if q.Max() != 20 {
+		t.Errorf("max %d, want 20 once the increase rate allowed another step", q.Max())
+	}
+}
@@ -2,148 +2,1004 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
-	"math"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	_ "net/http/pprof"
-	"net/url"
+	"os"
+	"os/signal"
+	"path"
 	"runtime"
-	"sync/atomic"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/marselester/capacity"
+	"github.com/marselester/capacity/internal/derive"
+	"github.com/marselester/capacity/internal/stress"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"golang.org/x/time/rate"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// version is the proxy's build version which can be set with -ldflags "-X main.version=v1.0.0".
+var version = "dev"
+
 func main() {
-	originAddr := flag.String("origin", "http://localhost:8000", "origin address where to proxy requests")
+	originAddr := flag.String("origin", "http://localhost:8000", "comma-separated origin addresses where to proxy requests, each can be followed by its quota, e.g., http://localhost:8000=10")
+	balancerName := flag.String("balancer", "round-robin", "how to pick an origin: round-robin, least-conn, p2c (power of two choices)")
+	backendQuotaFlag := flag.Int64("backend-quota", 0, "allowed number of concurrent requests per origin, 0 means it's the same as -quota")
 	addr := flag.String("addr", ":7000", "address to listen to")
+	adminAddr := flag.String("admin-addr", "localhost:7001", "address to serve /admin/ endpoints on; they change quota and origins, so keep it private")
 	quota := flag.Int64("quota", 5, "allowed number of concurrent requests")
 	adaptive := flag.Bool("adaptive", false, "adaptive capacity control")
+	algorithm := flag.String("algorithm", "aimd", "adaptive capacity control algorithm: aimd, utilization, pid, goodput, leaky, gradient, composite")
+	signalSpec := flag.String("signals", "error-rate=0.1", "comma-separated name=threshold overload signals of composite algorithm: error-rate, latency, queue-depth, e.g., error-rate=0.1,latency=500ms,queue-depth=50")
+	signalWindow := flag.Int("signal-window", 20, "how many recent responses error-rate and latency signals are calculated over")
+	queueDepthHeader := flag.String("queue-depth-header", "X-Queue-Depth", "origin's response header which reports its queue depth to queue-depth signal")
+	leakRate := flag.Float64("leak-rate", 10, "how many requests per second are let through to origin with leaky algorithm, the bucket holds up to -quota waiting requests")
+	minQuota := flag.Int64("min-quota", 1, "the least allowed number of concurrent requests with adaptive capacity control")
+	maxQuota := flag.Int64("max-quota", 100, "the most allowed number of concurrent requests with adaptive capacity control")
+	targetUtilization := flag.Float64("target-utilization", 0.8, "utilization (in-flight/quota) to maintain with utilization algorithm")
+	utilizationLatencyTolerance := flag.Float64("utilization-latency-tolerance", 2, "how many times the average round-trip time can exceed the lowest one for utilization algorithm to raise quota, 0 means latency isn't checked")
+	latencySetpoint := flag.Duration("latency-setpoint", time.Second, "round-trip time of a request to maintain with pid algorithm")
+	kp := flag.Float64("kp", 2, "proportional gain of pid algorithm")
+	ki := flag.Float64("ki", 0.5, "integral gain of pid algorithm")
+	rttWindow := flag.Int("rtt-window", 1, "how many recent round-trip times pid algorithm averages to smooth out latency noise, 1 means no smoothing")
+	shortWindow := flag.Int("gradient-short-window", 10, "how many recent round-trip times gradient algorithm takes the minimum of to estimate current queueing")
+	longWindow := flag.Int("gradient-long-window", 600, "how many recent round-trip times gradient algorithm takes the minimum of to estimate latency without queueing")
+	incRate := flag.Float64("inc-rate", 1, "how many additive increases per second are allowed with aimd and composite algorithms")
+	incStep := flag.Int64("inc-step", 1, "how much quota grows by on every additive increase with aimd and composite algorithms")
+	incOnDemand := flag.Bool("inc-on-demand", true, "increase quota only if it ran out since the previous increase, so it isn't inflated while there is no demand")
+	incBurst := flag.Int("inc-burst", 1, "how many additive increases are allowed at once after an idle period with aimd and composite algorithms")
+	incPrewarm := flag.Bool("inc-prewarm", true, "allow a burst of additive increases right after start with aimd and composite algorithms")
+	maintenanceFile := flag.String("maintenance-file", "", "file to serve instead of 502 Bad Gateway when all origins are ejected")
+	maintenanceStatus := flag.Int("maintenance-status", http.StatusServiceUnavailable, "status code of the maintenance page")
+	maintenanceContentType := flag.String("maintenance-content-type", "text/html; charset=utf-8", "content type of the maintenance page")
+	breakerEnabled := flag.Bool("breaker", false, "fast-fail requests with 503 for -breaker-cooldown when error rate of recent requests exceeds -breaker-threshold")
+	breakerWindow := flag.Int("breaker-window", 20, "how many recent requests circuit breaker uses to calculate error rate")
+	breakerThreshold := flag.Float64("breaker-threshold", 0.5, "error rate (5xx, 429, connection errors) above which circuit breaker opens")
+	breakerPrecedence := flag.String("breaker-precedence", "breaker", "who wins when circuit breaker is open but the limiter has quota to spare: breaker (fast-fail with 503) or limiter (forward the request)")
+	breakerCooldown := flag.Duration("breaker-cooldown", 5*time.Second, "how long circuit breaker stays open before it lets a probe request through")
+	traceQuotaEvents := flag.Bool("trace-quota-events", false, "write a trace span of every request to stdout with quota_acquired, quota_released, and quota_rejected events and quota usage at those moments")
+	sampleJitter := flag.Duration("sample-jitter", 0, "delay every tick of metric sampling and control loops by a random duration up to this, to check they stay correct when sampling is irregular")
+	deriveInterval := flag.Duration("derive-interval", 0, "how often rejection ratio, goodput, utilization and optimal concurrency gauges are derived from raw metrics, 0 disables them")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "how long to wait for in-flight requests to finish on SIGINT/SIGTERM")
+	shedWeightsSpec := flag.String("shed-weights", "", "fractions of requests to drop per class once quota is saturated, e.g., low:0.9,normal:0.1; a class is a value of -priority-header")
+	priorityHeader := flag.String("priority-header", "X-Priority", "request header which holds a class of the request for -shed-weights")
+	shedSaturation := flag.Float64("shed-saturation", 0.9, "quota utilization (in-flight requests / target concurrency) from which -shed-weights apply")
+	warmupRequests := flag.Int64("warmup-requests", 0, "how many responses to wait for before adaptive control begins, the quota stays at -quota until then")
+	requestDeadline := flag.Duration("request-deadline", 0, "how long a request can take since it was received before origin abandons it, the deadline is forwarded in X-Request-Deadline header; 0 means no deadline")
+	postBackoffHold := flag.Duration("post-backoff-hold", 0, "how long successful responses don't increase quota after a back-off, so that the back-off takes effect first")
+	minSlotHold := flag.Duration("min-slot-hold", 0, "the least time a quota slot is held before it can be reused, so request rate doesn't exceed quota/min-slot-hold; 0 releases slots right away")
+	decisionLogSize := flag.Int("decisions", 100, "how many recent quota increase and back-off decisions to keep for GET /admin/decisions, 0 disables the log")
+	problemJSON := flag.Bool("problem-json", false, "respond to rejected requests and origin errors with RFC 7807 application/problem+json bodies")
+	problemTypeURI := flag.String("problem-type-uri", "", "URI prefix of problem types with -problem-json, e.g., https://example.com/problems/; problem types are about:blank by default")
+	shadowAlgorithm := flag.String("shadow-algorithm", "", "adaptive capacity control algorithm to run in shadow mode without enforcing its quota: aimd, pid")
+	bypassPaths := flag.String("bypass-paths", "", "comma-separated path prefixes or glob patterns, e.g., /health,/static/*.css, which are proxied without consuming quota")
+	shedAsOverload := flag.Bool("treat-origin-shed-as-overload", true, "whether origin's 429 and 503 responses trigger adaptive back-off and their Retry-After holds quota growth")
+	errorWindow := flag.Int("error-window", 0, "how many recent responses aimd algorithm uses to calculate error rate for proportional back-off, 0 means back-off on every error")
+	errorThreshold := flag.Float64("error-threshold", 0.1, "error rate above which aimd algorithm backs off when error window is set")
+	backoffFloor := flag.Float64("backoff-floor", 0.5, "the smallest fraction aimd algorithm backs off to when error window is set")
+	expectContinue := flag.Bool("expect-continue", false, "acquire quota for requests with Expect: 100-continue header only when origin agrees to receive a body; origin must read the body to agree")
+	ejectAfter := flag.Int("eject-after", 0, "how many consecutive failures (5xx or connection errors) eject an origin from balancing, 0 means origins are never ejected")
+	ejectionTime := flag.Duration("ejection-time", 5*time.Second, "how long an origin is ejected for the first time, it doubles with every consecutive ejection")
+	maxEjectionDuration := flag.Duration("max-ejection-duration", 5*time.Minute, "the longest an origin can stay ejected before it's re-admitted for a trial")
+	originDNS := flag.String("origin-dns", "", "host:port of origins discovered by resolving the host name to IP addresses, it overrides -origin")
+	originDNSInterval := flag.Duration("origin-dns-interval", 10*time.Second, "how often origins are discovered with -origin-dns")
+	processMetrics := flag.Bool("process-metrics", false, "export the proxy's CPU and memory usage as proxy_process_* metrics")
+	controlInterval := flag.Duration("control-interval", time.Second, "how often utilization and goodput algorithms adjust quota")
+	probePath := flag.String("probe-path", "", "origin path to probe to measure baseline round-trip time, e.g., /health; probes are disabled by default")
+	probeInterval := flag.Duration("probe-interval", 10*time.Second, "how often origin is probed")
+	congestionMark := flag.Float64("congestion-mark-utilization", 0, "quota utilization (in-flight / target) at or above which successful responses are marked with X-Congestion: high header, 0 means no marks")
+	maxConnsPerHost := flag.Int("max-conns-per-host", 0, "how many connections the proxy can open to a backend, 0 means no limit")
+	connWaitTimeout := flag.Duration("conn-wait-timeout", 0, "how long a request can wait for a connection to a backend before it's counted as connection pool exhaustion, 0 means no limit")
+	shedConnWait := flag.Bool("shed-on-conn-wait-timeout", false, "reject requests with 429 when they waited for a connection longer than -conn-wait-timeout instead of queuing them")
+	methodQuotas := flag.Bool("method-quotas", false, "adapt separate quotas for reads (GET, HEAD, OPTIONS, TRACE) and writes (other methods), each allowed -quota concurrent requests initially")
+	maxDownstreamConns := flag.Int64("max-downstream-conns", 0, "how many client connections can be open at once, new connections over the limit are closed, 0 means no limit")
+	maxOldestAge := flag.Duration("max-oldest-inflight-age", 0, "reject new requests with 503 when the oldest in-flight request is older than this, 0 means no limit")
+	var quotaRules quotaRuleFlag
+	flag.Var(&quotaRules, "quota-rule", "comma-separated prefix=quota rules of independent static quotas for paths, e.g., /api/heavy=2,/api/light=20; the longest prefix wins, other paths use -quota; it can be repeated")
+	rewriteSpec := flag.String("rewrite", "", "comma-separated from=to path prefix rewrites applied before forwarding, e.g., /public/=/internal/")
+	rewriteHost := flag.String("rewrite-host", "", "Host header to send to origin instead of the client's one")
+	preserveHost := flag.Bool("preserve-host", false, "send the client's Host header to origin instead of the origin's host, e.g., for virtual-host routing")
+	forwardedHeaders := flag.Bool("forwarded-headers", true, "send X-Forwarded-For, X-Forwarded-Proto, and X-Forwarded-Host headers to origin")
+	trustedProxiesSpec := flag.String("trusted-proxies", "", "comma-separated CIDRs of proxies in front of this one, e.g., 10.0.0.0/8; X-Forwarded-For is walked through them to send the real client IP to origin in X-Real-IP")
+	backendWarmup := flag.Duration("backend-warmup", 0, "how long a newly added or re-admitted origin ramps up its share of quota from zero, 0 means no slow start")
+	latencySignal := flag.String("latency-signal", "ttfb", "which latency of origin's responses feeds the limiters: ttfb (time to first byte) or total (including response body)")
+	quotaFraction := flag.Float64("quota-fraction", 0, "set -quota to this fraction (0 < fraction <= 1) of the first origin's capacity discovered at startup by doubling concurrency until goodput stops growing, 0 means no discovery")
+	capacityPath := flag.String("capacity-search-path", "/", "origin path requested to discover capacity with -quota-fraction")
+	capacityStep := flag.Duration("capacity-search-step", 5*time.Second, "how long every concurrency level is measured to discover capacity with -quota-fraction")
+	queueTimeout := flag.Duration("queue-timeout", 0, "how long a request can wait for quota before it's rejected with 429, 0 means it's rejected right away")
+	queueSize := flag.Int("queue-size", 0, "how many requests can wait for each quota with -queue-timeout, others are rejected with 429; 0 means no limit")
+	dnsCacheTTL := flag.Duration("dns-cache-ttl", 0, "how long resolved origin host names are cached when dialing connections, 0 means they're resolved on every dial")
+	strict := flag.Bool("strict", false, "never admit more than -quota concurrent requests; by default concurrent arrivals can briefly exceed it in exchange for cheaper admission")
+	stressMode := flag.Bool("stress", false, "hammer quota with concurrent goroutines, report whether its invariants hold, and exit; run with the race detector")
+	stressGoroutines := flag.Int("stress-goroutines", 100, "how many goroutines use quota in -stress mode")
+	stressIterations := flag.Int("stress-iterations", 10000, "how many requests every goroutine makes in -stress mode")
 	flag.Parse()
 
+	if *stressMode {
+		failed := false
+		for _, adjust := range []struct {
+			name string
+			inc  bool
+			back bool
+		}{
+			{name: "none"},
+			{name: "inc", inc: true},
+			{name: "inc,backoff", inc: true, back: true},
+		} {
+			opts := []capacity.Option{capacity.WithFloor(*minQuota), capacity.WithCeiling(*maxQuota)}
+			if *strict {
+				opts = append(opts, capacity.WithStrict())
+			}
+			r := stress.Run(capacity.NewQuota(*quota, opts...), stress.Config{
+				Goroutines: *stressGoroutines,
+				Iterations: *stressIterations,
+				Inc:        adjust.inc,
+				Backoff:    adjust.back,
+				Strict:     *strict,
+			})
+			fmt.Printf("adjust=%s %s\n", adjust.name, r)
+			if err := r.Err(); err != nil {
+				fmt.Printf("adjust=%s invariant violated: %v\n", adjust.name, err)
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	switch *algorithm {
+	case "aimd", "utilization", "pid", "goodput", "leaky", "gradient", "composite":
+	default:
+		log.Fatalf("proxy: unknown algorithm %q", *algorithm)
+	}
+	switch *shadowAlgorithm {
+	case "", "aimd", "pid":
+	default:
+		log.Fatalf("proxy: unknown shadow algorithm %q", *shadowAlgorithm)
+	}
+	rewrites, err := parseRewriteRules(*rewriteSpec)
+	if err != nil {
+		log.Fatalf("proxy: %v", err)
+	}
+	trusted, err := parseTrustedProxies(*trustedProxiesSpec)
+	if err != nil {
+		log.Fatalf("proxy: %v", err)
+	}
+	if *preserveHost && *rewriteHost != "" {
+		log.Fatalf("proxy: -preserve-host and -rewrite-host are mutually exclusive")
+	}
+	switch *latencySignal {
+	case "ttfb", "total":
+	default:
+		log.Fatalf("proxy: unknown latency signal %q", *latencySignal)
+	}
+	bypass := parsePathMatcher(*bypassPaths)
+	for _, pattern := range bypass {
+		if _, err := path.Match(pattern, ""); err != nil {
+			log.Fatalf("proxy: invalid bypass path %q: %v", pattern, err)
+		}
+	}
+	if *errorWindow < 0 {
+		log.Fatalf("proxy: error window must not be negative: %d", *errorWindow)
+	}
+	if *errorThreshold < 0 || *errorThreshold >= 1 {
+		log.Fatalf("proxy: error threshold must be in [0, 1): %v", *errorThreshold)
+	}
+	if *backoffFloor < 0 || *backoffFloor > 1 {
+		log.Fatalf("proxy: back-off floor must be in [0, 1]: %v", *backoffFloor)
+	}
+	if *ejectAfter < 0 {
+		log.Fatalf("proxy: eject-after must not be negative: %d", *ejectAfter)
+	}
+	if *ejectionTime <= 0 || *maxEjectionDuration < *ejectionTime {
+		log.Fatalf("proxy: ejection time must satisfy 0 < ejection-time <= max-ejection-duration: %v, %v", *ejectionTime, *maxEjectionDuration)
+	}
+	if *backendWarmup < 0 {
+		log.Fatalf("proxy: backend warmup must not be negative: %v", *backendWarmup)
+	}
+	if *controlInterval <= 0 {
+		log.Fatalf("proxy: control interval must be positive: %v", *controlInterval)
+	}
+	if *probeInterval <= 0 {
+		log.Fatalf("proxy: probe interval must be positive: %v", *probeInterval)
+	}
+	if *signalWindow < 1 {
+		log.Fatalf("proxy: signal window must be positive: %d", *signalWindow)
+	}
+	if _, err := parseSignals(*signalSpec, *signalWindow, *queueDepthHeader); err != nil {
+		log.Fatalf("proxy: %v", err)
+	}
+	if *shortWindow < 1 || *longWindow < *shortWindow {
+		log.Fatalf("proxy: gradient windows must be 1 <= short (%d) <= long (%d)", *shortWindow, *longWindow)
+	}
+	if *breakerWindow < 1 || *breakerThreshold < 0 || *breakerThreshold >= 1 {
+		log.Fatalf("proxy: breaker window must be positive (%d) and threshold must be in [0, 1) (%v)", *breakerWindow, *breakerThreshold)
+	}
+	if *queueSize < 0 {
+		log.Fatalf("proxy: queue size must not be negative: %d", *queueSize)
+	}
+	if *queueSize > 0 && *queueTimeout == 0 {
+		log.Fatalf("proxy: -queue-size requires -queue-timeout")
+	}
+	if *postBackoffHold < 0 {
+		log.Fatalf("proxy: post back-off hold must not be negative: %v", *postBackoffHold)
+	}
+	if *minSlotHold < 0 {
+		log.Fatalf("proxy: min slot hold must not be negative: %v", *minSlotHold)
+	}
+	if *decisionLogSize < 0 {
+		log.Fatalf("proxy: decision log size must not be negative: %d", *decisionLogSize)
+	}
+	if *leakRate <= 0 {
+		log.Fatalf("proxy: leak rate must be positive: %v", *leakRate)
+	}
+	if *incRate <= 0 {
+		log.Fatalf("proxy: increase rate must be positive: %v", *incRate)
+	}
+	if *incStep < 1 {
+		log.Fatalf("proxy: increase step must be positive: %d", *incStep)
+	}
+	if *incBurst < 1 {
+		log.Fatalf("proxy: increase burst must be positive: %d", *incBurst)
+	}
+	if *minQuota < 1 || *minQuota > *maxQuota {
+		log.Fatalf("proxy: quota bounds must satisfy 1 <= min <= max: [%d, %d]", *minQuota, *maxQuota)
+	}
+	if *quota < 1 {
+		log.Fatalf("proxy: quota must be positive: %d", *quota)
+	}
+	// Adaptive control starts from -quota, so it must be within the bounds the controller keeps it in.
+	if *adaptive && (*quota < *minQuota || *quota > *maxQuota) {
+		log.Fatalf("proxy: quota %d must be within quota bounds [%d, %d]", *quota, *minQuota, *maxQuota)
+	}
+	if *latencySetpoint <= 0 {
+		log.Fatalf("proxy: latency setpoint must be positive: %v", *latencySetpoint)
+	}
+	if *targetUtilization <= 0 || *targetUtilization > 1 {
+		log.Fatalf("proxy: target utilization must be in (0, 1]: %v", *targetUtilization)
+	}
+	if *utilizationLatencyTolerance != 0 && *utilizationLatencyTolerance < 1 {
+		log.Fatalf("proxy: utilization latency tolerance must be 0 or at least 1: %v", *utilizationLatencyTolerance)
+	}
+
+	if *quotaFraction < 0 || *quotaFraction > 1 {
+		log.Fatalf("proxy: quota fraction must be in [0, 1]: %v", *quotaFraction)
+	}
+	// Capacity is discovered before serving traffic, so that quota starts from it.
+	if *quotaFraction > 0 {
+		origin := "http://" + *originDNS
+		if *originDNS == "" {
+			origin = strings.Split(*originAddr, ",")[0]
+		}
+		u, _, err := parseBackend(origin)
+		if err != nil {
+			log.Fatalf("proxy: %v", err)
+		}
+		u.Path = singleJoiningSlash(u.Path, *capacityPath)
+
+		s := capacitySearch{
+			url:       u,
+			client:    &http.Client{Timeout: *capacityStep},
+			step:      *capacityStep,
+			max:       *maxQuota,
+			tolerance: 0.05,
+		}
+		knee := s.Run(context.Background())
+		*quota = quotaOfCapacity(knee, *quotaFraction, *minQuota)
+		log.Printf("proxy: discovered capacity %d, quota is set to %d", knee, *quota)
+	}
+
+	var maintenance *maintenancePage
+	if *maintenanceFile != "" {
+		body, err := os.ReadFile(*maintenanceFile)
+		if err != nil {
+			log.Fatalf("proxy: failed to read maintenance page: %v", err)
+		}
+		maintenance = &maintenancePage{
+			body:        body,
+			status:      *maintenanceStatus,
+			contentType: *maintenanceContentType,
+		}
+	}
+
 	runtime.SetMutexProfileFraction(5)
+	// Initialize the default source of uniformly-distributed pseudo-random ints.
+	rand.Seed(time.Now().UnixNano())
 
-	inflightRequests := prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "proxy_inflight_requests",
-		Help: "How many HTTP requests are in-flight.",
+	inflightRequests := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "proxy_inflight_requests",
+			Help: "How many HTTP requests are in-flight, partitioned by method class (all, or read and write with -method-quotas).",
+		},
+		[]string{"method_class"},
+	)
+	targetInflightRequests := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "proxy_target_inflight_requests",
+			Help: "How many HTTP requests should be in-flight, partitioned by method class (all, or read and write with -method-quotas).",
+		},
+		[]string{"method_class"},
+	)
+	overTargetSeconds := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "proxy_inflight_over_target_seconds_total",
+		Help: "How long in seconds in-flight HTTP requests exceeded the target.",
+	})
+	underTargetSeconds := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "proxy_inflight_under_target_seconds_total",
+		Help: "How long in seconds in-flight HTTP requests trailed the target.",
 	})
-	targetInflightRequests := prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "proxy_target_inflight_requests",
-		Help: "How many HTTP requests should be in-flight.",
+	drainingGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "proxy_draining",
+		Help: "Whether the proxy is shutting down and waits for in-flight HTTP requests to finish.",
+	})
+	originRTT := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "proxy_origin_rtt_seconds",
+			Help:    "Round-trip time of HTTP requests to origin including response body in seconds, partitioned by backend.",
+			Buckets: []float64{0.95, 1, 1.05, 1.1, 1.5, 1.95, 2, 2.05, 2.1, 2.5, 3, 4},
+		},
+		[]string{"backend"},
+	)
+	originTTFB := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "proxy_origin_ttfb_seconds",
+			Help:    "Time to the first byte of origin's responses in seconds, partitioned by backend.",
+			Buckets: []float64{0.95, 1, 1.05, 1.1, 1.5, 1.95, 2, 2.05, 2.1, 2.5, 3, 4},
+		},
+		[]string{"backend"},
+	)
+	backendInflightRequests := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "proxy_backend_inflight_requests",
+			Help: "How many HTTP requests are in-flight, partitioned by backend.",
+		},
+		[]string{"backend"},
+	)
+	backendTargetInflightRequests := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "proxy_backend_target_inflight_requests",
+			Help: "How many HTTP requests should be in-flight, partitioned by backend.",
+		},
+		[]string{"backend"},
+	)
+	baselineRTT := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "proxy_baseline_rtt_seconds",
+		Help: "Round-trip time of the most recent probe request to origin in seconds.",
+	})
+	queueDepth := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "proxy_queue_depth",
+		Help: "How many requests wait for quota in -queue-size bounded queues.",
+	})
+	breakerState := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "proxy_breaker_state",
+		Help: "State of the circuit breaker: 0 closed, 1 open, 2 half-open.",
+	})
+	// Rejections should be near-instant, a slow one hints that requests wait somewhere before they're rejected.
+	requestDuration := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "proxy_request_duration_seconds",
+			Help:    "Time from receiving a request to responding in seconds, partitioned by outcome: ok (2xx from origin), failed, or rejected by the proxy.",
+			Buckets: []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 2.5, 5},
+		},
+		[]string{"outcome"},
+	)
+	backoffRatio := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "proxy_backoff_ratio",
+		Help:    "Ratio of target concurrency after and before a back-off.",
+		Buckets: []float64{0.1, 0.25, 0.5, 0.6, 0.7, 0.75, 0.8, 0.85, 0.9, 0.95, 1},
+	})
+	connPoolExhausted := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "proxy_conn_pool_exhausted_total",
+		Help: "How many requests waited for a connection to a backend longer than -conn-wait-timeout.",
+	})
+	downstreamConns := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "proxy_downstream_connections",
+		Help: "How many client connections are open.",
+	})
+	downstreamConnsRejected := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "proxy_downstream_connections_rejected_total",
+		Help: "How many client connections were closed because of -max-downstream-conns.",
 	})
 	prometheus.MustRegister(inflightRequests)
 	prometheus.MustRegister(targetInflightRequests)
+	prometheus.MustRegister(overTargetSeconds)
+	prometheus.MustRegister(underTargetSeconds)
+	prometheus.MustRegister(drainingGauge)
+	prometheus.MustRegister(originRTT)
+	prometheus.MustRegister(originTTFB)
+	prometheus.MustRegister(backendInflightRequests)
+	prometheus.MustRegister(backendTargetInflightRequests)
+	prometheus.MustRegister(baselineRTT)
+	prometheus.MustRegister(backoffRatio)
+	prometheus.MustRegister(requestDuration)
+	prometheus.MustRegister(breakerState)
+	prometheus.MustRegister(queueDepth)
+	prometheus.MustRegister(connPoolExhausted)
+	truncatedResponses := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "proxy_truncated_responses_total",
+		Help: "How many origin responses ended before their body was fully read, e.g., shorter than Content-Length.",
+	})
+	prometheus.MustRegister(truncatedResponses)
+	prometheus.MustRegister(downstreamConns)
+	prometheus.MustRegister(downstreamConnsRejected)
+	if *processMetrics {
+		prometheus.MustRegister(newProcessCollector())
+	}
+	// Sampling of metrics and control loops can be made irregular on purpose with -sample-jitter.
+	clock := sampleClock{jitter: *sampleJitter}
 	http.Handle("/metrics", promhttp.Handler())
+	if *deriveInterval > 0 {
+		go derive.Run(clock.NewTicker(*deriveInterval).C, prometheus.DefaultGatherer, newDerivedGauges().Update, func(err error) {
+			log.Printf("proxy: failed to gather metrics: %v", err)
+		})
+	}
 
-	inflight := NewQuota(*quota, inflightRequests, targetInflightRequests)
-	// incLimiter throttles additive increase which happens on every HTTP 200 OK response.
-	incLimiter := rate.NewLimiter(rate.Limit(1), 1)
+	// Every composite limiter gets its own signals, since they keep windows of responses.
+	newSignals := func() []Signal {
+		ss, _ := parseSignals(*signalSpec, *signalWindow, *queueDepthHeader)
+		return ss
+	}
+	lc := limiterConfig{
+		quota:             *quota,
+		minQuota:          *minQuota,
+		maxQuota:          *maxQuota,
+		incRate:           *incRate,
+		incStep:           *incStep,
+		incBurst:          *incBurst,
+		incPrewarm:        *incPrewarm,
+		incOnDemand:       *incOnDemand,
+		errorWindow:       *errorWindow,
+		errorThreshold:    *errorThreshold,
+		backoffFloor:      *backoffFloor,
+		targetUtilization: *targetUtilization,
+		latencyTolerance:  *utilizationLatencyTolerance,
+		latencySetpoint:   *latencySetpoint,
+		kp:                *kp,
+		ki:                *ki,
+		rttWindow:         *rttWindow,
+		leakRate:          *leakRate,
+		shortWindow:       *shortWindow,
+		longWindow:        *longWindow,
+		signals:           newSignals,
+		signalSpec:        *signalSpec,
+	}
+	algo := "static"
+	if *adaptive {
+		algo = *algorithm
+	}
+	prometheus.MustRegister(newLimiterInfo(algo, lc))
 
-	target, err := url.Parse(*originAddr)
-	if err != nil {
-		log.Fatalf("proxy: failed to parse origin url: %v", err)
+	class := "all"
+	if *methodQuotas {
+		class = "read"
 	}
-	proxy := httputil.NewSingleHostReverseProxy(target)
-	proxy.ModifyResponse = func(resp *http.Response) error {
-		if !*adaptive {
-			return nil
+	var decisions *capacity.DecisionLog
+	if *decisionLogSize > 0 {
+		decisions = capacity.NewDecisionLog(*decisionLogSize)
+	}
+	quotaOptions := func(class string) []capacity.Option {
+		opts := []capacity.Option{
+			capacity.WithCurrentGauge(inflightRequests.WithLabelValues(class)),
+			capacity.WithTargetGauge(targetInflightRequests.WithLabelValues(class)),
+			capacity.WithBackoffObserver(backoffRatio),
+			capacity.WithFloor(*minQuota),
+			capacity.WithCeiling(*maxQuota),
 		}
-
-		if resp.StatusCode != http.StatusOK {
-			inflight.Backoff(0.75)
-			return nil
+		if *strict {
+			opts = append(opts, capacity.WithStrict())
 		}
-		// Increase target concurrency by a constant c per unit time,
-		// e.g., allow 1 more rps every second if there is a demand.
-		if incLimiter.Allow() {
-			inflight.Inc()
+		if *postBackoffHold > 0 {
+			opts = append(opts, capacity.WithPostBackoffHold(*postBackoffHold))
 		}
-		return nil
+		if decisions != nil {
+			opts = append(opts, capacity.WithDecisionLog(decisions))
+		}
+		return opts
 	}
-	proxy.ErrorHandler = func(rw http.ResponseWriter, r *http.Request, err error) {
-		log.Printf("proxy: %v", err)
-		rw.WriteHeader(http.StatusBadGateway)
-		if *adaptive {
-			inflight.Backoff(0.75)
+	inflight := capacity.NewQuota(*quota, quotaOptions(class)...)
+	limiter := newLimiter(algo, inflight, lc)
+	go trackTarget(inflight, clock.NewTicker(100*time.Millisecond), overTargetSeconds, underTargetSeconds)
+	// Limiters don't adjust quota until warmup is over.
+	warm := newWarmup(*warmupRequests)
+	if *warmupRequests > 0 {
+		go func() {
+			<-warm.Done()
+			log.Printf("proxy: warmup is over after %d responses, adaptive control begins", *warmupRequests)
+		}()
+	}
+	runPeriodic := func(l Limiter) {
+		if l, ok := l.(periodicLimiter); ok {
+			go func() {
+				<-warm.Done()
+				l.Run(*controlInterval, clock)
+			}()
 		}
 	}
+	runPeriodic(limiter)
+	// Writes get their own quota which adapts only to responses to writes.
+	var (
+		writeInflight *capacity.Quota
+		writeLimiter  Limiter
+	)
+	if *methodQuotas {
+		writeInflight = capacity.NewQuota(*quota, quotaOptions("write")...)
+		writeLimiter = newLimiter(algo, writeInflight, lc)
+		runPeriodic(writeLimiter)
+	}
+	pathInflightRequests := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "proxy_path_inflight_requests",
+			Help: "How many HTTP requests are in-flight, partitioned by path prefix of -quota-rule.",
+		},
+		[]string{"path"},
+	)
+	pathTargetInflightRequests := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "proxy_path_target_inflight_requests",
+			Help: "How many HTTP requests are allowed to be in-flight, partitioned by path prefix of -quota-rule.",
+		},
+		[]string{"path"},
+	)
+	prometheus.MustRegister(pathInflightRequests)
+	prometheus.MustRegister(pathTargetInflightRequests)
+	paths := newPathQuotas(quotaRules, func(prefix string, n int64) *capacity.Quota {
+		opts := []capacity.Option{
+			capacity.WithCurrentGauge(pathInflightRequests.WithLabelValues(prefix)),
+			capacity.WithTargetGauge(pathTargetInflightRequests.WithLabelValues(prefix)),
+		}
+		if *strict {
+			opts = append(opts, capacity.WithStrict())
+		}
+		return capacity.NewQuota(n, opts...)
+	})
 
-	http.HandleFunc("/", func(rw http.ResponseWriter, r *http.Request) {
-		if inflight.Receive() {
-			proxy.ServeHTTP(rw, r)
-			inflight.Release()
+	methods := methodClasses{
+		read:         inflight,
+		readLimiter:  limiter,
+		write:        writeInflight,
+		writeLimiter: writeLimiter,
+	}
+	// classOf returns quota and limiter of the request's path or method class.
+	// Path quotas are static.
+	classOf := func(r *http.Request) (*capacity.Quota, Limiter) {
+		if q := pathQuotaFrom(r.Context()); q != nil {
+			return q, nopLimiter{}
+		}
+		return methods.Of(r.Method)
+	}
+
+	// Shadow limiter receives the same observations as the live one,
+	// but its quota only tracks what the limiter would do without enforcing it.
+	var shadowLimiter Limiter = nopLimiter{}
+	if *shadowAlgorithm != "" {
+		shadowTargetInflightRequests := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "proxy_shadow_target_inflight_requests",
+			Help: "How many HTTP requests should be in-flight according to the shadow limiter.",
+		})
+		prometheus.MustRegister(shadowTargetInflightRequests)
+
+		// Shadow quota doesn't admit requests, so it only reports target concurrency.
+		shadow := capacity.NewQuota(*quota, capacity.WithTargetGauge(shadowTargetInflightRequests))
+		shadowLimiter = newLimiter(*shadowAlgorithm, shadow, lc)
+	}
+	// observe feeds a response from origin which took rtt to the limiters.
+	// The header is nil when origin didn't respond.
+	observe := func(r *http.Request, rtt time.Duration, overloaded bool, header http.Header) {
+		if !warm.Observe() {
 			return
 		}
+		_, live := classOf(r)
+		observeLimiters(sample{rtt: rtt, overloaded: overloaded, header: header}, live, shadowLimiter)
+	}
 
-		rw.WriteHeader(http.StatusTooManyRequests)
-		fmt.Fprint(rw, "🚦\n")
-	})
-	http.ListenAndServe(*addr, nil)
-}
+	var (
+		events  quotaEvents = nopQuotaEvents{}
+		tracing *sdktrace.TracerProvider
+		tracer  trace.Tracer
+	)
+	if *traceQuotaEvents {
+		exporter, err := stdouttrace.New()
+		if err != nil {
+			log.Fatalf("proxy: %v", err)
+		}
+		tracing = sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+		tracer = tracing.Tracer("github.com/marselester/capacity/cmd/proxy")
+		events = spanQuotaEvents{}
+	}
 
-// Quota is a limited quantity of requests allowed to be in-flight.
-type Quota struct {
-	used int64
-	max  int64
+	var shedder *weightedShedder
+	if *shedWeightsSpec != "" {
+		weights, err := parseShedWeights(*shedWeightsSpec)
+		if err != nil {
+			log.Fatalf("proxy: %v", err)
+		}
+		if *shedSaturation <= 0 || *shedSaturation > 1 {
+			log.Fatalf("proxy: shed saturation %v must be in (0, 1]", *shedSaturation)
+		}
+		shedder = &weightedShedder{
+			header:     *priorityHeader,
+			weights:    weights,
+			saturation: *shedSaturation,
+			shed: prometheus.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: "proxy_weighted_shed_total",
+					Help: "How many requests were dropped by -shed-weights, partitioned by class.",
+				},
+				[]string{"class"},
+			),
+		}
+		prometheus.MustRegister(shedder.shed)
+	}
 
-	current prometheus.Gauge
-	target  prometheus.Gauge
-}
+	var breaker *CircuitBreaker
+	admissionDisagreements := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "proxy_admission_disagreements_total",
+			Help: "How many times circuit breaker was open while the limiter had quota to spare, partitioned by who won per -breaker-precedence.",
+		},
+		[]string{"winner"},
+	)
+	if *breakerEnabled {
+		if *breakerPrecedence != "breaker" && *breakerPrecedence != "limiter" {
+			log.Fatalf("proxy: unknown breaker precedence %q: want breaker or limiter", *breakerPrecedence)
+		}
+		prometheus.MustRegister(admissionDisagreements)
+		breaker = NewCircuitBreaker(*breakerWindow, *breakerThreshold, *breakerCooldown, func(state int) {
+			breakerState.Set(float64(state))
+		})
+	}
+	admission := breakerAdmission{
+		breaker:       breaker,
+		precedence:    *breakerPrecedence,
+		disagreements: admissionDisagreements,
+	}
 
-// NewQuota creates a quota of in-flight requests.
-func NewQuota(n int64, current, target prometheus.Gauge) *Quota {
-	q := Quota{
-		max:     n,
-		current: current,
-		target:  target,
+	problems := problemWriter{typeURI: *problemTypeURI}
+	// unavailable responds when no origin can accept a request because all of them are ejected.
+	unavailable := func(rw http.ResponseWriter, r *http.Request) {
+		if maintenance != nil {
+			maintenance.ServeHTTP(rw, r)
+			return
+		}
+		if *problemJSON {
+			problems.Write(rw, http.StatusBadGateway, "origin-unavailable", "All origins are ejected.")
+			return
+		}
+		rw.WriteHeader(http.StatusBadGateway)
 	}
-	return &q
-}
+	// badGateway responds when origin failed to respond to a request, e.g., it's down.
+	// Other origins might still be healthy, so it's not a reason to serve the maintenance page.
+	badGateway := func(rw http.ResponseWriter) {
+		if *problemJSON {
+			problems.Write(rw, http.StatusBadGateway, "origin-unavailable", "Origin failed to respond.")
+			return
+		}
+		rw.WriteHeader(http.StatusBadGateway)
+	}
+	// reject responds with 429 when quota is exhausted.
+	rejection := rejecter{duration: requestDuration.WithLabelValues("rejected")}
+	if *problemJSON {
+		rejection.problems = &problems
+	}
+	reject := rejection.Reject
 
-// Receive fills quota by one and returns true if quota is available.
-func (q *Quota) Receive() bool {
-	used := atomic.LoadInt64(&q.used)
-	max := atomic.LoadInt64(&q.max)
-	available := used < max
-	// If quota became available here, it's still ok to reject the request.
-	if !available {
-		return false
+	backendQuota := *quota
+	if *backendQuotaFlag > 0 {
+		backendQuota = *backendQuotaFlag
+	}
+	backends := newBackendPool(backendQuota, backendMetrics{
+		rtt:            originRTT,
+		ttfb:           originTTFB,
+		inflight:       backendInflightRequests,
+		targetInflight: backendTargetInflightRequests,
+	}, ejectionPolicy{
+		failures: *ejectAfter,
+		baseTime: *ejectionTime,
+		maxTime:  *maxEjectionDuration,
+	}, *backendWarmup)
+	if *originDNS != "" {
+		d := dnsDiscovery{
+			pool:   backends,
+			addr:   *originDNS,
+			lookup: net.DefaultResolver.LookupHost,
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), *originDNSInterval)
+		err := d.Refresh(ctx)
+		cancel()
+		if err != nil {
+			log.Fatalf("proxy: %v", err)
+		}
+		go d.Run(*originDNSInterval)
+	} else if err := backends.Update(strings.Split(*originAddr, ",")); err != nil {
+		log.Fatalf("proxy: %v", err)
+	}
+	// Requests which bypass quota are proxied to backends in turn.
+	bypassBalancer := roundRobin{pool: backends}
+	var balancer Balancer
+	switch *balancerName {
+	case "round-robin":
+		balancer = &roundRobin{pool: backends}
+	case "least-conn":
+		balancer = &leastConn{pool: backends}
+	case "p2c":
+		balancer = &powerOfTwo{pool: backends}
+	default:
+		log.Fatalf("proxy: unknown balancer %q", *balancerName)
+	}
+	if *probePath != "" {
+		p := prober{
+			path:     *probePath,
+			quota:    inflight,
+			backends: &roundRobin{pool: backends},
+			client:   &http.Client{},
+			baseline: baselineRTT,
+		}
+		for _, l := range []Limiter{limiter, shadowLimiter} {
+			if o, ok := l.(baselineObserver); ok {
+				p.observers = append(p.observers, o)
+			}
+		}
+		go p.Run(*probeInterval)
 	}
 
-	atomic.AddInt64(&q.used, 1)
-	q.current.Inc()
+	// director rewrites a request's path and host before it's sent to the chosen backend.
+	director := func(r *http.Request) {
+		if *forwardedHeaders {
+			setForwardedHeaders(r, trusted)
+		} else {
+			omitForwardedFor(r)
+		}
+		rewrites.Apply(r)
+		direct(r)
+		setDeadlineHeader(r)
+		setHost(r, *rewriteHost, *preserveHost)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxConnsPerHost = *maxConnsPerHost
+	if *dnsCacheTTL > 0 {
+		transport.DialContext = newDNSCache(*dnsCacheTTL, net.DefaultResolver.LookupHost).DialContext
+	}
+	proxy := &httputil.ReverseProxy{
+		Director: director,
+		Transport: &connPoolTransport{
+			RoundTripper: transport,
+			waitTimeout:  *connWaitTimeout,
+			shed:         *shedConnWait,
+			exhausted:    connPoolExhausted,
+		},
+	}
+	proxyBypass := &httputil.ReverseProxy{Director: director}
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		ctx := resp.Request.Context()
+		b := backendFrom(ctx)
+		ttfb := timeToFirstByte(ctx)
+		b.ttfb.Observe(ttfb.Seconds())
+		b.outlier.Record(resp.StatusCode >= http.StatusInternalServerError)
 
-	// If quota became unavailable here, it's still ok to process the request.
-	return true
-}
+		shed := isShed(resp.StatusCode)
+		feed := feedsLimiter(resp.StatusCode, *shedAsOverload)
+		overloaded := resp.StatusCode != http.StatusOK
+		if breaker != nil {
+			breaker.Record(breakerTicketFrom(ctx), resp.StatusCode < http.StatusInternalServerError && resp.StatusCode != http.StatusTooManyRequests)
+		}
+		// Origin asked for relief, so quota is held low until then,
+		// unless origin's sheds aren't treated as overload.
+		if shed && *shedAsOverload {
+			q, _ := classOf(resp.Request)
+			holdForRetryAfter(resp, q, time.Now())
+		}
 
-// Release frees up quota by one.
-func (q *Quota) Release() {
-	atomic.AddInt64(&q.used, -1)
+		// Total round-trip time is known once the response body is copied to the client.
+		body := &closeHookBody{ReadCloser: resp.Body}
+		body.onClose = func() {
+			rtt := sinceStart(ctx)
+			b.rtt.Observe(rtt.Seconds())
+			// A body cut short by origin is a failure even though its status was fine,
+			// unlike a client that went away before the body was copied.
+			truncated := body.Truncated()
+			if truncated {
+				log.Printf("proxy: truncated response from %s: %v", b.url.Host, body.ReadErr())
+				truncatedResponses.Inc()
+			}
+			outcome := "failed"
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 && !truncated {
+				outcome = "ok"
+			}
+			requestDuration.WithLabelValues(outcome).Observe(sinceReceived(ctx).Seconds())
+			if feed && *latencySignal == "total" {
+				observe(resp.Request, rtt, overloaded || truncated, resp.Header)
+			}
+		}
+		resp.Body = body
+		if !feed {
+			return nil
+		}
+		if *latencySignal == "ttfb" {
+			observe(resp.Request, ttfb, overloaded, resp.Header)
+		}
 
-	q.current.Dec()
-}
+		q, _ := classOf(resp.Request)
+		markCongestion(resp, q, *congestionMark)
+		return nil
+	}
+	proxy.ErrorHandler = func(rw http.ResponseWriter, r *http.Request, err error) {
+		if uploadRejected(r) {
+			reject(rw, r)
+			return
+		}
+		// The proxy itself is the bottleneck, so it's neither backend's failure nor overload.
+		if errors.Is(err, errConnPoolExhausted) {
+			reject(rw, r)
+			return
+		}
 
-// Inc lifts quota by one.
-func (q *Quota) Inc() {
-	atomic.AddInt64(&q.max, 1)
+		log.Printf("proxy: %v", err)
+		backendFrom(r.Context()).outlier.Record(true)
+		if breaker != nil {
+			breaker.Record(breakerTicketFrom(r.Context()), false)
+		}
+		badGateway(rw)
+		requestDuration.WithLabelValues("failed").Observe(sinceReceived(r.Context()).Seconds())
+		observe(r, sinceStart(r.Context()), true, nil)
+	}
 
-	q.target.Inc()
-}
+	// Admin endpoints are served on their own listener,
+	// so that clients can't adjust quota or repoint the proxy, and origin's /admin/ paths stay reachable.
+	admin := http.NewServeMux()
+	admin.Handle("/admin/backoff", backoffHandler(inflight))
+	admin.Handle("/admin/increase", increaseHandler(inflight))
+	admin.Handle("/admin/backends", backendsHandler(backends))
+	admin.Handle("/admin/snapshot", snapshotHandler(algo, inflight, limiter, backends))
+	if decisions != nil {
+		admin.Handle("/admin/decisions", decisionsHandler(decisions))
+	}
+	// Requests wait for quota in bounded queues, the rest are rejected right away.
+	queues := map[*capacity.Quota]*capacity.QueuedQuota{}
+	if *queueSize > 0 {
+		queued := []*capacity.Quota{inflight}
+		if writeInflight != nil {
+			queued = append(queued, writeInflight)
+		}
+		for _, p := range paths {
+			queued = append(queued, p.quota)
+		}
+		for _, q := range queued {
+			queues[q] = capacity.NewQueuedQuota(q, *queueSize, *queueTimeout, queueDepth)
+		}
+	}
+	// receive admits a request into quota q waiting for it no longer than the queue timeout.
+	receive := func(ctx context.Context, q *capacity.Quota) bool {
+		if *queueTimeout == 0 {
+			return q.Receive()
+		}
+		if qq, ok := queues[q]; ok {
+			return qq.Receive(ctx) == nil
+		}
+		ctx, cancel := context.WithTimeout(ctx, *queueTimeout)
+		defer cancel()
+		return q.ReceiveWait(ctx) == nil
+	}
+	admit := &admitHandler{
+		classOf:        classOf,
+		receive:        receive,
+		events:         events,
+		minSlotHold:    *minSlotHold,
+		expectContinue: *expectContinue,
+		ages:           newInflightAges(),
+		maxOldestAge:   *maxOldestAge,
+		admission:      admission,
+		shedder:        shedder,
+		balancer:       balancer,
+		backends:       backends,
+		proxy:          proxy,
+		problems:       rejection.problems,
+		unavailable:    unavailable,
+		reject:         reject,
+	}
+	// Trivial requests such as health checks don't consume quota
+	// and don't affect adaptive capacity control.
+	handler := paths.Handler(bypassHandler(bypass, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(withBackend(r.Context(), bypassBalancer.Next()))
+		proxyBypass.ServeHTTP(rw, r)
+	}), admit))
+	http.HandleFunc("/", func(rw http.ResponseWriter, r *http.Request) {
+		received := time.Now()
+		ctx := withReceived(r.Context(), received)
+		// The earliest of the client's and proxy's deadlines is forwarded to origin.
+		if deadline, ok := parseDeadline(r.Header.Get("X-Request-Deadline")); ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, deadline)
+			defer cancel()
+		}
+		if *requestDeadline > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, received.Add(*requestDeadline))
+			defer cancel()
+		}
+		if tracer != nil {
+			var span *requestSpan
+			ctx, span = startRequestSpan(ctx, tracer, r)
+			defer span.Done()
+		}
+		r = r.WithContext(ctx)
 
-// Backoff sets target concurrency to a fraction p of its current size (0 <= p <= 1), e.g.,
-// back-off to 75% when a service is overloaded.
-func (q *Quota) Backoff(p float64) {
-	for {
-		oldMax := atomic.LoadInt64(&q.max)
-		newMax := math.Ceil(p * float64(oldMax))
-		if atomic.CompareAndSwapInt64(&q.max, oldMax, int64(newMax)) {
-			q.target.Set(newMax)
-			break
+		handler.ServeHTTP(rw, r)
+	})
+	conns := connLimiter{
+		max:      *maxDownstreamConns,
+		open:     downstreamConns,
+		rejected: downstreamConnsRejected,
+	}
+	srv := http.Server{
+		Addr:      *addr,
+		ConnState: conns.ConnState,
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatalf("proxy: %v", err)
 		}
+	}()
+	adminSrv := http.Server{
+		Addr:    *adminAddr,
+		Handler: admin,
+	}
+	go func() {
+		if err := adminSrv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatalf("proxy: admin: %v", err)
+		}
+	}()
+
+	// On shutdown, in-flight requests are allowed to finish while new ones are rejected.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+
+	quotas := []*capacity.Quota{inflight}
+	if writeInflight != nil {
+		quotas = append(quotas, writeInflight)
+	}
+	for _, p := range paths {
+		quotas = append(quotas, p.quota)
+	}
+	var used int64
+	for _, q := range quotas {
+		used += q.Used()
+	}
+	log.Printf("proxy: draining %d in-flight requests", used)
+	drainingGauge.Set(1)
+	for _, q := range quotas {
+		q.Drain()
+	}
+	begun := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	for _, q := range quotas {
+		for q.Used() > 0 && ctx.Err() == nil {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	// Listeners and idle connections are closed once in-flight requests are done.
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Fatalf("proxy: failed to drain in-flight requests: %v", err)
+	}
+	adminSrv.Shutdown(ctx)
+	// Spans of the drained requests are flushed.
+	if tracing != nil {
+		tracing.Shutdown(ctx)
 	}
+	log.Printf("proxy: drained in %v", time.Since(begun))
 }
@@ -0,0 +1,18 @@
+package main
+
+import "net/http"
+
+// maintenancePage is served instead of proxying when no origin can accept requests,
+// because all of them are ejected.
+type maintenancePage struct {
+	body        []byte
+	status      int
+	contentType string
+}
+
+// ServeHTTP responds with the maintenance page.
+func (p *maintenancePage) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", p.contentType)
+	rw.WriteHeader(p.status)
+	rw.Write(p.body)
+}
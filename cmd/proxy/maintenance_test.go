@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMaintenancePage(t *testing.T) {
+	pool := newTestPool(t, 5, 1, "http://a.local", "http://b.local")
+	balancer := &roundRobin{pool: pool}
+	page := &maintenancePage{
+		body:        []byte("<h1>Back soon</h1>"),
+		status:      http.StatusServiceUnavailable,
+		contentType: "text/html",
+	}
+
+	// A single failed origin isn't a reason for maintenance while another one can accept requests.
+	pool.Backends()[0].outlier.Record(true)
+	if allEjected(pool.Backends()) {
+		t.Fatal("all origins are ejected after one of them failed")
+	}
+	b := balancer.Pick()
+	if b == nil || b.url.Host != "b.local" {
+		t.Fatalf("picked %v, want b.local", b)
+	}
+	b.quota.Release()
+
+	pool.Backends()[1].outlier.Record(true)
+	if b = balancer.Pick(); b != nil {
+		t.Fatalf("picked %s when all origins are ejected", b.url)
+	}
+	if !allEjected(pool.Backends()) {
+		t.Fatal("origins aren't ejected")
+	}
+
+	rec := httptest.NewRecorder()
+	page.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/html" {
+		t.Errorf("content type %q, want text/html", ct)
+	}
+	if body := rec.Body.String(); body != "<h1>Back soon</h1>" {
+		t.Errorf("body %q, want the maintenance page", body)
+	}
+}
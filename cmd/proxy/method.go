@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/marselester/capacity"
+)
+
+// isReadMethod reports whether a request method is safe, i.e., it doesn't modify origin's state.
+// Reads and writes usually stress origin differently, e.g., writes hold database locks.
+func isReadMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// methodClasses are quotas and limiters of reads and writes.
+// When write quota is nil, writes share quota with reads.
+type methodClasses struct {
+	read         *capacity.Quota
+	readLimiter  Limiter
+	write        *capacity.Quota
+	writeLimiter Limiter
+}
+
+// Of returns quota and limiter of the request method's class.
+func (c methodClasses) Of(method string) (*capacity.Quota, Limiter) {
+	if c.write != nil && !isReadMethod(method) {
+		return c.write, c.writeLimiter
+	}
+	return c.read, c.readLimiter
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/marselester/capacity"
+)
+
+func TestMethodClassesAdaptIndependently(t *testing.T) {
+	c := limiterConfig{incRate: 1000, incStep: 1, incBurst: 100, incPrewarm: true}
+	read, write := capacity.NewQuota(10), capacity.NewQuota(10)
+	methods := methodClasses{
+		read:         read,
+		readLimiter:  newLimiter("aimd", read, c),
+		write:        write,
+		writeLimiter: newLimiter("aimd", write, c),
+	}
+
+	// Writes overload origin while reads are fine.
+	for i := 0; i < 5; i++ {
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodHead, http.MethodDelete} {
+			q, l := methods.Of(method)
+			for q.Used() < q.Max() && q.Receive() {
+			}
+			l.Observe(time.Millisecond, !isReadMethod(method))
+		}
+	}
+	if read.Max() <= 10 {
+		t.Errorf("read max %d, want above 10 since reads succeeded", read.Max())
+	}
+	if write.Max() >= 10 {
+		t.Errorf("write max %d, want below 10 since writes overloaded origin", write.Max())
+	}
+}
+
+func TestMethodClassesShared(t *testing.T) {
+	q := capacity.NewQuota(10)
+	methods := methodClasses{read: q, readLimiter: nopLimiter{}}
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		if got, _ := methods.Of(method); got != q {
+			t.Errorf("%s got a separate quota, want the shared one", method)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// inflightAges tracks when in-flight requests were admitted to find the oldest one.
+type inflightAges struct {
+	mu sync.Mutex
+	// admitted holds admission times in order they were added, so the oldest is in front.
+	admitted *list.List
+}
+
+// newInflightAges creates an empty tracker of in-flight requests.
+func newInflightAges() *inflightAges {
+	return &inflightAges{
+		admitted: list.New(),
+	}
+}
+
+// Add records admission of a request and returns a handle to remove it once it's done.
+func (a *inflightAges) Add() *list.Element {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.admitted.PushBack(time.Now())
+}
+
+// Remove forgets a request which is no longer in-flight.
+func (a *inflightAges) Remove(e *list.Element) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.admitted.Remove(e)
+}
+
+// Oldest returns how long the oldest in-flight request has been in-flight, or zero if there are none.
+func (a *inflightAges) Oldest() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	e := a.admitted.Front()
+	if e == nil {
+		return 0
+	}
+	return time.Since(e.Value.(time.Time))
+}
+
+// Exceeds reports whether the oldest in-flight request is older than max, 0 means no limit.
+func (a *inflightAges) Exceeds(max time.Duration) bool {
+	return max > 0 && a.Oldest() > max
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInflightAgesStuckRequest(t *testing.T) {
+	a := newInflightAges()
+	if a.Exceeds(10 * time.Millisecond) {
+		t.Fatal("no requests are in-flight, yet the oldest exceeds the limit")
+	}
+
+	stuck := a.Add()
+	time.Sleep(20 * time.Millisecond)
+	fresh := a.Add()
+	if !a.Exceeds(10 * time.Millisecond) {
+		t.Fatalf("oldest %v, want new requests shed behind the stuck one", a.Oldest())
+	}
+	if a.Exceeds(0) {
+		t.Error("requests were shed without a limit")
+	}
+
+	// Once the stuck request completes, the fresh one is the oldest.
+	a.Remove(stuck)
+	if a.Exceeds(10 * time.Millisecond) {
+		t.Errorf("oldest %v, want requests admitted after the stuck one completed", a.Oldest())
+	}
+	a.Remove(fresh)
+	if got := a.Oldest(); got != 0 {
+		t.Errorf("oldest %v, want 0", got)
+	}
+}
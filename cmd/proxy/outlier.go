@@ -0,0 +1,89 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// ejectionPolicy configures when backends are ejected from balancing.
+type ejectionPolicy struct {
+	// failures is how many consecutive failures eject a backend, 0 means backends are never ejected.
+	failures int
+	// baseTime is how long a backend is ejected for the first time,
+	// the ejection time doubles with every consecutive ejection.
+	baseTime time.Duration
+	// maxTime caps the ejection time, so that a recovered backend is re-admitted eventually.
+	maxTime time.Duration
+}
+
+// outlierDetector passively tracks failures of a backend and ejects it from balancing.
+// Once an ejection expires, the backend is re-admitted for a trial:
+// it's ejected again right away if the next request fails.
+type outlierDetector struct {
+	policy ejectionPolicy
+
+	mu sync.Mutex
+	// consecutive is how many requests failed in a row.
+	consecutive int
+	// ejections is how many times the backend was ejected in a row without a successful request in between.
+	ejections int
+	// ejectedUntil is when the backend is re-admitted.
+	ejectedUntil time.Time
+}
+
+// Ejected reports whether the backend shouldn't receive requests.
+func (d *outlierDetector) Ejected() bool {
+	if d.policy.failures == 0 {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return time.Now().Before(d.ejectedUntil)
+}
+
+// EjectedUntil returns when the backend is (or was) re-admitted after its latest ejection.
+func (d *outlierDetector) EjectedUntil() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.ejectedUntil
+}
+
+// Record records an outcome of a request to the backend.
+func (d *outlierDetector) Record(failed bool) {
+	if d.policy.failures == 0 {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !failed {
+		d.consecutive = 0
+		d.ejections = 0
+		return
+	}
+
+	// Requests which were in-flight when the backend got ejected don't extend the ejection.
+	now := time.Now()
+	if now.Before(d.ejectedUntil) {
+		return
+	}
+
+	d.consecutive++
+	// A backend on trial after ejection is ejected on its first failure.
+	if d.consecutive < d.policy.failures && d.ejections == 0 {
+		return
+	}
+
+	ejectionTime := d.policy.baseTime
+	for i := 0; i < d.ejections && ejectionTime < d.policy.maxTime; i++ {
+		ejectionTime *= 2
+	}
+	if ejectionTime > d.policy.maxTime {
+		ejectionTime = d.policy.maxTime
+	}
+	d.ejections++
+	d.consecutive = 0
+	d.ejectedUntil = now.Add(ejectionTime)
+}
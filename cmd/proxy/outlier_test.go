@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutlierDetectorMaxEjection(t *testing.T) {
+	d := outlierDetector{policy: ejectionPolicy{
+		failures: 2,
+		baseTime: time.Minute,
+		maxTime:  5 * time.Minute,
+	}}
+
+	d.Record(true)
+	if d.Ejected() {
+		t.Fatal("backend was ejected after a single failure")
+	}
+	// The backend keeps failing its trials, so the ejection time doubles up to the max.
+	want := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}
+	for i, w := range want {
+		d.Record(true)
+		if !d.Ejected() {
+			t.Fatalf("ejection %d: backend wasn't ejected", i)
+		}
+		if got := time.Until(d.EjectedUntil()); got > w || got < w-time.Second {
+			t.Errorf("ejection %d: backend is ejected for %v, want %v", i, got.Round(time.Second), w)
+		}
+
+		// The ejection expires, so the backend is re-admitted for a trial.
+		d.mu.Lock()
+		d.ejectedUntil = time.Now()
+		d.mu.Unlock()
+		if d.Ejected() {
+			t.Fatalf("ejection %d: backend wasn't re-admitted after the max ejection time", i)
+		}
+	}
+
+	// A successful trial resets the ejection time.
+	d.Record(false)
+	d.Record(true)
+	if d.Ejected() {
+		t.Error("recovered backend was ejected after a single failure")
+	}
+	d.Record(true)
+	if got := time.Until(d.EjectedUntil()); got > time.Minute {
+		t.Errorf("backend is ejected for %v, want %v", got.Round(time.Second), time.Minute)
+	}
+}
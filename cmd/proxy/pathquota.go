@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/marselester/capacity"
+)
+
+// quotaRule allows n concurrent requests to paths with the given prefix.
+type quotaRule struct {
+	prefix string
+	n      int64
+}
+
+// quotaRuleFlag is a repeatable flag of comma-separated prefix=quota rules,
+// e.g., -quota-rule /api/heavy=2,/api/light=20 -quota-rule /admin=1.
+type quotaRuleFlag []quotaRule
+
+// String returns the rules as a comma-separated list.
+func (f *quotaRuleFlag) String() string {
+	var rr []string
+	for _, r := range *f {
+		rr = append(rr, fmt.Sprintf("%s=%d", r.prefix, r.n))
+	}
+	return strings.Join(rr, ",")
+}
+
+// Set adds rules from a comma-separated list of prefix=quota pairs.
+func (f *quotaRuleFlag) Set(spec string) error {
+	for _, s := range strings.Split(spec, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+
+		kv := strings.Split(s, "=")
+		if len(kv) != 2 {
+			return fmt.Errorf("quota rule %q: want prefix=quota", s)
+		}
+		if !strings.HasPrefix(kv[0], "/") {
+			return fmt.Errorf("quota rule %q: path prefix must start with /", s)
+		}
+		n, err := strconv.ParseInt(kv[1], 10, 64)
+		if err != nil || n < 1 {
+			return fmt.Errorf("quota rule %q: quota must be a positive integer", s)
+		}
+
+		*f = append(*f, quotaRule{prefix: kv[0], n: n})
+	}
+	return nil
+}
+
+// pathQuota is an independent quota of requests to paths with the prefix.
+type pathQuota struct {
+	prefix string
+	quota  *capacity.Quota
+}
+
+// pathQuotas are quotas ordered from the longest prefix to the shortest.
+type pathQuotas []pathQuota
+
+// newPathQuotas creates a quota per rule, newQuota is called with a rule's prefix and quota.
+func newPathQuotas(rr []quotaRule, newQuota func(prefix string, n int64) *capacity.Quota) pathQuotas {
+	pp := make(pathQuotas, 0, len(rr))
+	for _, r := range rr {
+		pp = append(pp, pathQuota{prefix: r.prefix, quota: newQuota(r.prefix, r.n)})
+	}
+	sort.SliceStable(pp, func(i, j int) bool {
+		return len(pp[i].prefix) > len(pp[j].prefix)
+	})
+	return pp
+}
+
+// Match returns a quota of the longest prefix matching the path,
+// or nil if none of the prefixes match.
+func (pp pathQuotas) Match(path string) *capacity.Quota {
+	for _, p := range pp {
+		if strings.HasPrefix(path, p.prefix) {
+			return p.quota
+		}
+	}
+	return nil
+}
+
+// Handler passes a quota of the request's path to next in the request's context, see pathQuotaFrom.
+func (pp pathQuotas) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if q := pp.Match(r.URL.Path); q != nil {
+			r = r.WithContext(withPathQuota(r.Context(), q))
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// pathQuotaKey is a context key of a path quota chosen for a request.
+type pathQuotaKey struct{}
+
+// withPathQuota returns a copy of ctx with the path quota q.
+func withPathQuota(ctx context.Context, q *capacity.Quota) context.Context {
+	return context.WithValue(ctx, pathQuotaKey{}, q)
+}
+
+// pathQuotaFrom returns a path quota chosen for a request with the given ctx, or nil if there is none.
+// The quota is looked up in ctx rather than by path, because the path might have been rewritten.
+func pathQuotaFrom(ctx context.Context) *capacity.Quota {
+	q, _ := ctx.Value(pathQuotaKey{}).(*capacity.Quota)
+	return q
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/marselester/capacity"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestQuotaRuleFlag(t *testing.T) {
+	var f quotaRuleFlag
+	for _, spec := range []string{"/api/heavy=2,/api/light=20", " /admin=1 ,"} {
+		if err := f.Set(spec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := "/api/heavy=2,/api/light=20,/admin=1"
+	if got := f.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestQuotaRuleFlagError(t *testing.T) {
+	for _, spec := range []string{"/api", "api=1", "/api=0", "/api=x", "/api=1=2"} {
+		var f quotaRuleFlag
+		if err := f.Set(spec); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
+
+func TestPathQuotasMatch(t *testing.T) {
+	var api, heavy capacity.Quota
+	pp := newPathQuotas(
+		[]quotaRule{{prefix: "/api", n: 10}, {prefix: "/api/heavy", n: 2}},
+		func(prefix string, n int64) *capacity.Quota {
+			if prefix == "/api" {
+				return &api
+			}
+			return &heavy
+		},
+	)
+	tests := map[string]*capacity.Quota{
+		"/api/heavy/report": &heavy,
+		"/api/light":        &api,
+		"/api":              &api,
+		"/static/cat.png":   nil,
+	}
+	for path, want := range tests {
+		if got := pp.Match(path); got != want {
+			t.Errorf("%s: got %p, want %p", path, got, want)
+		}
+	}
+}
+
+func TestPathQuotasHandler(t *testing.T) {
+	inflight := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "inflight"}, []string{"path"})
+	target := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "target"}, []string{"path"})
+	pp := newPathQuotas(
+		[]quotaRule{{prefix: "/api/heavy", n: 2}, {prefix: "/api/light", n: 20}},
+		func(prefix string, n int64) *capacity.Quota {
+			return capacity.NewQuota(n,
+				capacity.WithCurrentGauge(inflight.WithLabelValues(prefix)),
+				capacity.WithTargetGauge(target.WithLabelValues(prefix)),
+			)
+		},
+	)
+	fallback := capacity.NewQuota(1)
+
+	// Admitted requests hold their quota until they're released.
+	release := make(chan struct{})
+	admitted := make(chan struct{})
+	h := pp.Handler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		q := pathQuotaFrom(r.Context())
+		if q == nil {
+			q = fallback
+		}
+		if !q.Receive() {
+			rw.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		defer q.Release()
+		admitted <- struct{}{}
+		<-release
+	}))
+	serve := func(path string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	done := make(chan int)
+	for i := 0; i < 2; i++ {
+		go func() { done <- serve("/api/heavy") }()
+		<-admitted
+	}
+	if code := serve("/api/heavy/report"); code != http.StatusTooManyRequests {
+		t.Errorf("saturated path got %d, want 429", code)
+	}
+	if got := testutil.ToFloat64(inflight.WithLabelValues("/api/heavy")); got != 2 {
+		t.Errorf("/api/heavy in-flight %v, want 2", got)
+	}
+
+	// Neither the other path nor unmatched paths are affected.
+	for _, path := range []string{"/api/light", "/"} {
+		go func(path string) { done <- serve(path) }(path)
+		<-admitted
+	}
+	if got := testutil.ToFloat64(inflight.WithLabelValues("/api/light")); got != 1 {
+		t.Errorf("/api/light in-flight %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(target.WithLabelValues("/api/light")); got != 20 {
+		t.Errorf("/api/light target %v, want 20", got)
+	}
+
+	close(release)
+	for i := 0; i < 4; i++ {
+		if code := <-done; code != http.StatusOK {
+			t.Errorf("admitted request got %d, want 200", code)
+		}
+	}
+	if got := testutil.ToFloat64(inflight.WithLabelValues("/api/heavy")); got != 0 {
+		t.Errorf("/api/heavy in-flight %v, want 0", got)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// PIDLimit is a concurrency limit driven by a PI controller
+// which aims to keep observed latency of requests at a setpoint.
+// The limit grows when requests are faster than the setpoint and shrinks when they are slower.
+type PIDLimit struct {
+	mu sync.Mutex
+	// setpoint is a desired round-trip time of a request.
+	setpoint time.Duration
+	// kp and ki are proportional and integral gains.
+	kp float64
+	ki float64
+	// bias is a limit the controller starts with.
+	bias float64
+	// integral is an accumulated latency error.
+	integral float64
+	// limit is the most recent limit computed by the controller.
+	limit float64
+	// min and max bound the limit.
+	min float64
+	max float64
+}
+
+// NewPIDLimit creates a PI controlled limit that starts with n requests
+// and stays within [min, max] bounds.
+func NewPIDLimit(n int64, setpoint time.Duration, kp, ki float64, min, max int64) *PIDLimit {
+	l := PIDLimit{
+		setpoint: setpoint,
+		kp:       kp,
+		ki:       ki,
+		bias:     float64(n),
+		limit:    float64(n),
+		min:      float64(min),
+		max:      float64(max),
+	}
+	return &l
+}
+
+// Observe records a round-trip time of a request and returns an updated limit.
+func (l *PIDLimit) Observe(rtt time.Duration) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// The error is normalized by setpoint so the gains don't depend on latency scale,
+	// e.g., the error is 0.5 when a request took half of the setpoint.
+	e := float64(l.setpoint-rtt) / float64(l.setpoint)
+
+	integral := l.integral + e
+	limit := l.bias + l.kp*e + l.ki*integral
+	// Anti-windup: the error isn't accumulated while the limit is clamped,
+	// otherwise the limit would stick to a bound long after latency recovered.
+	switch {
+	case limit < l.min:
+		limit = l.min
+	case limit > l.max:
+		limit = l.max
+	default:
+		l.integral = integral
+	}
+	l.limit = limit
+
+	return int64(limit + 0.5)
+}
+
+// Limit returns the most recent limit computed by the controller.
+func (l *PIDLimit) Limit() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return int64(l.limit + 0.5)
+}
+
+// State returns the controller's internal state.
+func (l *PIDLimit) State() map[string]interface{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return map[string]interface{}{
+		"setpoint": l.setpoint.String(),
+		"limit":    l.limit,
+		"integral": l.integral,
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPIDLimitConverges(t *testing.T) {
+	l := NewPIDLimit(10, 100*time.Millisecond, 5, 1, 1, 100)
+
+	// Origin serves 200 requests per second, so by Little's law
+	// latency is 100ms setpoint when 20 requests are in-flight.
+	var limits []float64
+	limit := l.Limit()
+	for i := 0; i < 300; i++ {
+		rtt := time.Duration(limit) * 5 * time.Millisecond
+		limit = l.Observe(rtt)
+		limits = append(limits, l.limit)
+	}
+
+	if got := l.Limit(); got != 20 {
+		t.Errorf("limit %d, want 20", got)
+	}
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, v := range limits[len(limits)-50:] {
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+	if hi-lo > 1 {
+		t.Errorf("limit oscillates within [%.2f, %.2f] during the last 50 observations", lo, hi)
+	}
+}
+
+func TestPIDLimitAntiWindup(t *testing.T) {
+	l := NewPIDLimit(10, 100*time.Millisecond, 5, 1, 1, 30)
+
+	// Origin is idle for a long time, so the limit is clamped to max.
+	for i := 0; i < 1000; i++ {
+		l.Observe(time.Millisecond)
+	}
+	if got := l.Limit(); got != 30 {
+		t.Fatalf("limit %d, want 30", got)
+	}
+	// Once origin is slow, the limit leaves the bound right away
+	// rather than unwinding an error accumulated while it was clamped.
+	l.Observe(time.Second)
+	l.Observe(time.Second)
+	if got := l.Limit(); got >= 30 {
+		t.Errorf("limit %d, want below 30 right after latency exceeded the setpoint", got)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httputil"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/marselester/capacity"
+)
+
+// backendPool is a set of backends which can be replaced at runtime, e.g., by service discovery.
+type backendPool struct {
+	// defaultQuota is a quota of a backend which doesn't specify its own.
+	defaultQuota int64
+	metrics      backendMetrics
+	ejection     ejectionPolicy
+	// warmup is how long a newly added or re-admitted backend ramps up its share of quota.
+	warmup time.Duration
+
+	// mu serializes updates of the backends.
+	mu sync.Mutex
+	// backends holds []*backend which is replaced atomically on update.
+	backends atomic.Value
+}
+
+// newBackendPool creates an empty pool of backends.
+// Backends are allowed n concurrent requests unless their quota is specified.
+func newBackendPool(n int64, m backendMetrics, ejection ejectionPolicy, warmup time.Duration) *backendPool {
+	p := backendPool{
+		defaultQuota: n,
+		metrics:      m,
+		ejection:     ejection,
+		warmup:       warmup,
+	}
+	p.backends.Store([]*backend{})
+	return &p
+}
+
+// Backends returns current backends, the slice must not be modified.
+func (p *backendPool) Backends() []*backend {
+	return p.backends.Load().([]*backend)
+}
+
+// Specs returns current backends as origin URLs followed by their quotas.
+func (p *backendPool) Specs() []string {
+	var specs []string
+	for _, b := range p.Backends() {
+		specs = append(specs, fmt.Sprintf("%s=%d", b.url, b.quota.Max()))
+	}
+	return specs
+}
+
+// Update replaces backends with the given origin URLs, e.g., http://localhost:8000=10.
+// Backends which remain in the pool keep their state;
+// removed ones stop receiving requests and they are discarded once their in-flight requests finish.
+func (p *backendPool) Update(specs []string) error {
+	if len(specs) == 0 {
+		return errors.New("at least one origin is required")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	current := make(map[string]*backend)
+	for _, b := range p.Backends() {
+		current[b.url.String()] = b
+	}
+	// The initial backends don't warm up since they get load gradually as the proxy starts.
+	var added time.Time
+	if len(current) > 0 {
+		added = time.Now()
+	}
+
+	var backends []*backend
+	seen := make(map[string]bool)
+	for _, s := range specs {
+		u, quota, err := parseBackend(s)
+		if err != nil {
+			return err
+		}
+		if seen[u.String()] {
+			return fmt.Errorf("origin %q is listed more than once", u)
+		}
+		seen[u.String()] = true
+
+		if b, ok := current[u.String()]; ok {
+			if quota > 0 && quota != b.quota.Max() {
+				b.quota.Set(quota)
+			}
+			delete(current, u.String())
+			backends = append(backends, b)
+			continue
+		}
+
+		if quota == 0 {
+			quota = p.defaultQuota
+		}
+		backends = append(backends, &backend{
+			url:      u,
+			director: httputil.NewSingleHostReverseProxy(u).Director,
+			quota: capacity.NewQuota(quota,
+				capacity.WithCurrentGauge(p.metrics.inflight.WithLabelValues(u.Host)),
+				capacity.WithTargetGauge(p.metrics.targetInflight.WithLabelValues(u.Host)),
+			),
+			rtt:     p.metrics.rtt.WithLabelValues(u.Host),
+			ttfb:    p.metrics.ttfb.WithLabelValues(u.Host),
+			outlier: &outlierDetector{policy: p.ejection},
+			added:   added,
+			warmup:  p.warmup,
+		})
+	}
+	p.backends.Store(backends)
+
+	for _, b := range current {
+		go p.retire(b)
+	}
+	return nil
+}
+
+// retire drains a removed backend and deletes its metrics once in-flight requests finish.
+func (p *backendPool) retire(b *backend) {
+	b.quota.Drain()
+	for b.quota.Used() > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Metrics are shared with a backend of the same host if it's still in the pool.
+	for _, other := range p.Backends() {
+		if other.url.Host == b.url.Host {
+			return
+		}
+	}
+	p.metrics.inflight.DeleteLabelValues(b.url.Host)
+	p.metrics.targetInflight.DeleteLabelValues(b.url.Host)
+	p.metrics.rtt.DeleteLabelValues(b.url.Host)
+	p.metrics.ttfb.DeleteLabelValues(b.url.Host)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackendPoolUpdate(t *testing.T) {
+	pool := newTestPool(t, 10, 0, "http://a", "http://b")
+	rr := roundRobin{pool: pool}
+	// pick returns how many of n requests went to every backend, in-flight requests are released right away.
+	pick := func(n int) map[string]int {
+		picks := make(map[string]int)
+		for i := 0; i < n; i++ {
+			b := rr.Pick()
+			picks[b.url.Host]++
+			b.quota.Release()
+		}
+		return picks
+	}
+
+	// A request to b is still in-flight when b is removed.
+	removed := pool.Backends()[1]
+	removed.quota.Receive()
+	if err := pool.Update([]string{"http://a", "http://c=5"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := pick(10); got["a"] != 5 || got["c"] != 5 {
+		t.Errorf("got %v, want traffic to shift from b to c", got)
+	}
+	if got := strings.Join(pool.Specs(), ","); got != "http://a=10,http://c=5" {
+		t.Errorf("got specs %s", got)
+	}
+
+	// The removed backend drains: it admits no new requests and its in-flight one finishes normally.
+	for deadline := time.Now().Add(time.Second); !removed.quota.Draining() && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if removed.receive() {
+		t.Error("removed backend admits requests")
+	}
+	removed.quota.Release()
+	if removed.quota.Used() != 0 {
+		t.Errorf("removed backend used %d, want 0", removed.quota.Used())
+	}
+
+	// A backend which stays in the pool keeps its state.
+	a := pool.Backends()[0]
+	a.quota.Receive()
+	if err := pool.Update([]string{"http://a"}); err != nil {
+		t.Fatal(err)
+	}
+	if pool.Backends()[0] != a || a.quota.Used() != 1 {
+		t.Error("backend a was replaced")
+	}
+	a.quota.Release()
+}
+
+func TestBackendsHandler(t *testing.T) {
+	pool := newTestPool(t, 10, 0, "http://a")
+	h := backendsHandler(pool)
+
+	tests := []struct {
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{body: `["http://a", "http://b=3"]`, wantStatus: http.StatusOK, wantBody: `["http://a=10","http://b=3"]` + "\n"},
+		{body: `[]`, wantStatus: http.StatusBadRequest},
+		{body: `{"origin": "http://a"}`, wantStatus: http.StatusBadRequest},
+		{body: `["localhost"]`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		rw := httptest.NewRecorder()
+		h(rw, httptest.NewRequest(http.MethodPost, "/admin/backends", strings.NewReader(tc.body)))
+		if rw.Code != tc.wantStatus {
+			t.Errorf("%s: status %d, want %d", tc.body, rw.Code, tc.wantStatus)
+		}
+		if tc.wantBody != "" && rw.Body.String() != tc.wantBody {
+			t.Errorf("%s: got %s, want %s", tc.body, rw.Body, tc.wantBody)
+		}
+	}
+	// Rejected updates didn't change the pool.
+	if got := strings.Join(pool.Specs(), ","); got != "http://a=10,http://b=3" {
+		t.Errorf("got specs %s", got)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/marselester/capacity"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// baselineObserver is a limiter which relies on round-trip time of an unloaded origin (minimum RTT).
+type baselineObserver interface {
+	// ObserveBaseline records round-trip time of a probe request.
+	ObserveBaseline(rtt time.Duration)
+}
+
+// prober periodically sends a lightweight request to origin while the proxy has little traffic
+// to measure baseline round-trip time, so that it doesn't drift stale.
+type prober struct {
+	path     string
+	quota    *capacity.Quota
+	backends *roundRobin
+	client   *http.Client
+	// baseline is a gauge of the most recent probe's round-trip time.
+	baseline prometheus.Gauge
+	// observers are fed with probe round-trip times.
+	observers []baselineObserver
+}
+
+// Run probes origin every interval.
+// Probes are skipped when more than half of quota is in use, because queueing would skew the baseline.
+func (p *prober) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		p.tick(interval)
+	}
+}
+
+// tick sends a probe request unless more than half of quota is in use,
+// and feeds its round-trip time to the observers.
+// The probe is abandoned after the timeout.
+func (p *prober) tick(timeout time.Duration) {
+	if p.quota.Used()*2 > p.quota.Max() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	rtt, err := p.probe(ctx, p.backends.Next())
+	cancel()
+	if err != nil {
+		log.Printf("proxy: probe failed: %v", err)
+		return
+	}
+
+	p.baseline.Set(rtt.Seconds())
+	for _, o := range p.observers {
+		o.ObserveBaseline(rtt)
+	}
+}
+
+// probe sends a probe request to backend b and returns its round-trip time.
+func (p *prober) probe(ctx context.Context, b *backend) (time.Duration, error) {
+	u := *b.url
+	u.Path = singleJoiningSlash(u.Path, p.path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	begun := time.Now()
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	rtt := time.Since(begun)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	return rtt, nil
+}
+
+// singleJoiningSlash joins URL paths a and b with a single slash.
+func singleJoiningSlash(a, b string) string {
+	switch aslash, bslash := len(a) > 0 && a[len(a)-1] == '/', len(b) > 0 && b[0] == '/'; {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/marselester/capacity"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// baselineRecorder remembers round-trip times of probes.
+type baselineRecorder []time.Duration
+
+func (r *baselineRecorder) ObserveBaseline(rtt time.Duration) {
+	*r = append(*r, rtt)
+}
+
+func TestProberTick(t *testing.T) {
+	paths := make(chan string, 1)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.Path
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer origin.Close()
+
+	tests := map[string]struct {
+		used   int64
+		probed bool
+	}{
+		"idle":      {used: 0, probed: true},
+		"half used": {used: 5, probed: true},
+		"busy":      {used: 6},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := capacity.NewQuota(10)
+			for i := int64(0); i < tc.used; i++ {
+				q.Receive()
+			}
+			var rec baselineRecorder
+			c := NewUtilizationController(q, 0.8, 1, 100, 2)
+			c.Observe(100*time.Millisecond, false)
+			p := prober{
+				path:      "/health",
+				quota:     q,
+				backends:  &roundRobin{pool: newTestPool(t, 10, 0, origin.URL+"/api")},
+				client:    origin.Client(),
+				baseline:  prometheus.NewGauge(prometheus.GaugeOpts{Name: "baseline"}),
+				observers: []baselineObserver{&rec, c},
+			}
+			p.tick(time.Second)
+
+			if !tc.probed {
+				if len(paths) != 0 || len(rec) != 0 {
+					t.Fatalf("probed %q while quota was busy", <-paths)
+				}
+				return
+			}
+			if path := <-paths; path != "/api/health" {
+				t.Fatalf("probed %q, want /api/health", path)
+			}
+			if len(rec) != 1 || rec[0] < 20*time.Millisecond {
+				t.Fatalf("observed %v, want one probe of at least 20ms", rec)
+			}
+			if got, want := testutil.ToFloat64(p.baseline), rec[0].Seconds(); got != want {
+				t.Errorf("baseline gauge %v, want %v", got, want)
+			}
+			// The probe replaced the stale baseline of a queued request.
+			if c.baseline != rec[0] {
+				t.Errorf("controller baseline %v, want %v", c.baseline, rec[0])
+			}
+		})
+	}
+}
+
+func TestSingleJoiningSlash(t *testing.T) {
+	tests := map[string]struct {
+		a, b string
+		want string
+	}{
+		"no slashes":   {a: "/api", b: "health", want: "/api/health"},
+		"both slashes": {a: "/api/", b: "/health", want: "/api/health"},
+		"one slash":    {a: "/api", b: "/health", want: "/api/health"},
+		"empty":        {a: "", b: "/health", want: "/health"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := singleJoiningSlash(tc.a, tc.b); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
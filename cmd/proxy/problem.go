@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// problem is an RFC 7807 problem details object which describes why a request failed.
+type problem struct {
+	// Type is a URI reference that identifies the problem type.
+	Type string `json:"type"`
+	// Title is a short summary of the problem type.
+	Title string `json:"title"`
+	// Status is the HTTP status code.
+	Status int `json:"status"`
+	// Detail is an explanation specific to this occurrence of the problem.
+	Detail string `json:"detail,omitempty"`
+}
+
+// problemWriter responds with application/problem+json bodies.
+type problemWriter struct {
+	// typeURI is a URI prefix of problem types, e.g., https://example.com/problems/.
+	// When it's empty, problems have about:blank type and their titles are status texts.
+	typeURI string
+}
+
+// Write responds with a problem of the given status.
+// The problem type is typeURI followed by the name, e.g., https://example.com/problems/quota-exhausted.
+func (w problemWriter) Write(rw http.ResponseWriter, status int, name, detail string) {
+	p := problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+	if w.typeURI != "" {
+		p.Type = w.typeURI + name
+	}
+
+	rw.Header().Set("Content-Type", "application/problem+json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(p)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProblemWriter(t *testing.T) {
+	tests := map[string]struct {
+		typeURI string
+		want    problem
+	}{
+		"about:blank": {
+			want: problem{
+				Type:   "about:blank",
+				Title:  "Too Many Requests",
+				Status: http.StatusTooManyRequests,
+				Detail: "Too many requests are in-flight to origin.",
+			},
+		},
+		"type uri": {
+			typeURI: "https://example.com/problems/",
+			want: problem{
+				Type:   "https://example.com/problems/quota-exhausted",
+				Title:  "Too Many Requests",
+				Status: http.StatusTooManyRequests,
+				Detail: "Too many requests are in-flight to origin.",
+			},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			problemWriter{typeURI: tc.typeURI}.Write(rec, http.StatusTooManyRequests, "quota-exhausted", "Too many requests are in-flight to origin.")
+
+			if rec.Code != http.StatusTooManyRequests {
+				t.Errorf("status %d, want %d", rec.Code, http.StatusTooManyRequests)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+				t.Errorf("content type %q, want application/problem+json", ct)
+			}
+			var got problem
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// newProcessCollector creates a collector of the proxy's CPU and memory usage.
+// Default registry already exports process_* and go_* metrics,
+// though proxy_process_* metrics are easier to tell apart on a shared dashboard.
+func newProcessCollector() prometheus.Collector {
+	return collectors.NewProcessCollector(collectors.ProcessCollectorOpts{
+		Namespace: "proxy",
+	})
+}
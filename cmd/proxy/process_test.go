@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestProcessMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(newProcessCollector())
+	gatherers := prometheus.Gatherers{reg, prometheus.DefaultGatherer}
+	srv := httptest.NewServer(promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{
+		"proxy_process_cpu_seconds_total",
+		"proxy_process_resident_memory_bytes",
+		"proxy_process_open_fds",
+		"go_goroutines",
+		"go_memstats_heap_inuse_bytes",
+	} {
+		if !strings.Contains(string(b), "\n"+name+" ") {
+			t.Errorf("%s is missing on /metrics", name)
+		}
+	}
+}
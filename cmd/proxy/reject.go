@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// rejecter responds with 429 when quota is exhausted.
+// It observes how long it took to reject a request since it was received.
+type rejecter struct {
+	// problems writes application/problem+json bodies, nil means a plain body.
+	problems *problemWriter
+	duration prometheus.Observer
+}
+
+// Reject responds to a request which wasn't admitted.
+func (j rejecter) Reject(rw http.ResponseWriter, r *http.Request) {
+	defer func() {
+		j.duration.Observe(sinceReceived(r.Context()).Seconds())
+	}()
+	if j.problems != nil {
+		j.problems.Write(rw, http.StatusTooManyRequests, "quota-exhausted", "Too many requests are in-flight to origin.")
+		return
+	}
+	rw.WriteHeader(http.StatusTooManyRequests)
+	fmt.Fprint(rw, "🚦\n")
+}
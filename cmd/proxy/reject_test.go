@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/marselester/capacity/internal/derive"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRejecterObservesDuration(t *testing.T) {
+	tests := map[string]struct {
+		problems    *problemWriter
+		contentType string
+	}{
+		"plain":        {},
+		"problem json": {problems: &problemWriter{}, contentType: "application/problem+json"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			reg := prometheus.NewRegistry()
+			duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "duration"}, []string{"outcome"})
+			reg.MustRegister(duration)
+			j := rejecter{problems: tc.problems, duration: duration.WithLabelValues("rejected")}
+
+			for i := 0; i < 3; i++ {
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				r = r.WithContext(withReceived(r.Context(), time.Now()))
+				rw := httptest.NewRecorder()
+				j.Reject(rw, r)
+				if rw.Code != http.StatusTooManyRequests {
+					t.Errorf("status %d, want %d", rw.Code, http.StatusTooManyRequests)
+				}
+				if ct := rw.Header().Get("Content-Type"); tc.contentType != "" && ct != tc.contentType {
+					t.Errorf("content type %q, want %q", ct, tc.contentType)
+				}
+			}
+
+			tt, err := derive.Gather(reg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			count, sum := tt.Sum("duration", func(labels map[string]string) bool {
+				return labels["outcome"] == "rejected"
+			})
+			if count != 3 {
+				t.Errorf("observed %v rejections, want 3", count)
+			}
+			// Rejections are near-instant.
+			if sum > 0.01 {
+				t.Errorf("rejections took %vs, want under 10ms", sum)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/marselester/capacity"
+)
+
+// parseRetryAfter returns the time indicated by Retry-After header value h
+// which is either delay in seconds or HTTP-date, e.g., 120 or Fri, 31 Dec 1999 23:59:59 GMT.
+// It returns false if the value can't be parsed.
+func parseRetryAfter(h string, now time.Time) (time.Time, bool) {
+	if h == "" {
+		return time.Time{}, false
+	}
+	if seconds, err := strconv.Atoi(h); err == nil {
+		if seconds < 0 {
+			return time.Time{}, false
+		}
+		return now.Add(time.Duration(seconds) * time.Second), true
+	}
+	t, err := http.ParseTime(h)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// holdForRetryAfter prevents quota q from being lifted until the time origin asked to retry after.
+// It returns false if the response has no valid Retry-After header.
+func holdForRetryAfter(resp *http.Response, q *capacity.Quota, now time.Time) bool {
+	until, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now)
+	if ok {
+		q.HoldUntil(until)
+	}
+	return ok
+}
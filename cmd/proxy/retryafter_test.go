@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/marselester/capacity"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(1999, 12, 31, 23, 58, 0, 0, time.UTC)
+	tests := map[string]struct {
+		h      string
+		want   time.Time
+		wantOK bool
+	}{
+		"delay seconds": {h: "120", want: now.Add(2 * time.Minute), wantOK: true},
+		"http date":     {h: "Fri, 31 Dec 1999 23:59:59 GMT", want: time.Date(1999, 12, 31, 23, 59, 59, 0, time.UTC), wantOK: true},
+		"absent":        {},
+		"negative":      {h: "-1"},
+		"garbage":       {h: "soon"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, ok := parseRetryAfter(tc.h, now)
+			if ok != tc.wantOK || !got.Equal(tc.want) {
+				t.Errorf("got %v %t, want %v %t", got, ok, tc.want, tc.wantOK)
+			}
+		})
+	}
+}
+
+func TestHoldForRetryAfter(t *testing.T) {
+	tests := map[string]struct {
+		h       string
+		held    bool
+		wantMax int64
+	}{
+		"delay seconds": {h: "60", held: true, wantMax: 10},
+		"http date":     {h: time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), held: true, wantMax: 10},
+		"past date":     {h: time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), held: true, wantMax: 11},
+		"absent":        {wantMax: 11},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := capacity.NewQuota(10)
+			resp := http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}}
+			if tc.h != "" {
+				resp.Header.Set("Retry-After", tc.h)
+			}
+			if held := holdForRetryAfter(&resp, q, time.Now()); held != tc.held {
+				t.Errorf("held %t, want %t", held, tc.held)
+			}
+			q.Inc()
+			if q.Max() != tc.wantMax {
+				t.Errorf("max %d, want %d", q.Max(), tc.wantMax)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// rewriteRule replaces a path prefix before a request is forwarded to origin, e.g., /public/ with /internal/.
+type rewriteRule struct {
+	from string
+	to   string
+}
+
+// rewriteRules are path rewrite rules, the first matching rule is applied.
+type rewriteRules []rewriteRule
+
+// parseRewriteRules parses a comma-separated list of from=to path prefixes, e.g., /public/=/internal/,/v1/=/.
+func parseRewriteRules(spec string) (rewriteRules, error) {
+	var rr rewriteRules
+	for _, s := range strings.Split(spec, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+
+		kv := strings.Split(s, "=")
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("rewrite rule %q: want from=to", s)
+		}
+		if !strings.HasPrefix(kv[0], "/") || !strings.HasPrefix(kv[1], "/") {
+			return nil, fmt.Errorf("rewrite rule %q: path prefixes must start with /", s)
+		}
+
+		rr = append(rr, rewriteRule{from: kv[0], to: kv[1]})
+	}
+	return rr, nil
+}
+
+// Apply replaces the path prefix of r according to the first matching rule.
+func (rr rewriteRules) Apply(r *http.Request) {
+	for _, rule := range rr {
+		if strings.HasPrefix(r.URL.Path, rule.from) {
+			r.URL.Path = rule.to + strings.TrimPrefix(r.URL.Path, rule.from)
+			// The escaped path keeps encoding of the rest of the path, e.g., %2F,
+			// unless the prefix itself was escaped, then it's derived from the new path.
+			if strings.HasPrefix(r.URL.RawPath, rule.from) {
+				r.URL.RawPath = rule.to + strings.TrimPrefix(r.URL.RawPath, rule.from)
+			} else {
+				r.URL.RawPath = ""
+			}
+			return
+		}
+	}
+}
+
+// setHost sets Host header sent to origin: the rewritten host if it's not empty,
+// the client's Host when it's preserved, otherwise the backend's host.
+func setHost(r *http.Request, rewriteHost string, preserve bool) {
+	switch {
+	case rewriteHost != "":
+		r.Host = rewriteHost
+	// The transport sends the backend's host when Host is empty.
+	case !preserve:
+		r.Host = ""
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+)
+
+func TestParseRewriteRulesError(t *testing.T) {
+	tests := map[string]string{
+		"no equals":        "/public/",
+		"too many equals":  "/a/=/b/=/c/",
+		"relative from":    "public/=/internal/",
+		"relative to":      "/public/=internal/",
+		"one of two wrong": "/v1/=/,/public/",
+	}
+	for name, spec := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseRewriteRules(spec); err == nil {
+				t.Errorf("expected an error for %q", spec)
+			}
+		})
+	}
+}
+
+func TestRewriteRules(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.URL.EscapedPath())
+	}))
+	defer origin.Close()
+
+	rr, err := parseRewriteRules("/public/=/internal/, /v1/=/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(origin.URL)
+	proxy := httputil.NewSingleHostReverseProxy(u)
+	direct := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		rr.Apply(r)
+		direct(r)
+	}
+	srv := httptest.NewServer(proxy)
+	defer srv.Close()
+
+	tests := map[string]struct {
+		path string
+		want string
+	}{
+		"replaced prefix": {path: "/public/cats", want: "/internal/cats"},
+		"stripped prefix": {path: "/v1/cats", want: "/cats"},
+		"no match":        {path: "/private/cats", want: "/private/cats"},
+		"escaped rest":    {path: "/public/a%2Fb", want: "/internal/a%2Fb"},
+		"prefix only":     {path: "/public/", want: "/internal/"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			resp, err := http.Get(srv.URL + tc.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if got := string(b); got != tc.want {
+				t.Errorf("origin received %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSetHost(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Host)
+	}))
+	defer origin.Close()
+	u, _ := url.Parse(origin.URL)
+
+	tests := map[string]struct {
+		rewriteHost string
+		preserve    bool
+		want        string
+	}{
+		"origin's host": {want: u.Host},
+		"preserved":     {preserve: true, want: "cats.example.com"},
+		"rewritten":     {rewriteHost: "dogs.example.com", want: "dogs.example.com"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			proxy := httputil.NewSingleHostReverseProxy(u)
+			direct := proxy.Director
+			proxy.Director = func(r *http.Request) {
+				direct(r)
+				setHost(r, tc.rewriteHost, tc.preserve)
+			}
+			srv := httptest.NewServer(proxy)
+			defer srv.Close()
+
+			req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+			req.Host = "cats.example.com"
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if got := string(b); got != tc.want {
+				t.Errorf("origin received Host %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
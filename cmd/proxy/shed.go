@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/marselester/capacity"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// weightedShedder drops requests of a class with probability of the class weight once quota is saturated,
+// e.g., 90% of low priority and 10% of normal requests, so that important requests keep most of the capacity.
+// Unlisted classes aren't dropped, though they're still rejected when quota runs out.
+type weightedShedder struct {
+	// header is a request header which holds a class of the request, e.g., X-Priority.
+	header  string
+	weights map[string]float64
+	// saturation is quota utilization (in-flight requests / target concurrency) from which requests are shed.
+	saturation float64
+	shed       *prometheus.CounterVec
+}
+
+// parseShedWeights parses a comma-separated list of class:weight rules, e.g., low:0.9,normal:0.1,
+// where a weight is a fraction of the class requests to drop.
+func parseShedWeights(spec string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	for _, s := range strings.Split(spec, ",") {
+		kv := strings.Split(strings.TrimSpace(s), ":")
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("shed weight %q: want class:weight", s)
+		}
+		w, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil || w < 0 || w > 1 {
+			return nil, fmt.Errorf("shed weight %q: weight must be in [0, 1]", s)
+		}
+		weights[kv[0]] = w
+	}
+	return weights, nil
+}
+
+// Shed reports whether request r should be dropped given its quota q.
+func (s *weightedShedder) Shed(r *http.Request, q *capacity.Quota) bool {
+	if float64(q.Used()) < s.saturation*float64(q.Max()) {
+		return false
+	}
+	class := r.Header.Get(s.header)
+	w, ok := s.weights[class]
+	if !ok || rand.Float64() >= w {
+		return false
+	}
+	s.shed.WithLabelValues(class).Inc()
+	return true
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/marselester/capacity"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestWeightedShedderRates(t *testing.T) {
+	weights, err := parseShedWeights("low:0.9, normal:0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := weightedShedder{
+		header:     "X-Priority",
+		weights:    weights,
+		saturation: 0.9,
+		shed:       prometheus.NewCounterVec(prometheus.CounterOpts{Name: "shed"}, []string{"class"}),
+	}
+	q := capacity.NewQuota(10)
+	request := func(class string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if class != "" {
+			r.Header.Set("X-Priority", class)
+		}
+		return r
+	}
+
+	// Nothing is shed below the saturation.
+	for i := 0; i < 8; i++ {
+		q.Receive()
+	}
+	for i := 0; i < 1000; i++ {
+		if s.Shed(request("low"), q) {
+			t.Fatalf("request was shed at utilization %d/%d", q.Used(), q.Max())
+		}
+	}
+
+	// Sustained overload keeps quota saturated.
+	q.Receive()
+	const n = 10000
+	want := map[string]float64{"low": 0.9, "normal": 0.1, "high": 0, "": 0}
+	for class, rate := range want {
+		shed := 0
+		for i := 0; i < n; i++ {
+			if s.Shed(request(class), q) {
+				shed++
+			}
+		}
+		if got := float64(shed) / n; math.Abs(got-rate) > 0.02 {
+			t.Errorf("class %q: shed %.3f of requests, want %v", class, got, rate)
+		}
+		if got := testutil.ToFloat64(s.shed.WithLabelValues(class)); got != float64(shed) {
+			t.Errorf("class %q: shed counter %v, want %d", class, got, shed)
+		}
+	}
+}
+
+func TestParseShedWeightsError(t *testing.T) {
+	for _, spec := range []string{"low", ":0.5", "low:2", "low:-0.1", "low:x", "low:0.5,"} {
+		if _, err := parseShedWeights(spec); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marselester/capacity"
+	"golang.org/x/time/rate"
+)
+
+// sample is a response from origin fed to overload signals.
+type sample struct {
+	rtt        time.Duration
+	overloaded bool
+	// header is origin's response header, it's nil when origin didn't respond.
+	header http.Header
+}
+
+// sampleLimiter is a limiter which needs the whole response, not only its round-trip time and status.
+type sampleLimiter interface {
+	ObserveSample(s sample)
+}
+
+// observeLimiters feeds the same response to every limiter, e.g., to the live and shadow ones.
+func observeLimiters(s sample, ls ...Limiter) {
+	for _, l := range ls {
+		if sl, ok := l.(sampleLimiter); ok {
+			sl.ObserveSample(s)
+		} else {
+			l.Observe(s.rtt, s.overloaded)
+		}
+	}
+}
+
+// Signal indicates whether origin is overloaded.
+type Signal interface {
+	// Observe records a response and reports whether the signal crossed its threshold.
+	Observe(s sample) bool
+}
+
+// errorRateSignal fires when error rate of recent responses exceeds the threshold.
+type errorRateSignal struct {
+	errors    *outcomeWindow
+	threshold float64
+}
+
+func (s *errorRateSignal) Observe(smp sample) bool {
+	return s.errors.Record(smp.overloaded) > s.threshold
+}
+
+// latencySignal fires when average round-trip time of recent responses exceeds the threshold.
+type latencySignal struct {
+	rtts      *rttWindow
+	threshold time.Duration
+}
+
+func (s *latencySignal) Observe(smp sample) bool {
+	return s.rtts.Record(smp.rtt) > s.threshold
+}
+
+// queueDepthSignal fires when origin reports its queue is deeper than the threshold in a response header.
+// Responses without the header are considered healthy.
+type queueDepthSignal struct {
+	header    string
+	threshold int
+}
+
+func (s *queueDepthSignal) Observe(smp sample) bool {
+	depth, err := strconv.Atoi(smp.header.Get(s.header))
+	return err == nil && depth > s.threshold
+}
+
+// parseSignals parses a comma-separated list of name=threshold signals,
+// e.g., error-rate=0.1,latency=500ms,queue-depth=50.
+// Error rate and latency are calculated over window recent responses,
+// queue depth is read from the given response header.
+func parseSignals(spec string, window int, queueDepthHeader string) ([]Signal, error) {
+	var ss []Signal
+	for _, s := range strings.Split(spec, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+
+		kv := strings.Split(s, "=")
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("signal %q: want name=threshold", s)
+		}
+		switch kv[0] {
+		case "error-rate":
+			threshold, err := strconv.ParseFloat(kv[1], 64)
+			if err != nil || threshold < 0 || threshold >= 1 {
+				return nil, fmt.Errorf("signal %q: error rate threshold must be in [0, 1)", s)
+			}
+			ss = append(ss, &errorRateSignal{errors: newOutcomeWindow(window), threshold: threshold})
+		case "latency":
+			threshold, err := time.ParseDuration(kv[1])
+			if err != nil || threshold <= 0 {
+				return nil, fmt.Errorf("signal %q: latency threshold must be a positive duration", s)
+			}
+			ss = append(ss, &latencySignal{rtts: newRTTWindow(window), threshold: threshold})
+		case "queue-depth":
+			threshold, err := strconv.Atoi(kv[1])
+			if err != nil || threshold < 0 {
+				return nil, fmt.Errorf("signal %q: queue depth threshold must be a non-negative integer", s)
+			}
+			ss = append(ss, &queueDepthSignal{header: queueDepthHeader, threshold: threshold})
+		default:
+			return nil, fmt.Errorf("signal %q: want error-rate, latency, or queue-depth", s)
+		}
+	}
+	return ss, nil
+}
+
+// compositeLimiter backs off quota when any of the signals fires,
+// and increases quota by a step at the allowed increase rate only when all of them are clear.
+type compositeLimiter struct {
+	quota      *capacity.Quota
+	signals    []Signal
+	incLimiter *rate.Limiter
+	// incStep is how much quota grows by on every additive increase.
+	incStep int64
+	// onDemand allows an increase only if quota ran out since the previous one.
+	onDemand bool
+}
+
+// Observe feeds a response without a header to the signals.
+func (l *compositeLimiter) Observe(rtt time.Duration, overloaded bool) {
+	l.ObserveSample(sample{rtt: rtt, overloaded: overloaded})
+}
+
+// ObserveSample feeds a response to all the signals and adjusts quota.
+func (l *compositeLimiter) ObserveSample(s sample) {
+	fired := false
+	// Every signal observes the response, so that their windows stay up to date.
+	for _, sig := range l.signals {
+		if sig.Observe(s) {
+			fired = true
+		}
+	}
+
+	switch {
+	case fired:
+		l.quota.Backoff(0.75)
+	// Quota isn't increased unless it ran out since the previous increase.
+	case l.onDemand && !l.quota.Saturated():
+	case l.incLimiter.Allow():
+		l.quota.IncN(l.incStep)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/marselester/capacity"
+	"golang.org/x/time/rate"
+)
+
+// switchSignal fires when it's on.
+type switchSignal struct {
+	on       bool
+	observed int
+}
+
+func (s *switchSignal) Observe(sample) bool {
+	s.observed++
+	return s.on
+}
+
+func TestCompositeLimiter(t *testing.T) {
+	var errors, latency switchSignal
+	q := capacity.NewQuota(100)
+	l := compositeLimiter{
+		quota:      q,
+		signals:    []Signal{&errors, &latency},
+		incLimiter: rate.NewLimiter(rate.Inf, 1),
+		incStep:    1,
+	}
+	tests := []struct {
+		errors  bool
+		latency bool
+		want    int64
+	}{
+		{want: 101},
+		{errors: true, want: 76},
+		{latency: true, want: 57},
+		{errors: true, latency: true, want: 43},
+		{want: 44},
+	}
+	for _, tc := range tests {
+		errors.on, latency.on = tc.errors, tc.latency
+		l.Observe(time.Millisecond, false)
+		if q.Max() != tc.want {
+			t.Errorf("errors %t, latency %t: max %d, want %d", tc.errors, tc.latency, q.Max(), tc.want)
+		}
+	}
+	// Signals keep observing responses even when another one already fired.
+	if errors.observed != len(tests) || latency.observed != len(tests) {
+		t.Errorf("signals observed %d and %d responses, want %d", errors.observed, latency.observed, len(tests))
+	}
+}
+
+func TestParseSignals(t *testing.T) {
+	ss, err := parseSignals("error-rate=0.5, latency=10ms,queue-depth=3", 2, "X-Queue-Depth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ss) != 3 {
+		t.Fatalf("got %d signals, want 3", len(ss))
+	}
+	errorRate, latency, queueDepth := ss[0], ss[1], ss[2]
+
+	if errorRate.Observe(sample{}) {
+		t.Error("error rate fired without errors")
+	}
+	if errorRate.Observe(sample{overloaded: true}) {
+		t.Error("error rate fired at the threshold")
+	}
+	if !errorRate.Observe(sample{overloaded: true}) {
+		t.Error("error rate didn't fire at 2 errors out of 2")
+	}
+
+	latency.Observe(sample{rtt: 5 * time.Millisecond})
+	if !latency.Observe(sample{rtt: 20 * time.Millisecond}) {
+		t.Error("latency didn't fire at 12.5ms average")
+	}
+
+	if queueDepth.Observe(sample{}) {
+		t.Error("queue depth fired without the header")
+	}
+	if queueDepth.Observe(sample{header: http.Header{"X-Queue-Depth": {"3"}}}) {
+		t.Error("queue depth fired at the threshold")
+	}
+	if !queueDepth.Observe(sample{header: http.Header{"X-Queue-Depth": {"4"}}}) {
+		t.Error("queue depth didn't fire above the threshold")
+	}
+}
+
+func TestParseSignalsError(t *testing.T) {
+	for _, spec := range []string{"error-rate", "error-rate=1", "latency=0", "latency=fast", "queue-depth=-1", "cpu=0.9"} {
+		if _, err := parseSignals(spec, 10, "X-Queue-Depth"); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
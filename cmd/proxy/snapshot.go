@@ -0,0 +1,50 @@
+package main
+
+import (
+	"time"
+
+	"github.com/marselester/capacity"
+)
+
+// snapshot is a state of the proxy's capacity control at a moment in time.
+type snapshot struct {
+	Time      time.Time `json:"time"`
+	Algorithm string    `json:"algorithm"`
+	Used      int64     `json:"used"`
+	Max       int64     `json:"max"`
+	Draining  bool      `json:"draining"`
+	// Limiter is the algorithm's internal state, e.g., accumulated error of a PI controller.
+	Limiter  map[string]interface{} `json:"limiter,omitempty"`
+	Backends []backendSnapshot      `json:"backends"`
+}
+
+// backendSnapshot is a state of a backend at a moment in time.
+type backendSnapshot struct {
+	URL     string `json:"url"`
+	Used    int64  `json:"used"`
+	Max     int64  `json:"max"`
+	Ejected bool   `json:"ejected"`
+}
+
+// takeSnapshot captures the current state of quota, its limiter, and backends.
+func takeSnapshot(algorithm string, q *capacity.Quota, l Limiter, pool *backendPool) snapshot {
+	s := snapshot{
+		Time:      time.Now(),
+		Algorithm: algorithm,
+		Used:      q.Used(),
+		Max:       q.Max(),
+		Draining:  q.Draining(),
+	}
+	if r, ok := l.(stateReporter); ok {
+		s.Limiter = r.State()
+	}
+	for _, b := range pool.Backends() {
+		s.Backends = append(s.Backends, backendSnapshot{
+			URL:     b.url.String(),
+			Used:    b.quota.Used(),
+			Max:     b.quota.Max(),
+			Ejected: b.outlier.Ejected(),
+		})
+	}
+	return s
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/marselester/capacity"
+)
+
+func TestSnapshotHandler(t *testing.T) {
+	q := capacity.NewQuota(10)
+	l := &pidLimiter{quota: q, limit: NewPIDLimit(10, 100*time.Millisecond, 5, 1, 1, 100)}
+	pool := newTestPool(t, 3, 1, "http://a", "http://b")
+	h := snapshotHandler("pid", q, l, pool)
+
+	take := func() snapshot {
+		t.Helper()
+		rw := httptest.NewRecorder()
+		h(rw, httptest.NewRequest(http.MethodGet, "/admin/snapshot", nil))
+		if rw.Code != http.StatusOK {
+			t.Fatalf("status %d", rw.Code)
+		}
+		var s snapshot
+		if err := json.NewDecoder(rw.Body).Decode(&s); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	before := take()
+	// Two requests are in-flight, origin got slow, and backend b failed.
+	q.Receive()
+	q.Receive()
+	l.Observe(time.Second, false)
+	pool.Backends()[0].quota.Receive()
+	pool.Backends()[1].outlier.Record(true)
+	after := take()
+
+	if !after.Time.After(before.Time) {
+		t.Errorf("snapshot time %v isn't after %v", after.Time, before.Time)
+	}
+	if after.Algorithm != "pid" || after.Used != 2 || after.Max != 1 || after.Draining {
+		t.Errorf("got %+v", after)
+	}
+	if after.Limiter["limit"].(float64) >= before.Limiter["limit"].(float64) {
+		t.Errorf("limiter state %v didn't reflect the latency spike since %v", after.Limiter, before.Limiter)
+	}
+	want := []backendSnapshot{
+		{URL: "http://a", Used: 1, Max: 3},
+		{URL: "http://b", Max: 3, Ejected: true},
+	}
+	if len(after.Backends) != len(want) {
+		t.Fatalf("got backends %+v, want %+v", after.Backends, want)
+	}
+	for i := range want {
+		if after.Backends[i] != want[i] {
+			t.Errorf("got backend %+v, want %+v", after.Backends[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"time"
+
+	"github.com/marselester/capacity"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// trackTarget samples quota on every tick and attributes the elapsed time
+// to periods when in-flight requests were above (over) or below (under) target concurrency.
+func trackTarget(q *capacity.Quota, ticker *sampleTicker, over, under prometheus.Counter) {
+	defer ticker.Stop()
+
+	last := time.Now()
+	for now := range ticker.C {
+		// Elapsed time is measured rather than assumed to be the interval,
+		// because ticks are dropped when the receiver is slow.
+		elapsed := now.Sub(last).Seconds()
+		last = now
+
+		used, max := q.Used(), q.Max()
+		switch {
+		case used > max:
+			over.Add(elapsed)
+		case used < max:
+			under.Add(elapsed)
+		}
+	}
+}
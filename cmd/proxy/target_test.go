@@ -0,0 +1,63 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/marselester/capacity"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTrackTarget(t *testing.T) {
+	q := capacity.NewQuota(10)
+	over := prometheus.NewCounter(prometheus.CounterOpts{Name: "over"})
+	under := prometheus.NewCounter(prometheus.CounterOpts{Name: "under"})
+
+	// track samples quota as if two ticks were delivered: in a second after start, and after elapsed time.
+	// The first tick is a second ahead, so it's never earlier than when tracking started.
+	track := func(elapsed time.Duration) {
+		ticks := make(chan time.Time)
+		done := make(chan struct{})
+		go func() {
+			trackTarget(q, &sampleTicker{C: ticks, stop: func() {}}, over, under)
+			close(done)
+		}()
+		now := time.Now().Add(time.Second)
+		ticks <- now
+		ticks <- now.Add(elapsed)
+		close(ticks)
+		<-done
+	}
+
+	// Load is above the target after a back-off.
+	for i := 0; i < 10; i++ {
+		q.Receive()
+	}
+	q.Backoff(0.5)
+	track(2 * time.Second)
+	// Load is right at the target.
+	for i := 0; i < 5; i++ {
+		q.Release()
+	}
+	track(time.Second)
+	// Load is below the target.
+	for i := 0; i < 5; i++ {
+		q.Release()
+	}
+	track(3 * time.Second)
+
+	tests := map[string]struct {
+		c    prometheus.Counter
+		want float64
+	}{
+		"over":  {over, 1 + 2},
+		"under": {under, 1 + 3},
+	}
+	for name, tc := range tests {
+		if got := testutil.ToFloat64(tc.c); math.Abs(got-tc.want) > 0.1 {
+			t.Errorf("%s target %.3fs, want %vs", name, got, tc.want)
+		}
+	}
+}
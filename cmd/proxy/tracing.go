@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestSpan is a trace span of a request proxied to origin.
+// It ends once the request is served and its quota slot is freed up,
+// since a slot can be held after the response was sent, see -min-slot-hold.
+type requestSpan struct {
+	trace.Span
+	// pending is how many parties haven't finished with the span yet.
+	pending int32
+}
+
+// requestSpanKey is a context key of a request's span.
+type requestSpanKey struct{}
+
+// startRequestSpan starts a span of request r with the tracer and returns a copy of ctx with the span.
+// The span ends when Done was called as many times as the span was held plus one.
+func startRequestSpan(ctx context.Context, tracer trace.Tracer, r *http.Request) (context.Context, *requestSpan) {
+	ctx, span := tracer.Start(ctx, "proxy "+r.Method, trace.WithAttributes(
+		attribute.String("http.method", r.Method),
+		attribute.String("http.target", r.URL.Path),
+	))
+	s := requestSpan{Span: span, pending: 1}
+	return context.WithValue(ctx, requestSpanKey{}, &s), &s
+}
+
+// Done ends the span if nobody holds it anymore.
+func (s *requestSpan) Done() {
+	if atomic.AddInt32(&s.pending, -1) == 0 {
+		s.End()
+	}
+}
+
+// holdSpan keeps the request's span found in ctx from ending until the returned done func is called.
+func holdSpan(ctx context.Context) (done func()) {
+	s, ok := ctx.Value(requestSpanKey{}).(*requestSpan)
+	if !ok {
+		return func() {}
+	}
+	atomic.AddInt32(&s.pending, 1)
+	return s.Done
+}
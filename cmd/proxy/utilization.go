@@ -0,0 +1,173 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/marselester/capacity"
+)
+
+// UtilizationController adjusts quota to keep its utilization (in-flight requests / target concurrency)
+// near a target, e.g., 0.8 leaves 20% of headroom for bursts.
+// Target concurrency is raised when utilization is above the target and latency is good,
+// and it's lowered when utilization is below the target.
+type UtilizationController struct {
+	quota  *capacity.Quota
+	target float64
+
+	// mu guards the controller's state.
+	mu sync.Mutex
+	// kp and ki are proportional and integral gains of PI control step.
+	kp float64
+	ki float64
+	// prevError is the utilization error of the previous step,
+	// the proportional term reacts to how the error changed since then.
+	prevError float64
+	// limit is a fractional target concurrency,
+	// so that small adjustments accumulate instead of being rounded away.
+	limit float64
+	// min and max bound target concurrency the controller is allowed to set.
+	min float64
+	max float64
+	// interval is how often a control step is meant to be applied.
+	interval time.Duration
+
+	// latencyTolerance is how many times the average round-trip time can exceed the baseline
+	// for latency to be good, 0 means latency isn't checked.
+	latencyTolerance float64
+	// baseline is the lowest round-trip time observed, i.e., latency without queueing.
+	// A probe request replaces it, so it doesn't drift stale.
+	baseline time.Duration
+	// rtt is the average round-trip time of the most recent step which observed responses.
+	rtt time.Duration
+	// rttSum and rttCount accumulate round-trip times observed since the previous step.
+	rttSum   time.Duration
+	rttCount int
+	// overloaded is set when origin was overloaded since the previous step.
+	overloaded bool
+}
+
+// NewUtilizationController creates a controller that keeps quota utilization near target (0 < target <= 1)
+// while staying within [min, max] bounds.
+// Quota is raised only while the average round-trip time stays within latencyTolerance times the baseline.
+func NewUtilizationController(q *capacity.Quota, target float64, min, max int64, latencyTolerance float64) *UtilizationController {
+	c := UtilizationController{
+		quota:            q,
+		target:           target,
+		kp:               0.5,
+		ki:               0.1,
+		limit:            float64(q.Max()),
+		min:              float64(min),
+		max:              float64(max),
+		latencyTolerance: latencyTolerance,
+	}
+	return &c
+}
+
+// Observe records round-trip time of a response to judge whether latency is good.
+// Utilization itself is sampled from quota.
+func (c *UtilizationController) Observe(rtt time.Duration, overloaded bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if overloaded {
+		c.overloaded = true
+		return
+	}
+	if c.baseline == 0 || rtt < c.baseline {
+		c.baseline = rtt
+	}
+	c.rttSum += rtt
+	c.rttCount++
+}
+
+// ObserveBaseline replaces the baseline with round-trip time of a probe request.
+func (c *UtilizationController) ObserveBaseline(rtt time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.baseline = rtt
+}
+
+// Run samples quota utilization every interval and applies a control step.
+func (c *UtilizationController) Run(interval time.Duration, clock sampleClock) {
+	c.mu.Lock()
+	c.interval = interval
+	c.mu.Unlock()
+
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for now := range ticker.C {
+		c.Step(now.Sub(last))
+		last = now
+	}
+}
+
+// Step samples quota utilization and moves target concurrency by an increment
+// proportional to the change of the utilization error and to the error itself (PI control in velocity form).
+// The integral term is scaled by the elapsed time since the previous step relative to the interval,
+// so that late or dropped ticks don't change how fast the controller reacts.
+func (c *UtilizationController) Step(elapsed time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	scale := 1.0
+	if c.interval > 0 {
+		scale = float64(elapsed) / float64(c.interval)
+	}
+	utilization := float64(c.quota.Used()) / c.limit
+	e := utilization - c.target
+
+	limit := c.limit * (1 + c.kp*(e-c.prevError) + c.ki*e*scale)
+	// High utilization is a demand for more quota, but it's not granted while latency is poor,
+	// otherwise the controller would keep raising quota into a slow origin.
+	if good := c.latencyGood(); limit > c.limit && !good {
+		limit = c.limit
+	}
+	switch {
+	case limit < c.min:
+		limit = c.min
+	case limit > c.max:
+		limit = c.max
+	}
+	c.prevError = e
+	c.limit = limit
+
+	c.quota.Set(int64(limit + 0.5))
+}
+
+// latencyGood reports whether round-trip times observed since the previous step were within tolerance of the baseline
+// and origin wasn't overloaded.
+// When no responses were observed, the previous step's average is judged.
+// It resets the observations for the next step.
+func (c *UtilizationController) latencyGood() bool {
+	if c.rttCount > 0 {
+		c.rtt = c.rttSum / time.Duration(c.rttCount)
+	}
+	overloaded := c.overloaded
+	c.rttSum, c.rttCount, c.overloaded = 0, 0, false
+
+	if overloaded {
+		return false
+	}
+	if c.latencyTolerance == 0 || c.baseline == 0 {
+		return true
+	}
+	return float64(c.rtt) <= c.latencyTolerance*float64(c.baseline)
+}
+
+// State returns the controller's internal state.
+func (c *UtilizationController) State() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return map[string]interface{}{
+		"target":   c.target,
+		"limit":    c.limit,
+		"error":    c.prevError,
+		"rtt":      c.rtt.String(),
+		"baseline": c.baseline.String(),
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/marselester/capacity"
+)
+
+// offer keeps min(demand, max) requests in-flight in quota q as if clients had that many requests to send.
+func offer(t *testing.T, q *capacity.Quota, demand int64) {
+	t.Helper()
+
+	for q.Used() > 0 {
+		q.Release()
+	}
+	for i := int64(0); i < demand && q.Receive(); i++ {
+	}
+}
+
+func TestUtilizationControllerConverges(t *testing.T) {
+	q := capacity.NewQuota(10)
+	c := NewUtilizationController(q, 0.8, 1, 100, 0)
+	c.interval = time.Second
+
+	// Clients keep 40 requests in-flight, so utilization is 0.8 at 50.
+	var limits []float64
+	for i := 0; i < 500; i++ {
+		offer(t, q, 40)
+		c.Step(time.Second)
+		limits = append(limits, c.limit)
+	}
+
+	if got := limits[len(limits)-1]; math.Abs(got-50) > 1 {
+		t.Errorf("limit %.2f, want about 50", got)
+	}
+	// The limit settles rather than oscillates around the target.
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, l := range limits[len(limits)-100:] {
+		lo, hi = math.Min(lo, l), math.Max(hi, l)
+	}
+	if hi-lo > 1 {
+		t.Errorf("limit oscillates within [%.2f, %.2f] during the last 100 steps", lo, hi)
+	}
+	if u := float64(q.Used()) / float64(q.Max()); math.Abs(u-0.8) > 0.05 {
+		t.Errorf("utilization %.2f, want about 0.8", u)
+	}
+}
+
+func TestUtilizationControllerLowersLimit(t *testing.T) {
+	q := capacity.NewQuota(50)
+	c := NewUtilizationController(q, 0.8, 1, 100, 0)
+	c.interval = time.Second
+
+	// Demand dropped to 8 requests, so 10 is enough.
+	for i := 0; i < 500; i++ {
+		offer(t, q, 8)
+		c.Step(time.Second)
+	}
+	if math.Abs(c.limit-10) > 1 {
+		t.Errorf("limit %.2f, want about 10", c.limit)
+	}
+}
+
+func TestUtilizationControllerDoesntDriftOnTarget(t *testing.T) {
+	q := capacity.NewQuota(10)
+	c := NewUtilizationController(q, 0.8, 1, 100, 0)
+	c.interval = time.Second
+
+	for i := 0; i < 100; i++ {
+		offer(t, q, 8)
+		c.Step(time.Second)
+		if c.limit != 10 {
+			t.Fatalf("step %d: limit %.2f drifted while utilization was on target", i, c.limit)
+		}
+	}
+}
+
+func TestUtilizationControllerLatencyGate(t *testing.T) {
+	q := capacity.NewQuota(10)
+	c := NewUtilizationController(q, 0.8, 1, 100, 2)
+	c.interval = time.Second
+
+	// Origin got three times slower than its baseline, so quota isn't raised even though it's fully used.
+	c.Observe(10*time.Millisecond, false)
+	c.Step(time.Second)
+	baseline := c.limit
+	for i := 0; i < 20; i++ {
+		offer(t, q, 40)
+		c.Observe(30*time.Millisecond, false)
+		c.Step(time.Second)
+		if c.limit > baseline {
+			t.Fatalf("step %d: limit %.2f was raised from %.2f while latency was poor", i, c.limit, baseline)
+		}
+	}
+	// Neither it's raised when origin is overloaded.
+	for i := 0; i < 20; i++ {
+		offer(t, q, 40)
+		c.Observe(10*time.Millisecond, true)
+		c.Step(time.Second)
+		if c.limit > baseline {
+			t.Fatalf("step %d: limit %.2f was raised from %.2f while origin was overloaded", i, c.limit, baseline)
+		}
+	}
+
+	// Latency recovered.
+	for i := 0; i < 20; i++ {
+		offer(t, q, 40)
+		c.Observe(15*time.Millisecond, false)
+		c.Step(time.Second)
+	}
+	if c.limit <= baseline {
+		t.Errorf("limit %.2f wasn't raised from %.2f once latency was good", c.limit, baseline)
+	}
+}
+
+// The integral term is scaled by the time elapsed since the previous step,
+// so a late tick moves the limit as far as the ticks it stood in for.
+func TestUtilizationControllerIrregularSteps(t *testing.T) {
+	tests := map[string]struct {
+		elapsed time.Duration
+		want    float64
+	}{
+		"on time": {elapsed: time.Second, want: 11.2},
+		"late":    {elapsed: 2 * time.Second, want: 11.4},
+		"early":   {elapsed: 500 * time.Millisecond, want: 11.1},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := capacity.NewQuota(10)
+			c := NewUtilizationController(q, 0.8, 1, 100, 0)
+			c.interval = time.Second
+
+			offer(t, q, 10)
+			c.Step(tc.elapsed)
+			if math.Abs(c.limit-tc.want) > 1e-9 {
+				t.Errorf("limit %v, want %v", c.limit, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import "sync/atomic"
+
+// warmup holds off adaptive control for the first n responses,
+// so that startup transients such as cold caches don't skew the learned capacity.
+type warmup struct {
+	remaining int64
+	// done is closed once warmup is over.
+	done chan struct{}
+}
+
+// newWarmup creates a warmup which is over after n responses, so zero means there is no warmup.
+func newWarmup(n int64) *warmup {
+	w := warmup{
+		remaining: n,
+		done:      make(chan struct{}),
+	}
+	if n <= 0 {
+		close(w.done)
+	}
+	return &w
+}
+
+// Observe counts a response and reports whether warmup was over before it,
+// i.e., whether the response should be observed by a limiter.
+func (w *warmup) Observe() bool {
+	n := atomic.AddInt64(&w.remaining, -1)
+	if n == 0 {
+		close(w.done)
+	}
+	return n < 0
+}
+
+// Done returns a channel which is closed once warmup is over.
+func (w *warmup) Done() <-chan struct{} {
+	return w.done
+}
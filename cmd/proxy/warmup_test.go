@@ -0,0 +1,80 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/marselester/capacity"
+	"golang.org/x/time/rate"
+)
+
+// isDone reports whether warmup is over.
+func isDone(w *warmup) bool {
+	select {
+	case <-w.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestWarmupHoldsOffAdaptation(t *testing.T) {
+	q := capacity.NewQuota(10)
+	// The limiter increases quota on every response it observes.
+	l := &compositeLimiter{quota: q, incLimiter: rate.NewLimiter(rate.Inf, 1), incStep: 1}
+	w := newWarmup(5)
+
+	for i := 1; i <= 8; i++ {
+		if w.Observe() {
+			observeLimiters(sample{rtt: time.Millisecond}, l)
+		}
+		want := int64(10)
+		if i > 5 {
+			want += int64(i - 5)
+		}
+		if q.Max() != want {
+			t.Errorf("response %d: max %d, want %d", i, q.Max(), want)
+		}
+		if done := isDone(w); done != (i >= 5) {
+			t.Errorf("response %d: warmup done %t", i, done)
+		}
+	}
+}
+
+func TestWarmupConcurrent(t *testing.T) {
+	w := newWarmup(100)
+	var (
+		wg       sync.WaitGroup
+		observed int64
+	)
+	for g := 0; g < 10; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if w.Observe() {
+					atomic.AddInt64(&observed, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if observed != 400 {
+		t.Errorf("observed %d responses, want 400 after warmup of 100", observed)
+	}
+	if !isDone(w) {
+		t.Error("warmup isn't over")
+	}
+}
+
+func TestNoWarmup(t *testing.T) {
+	w := newWarmup(0)
+	if !isDone(w) {
+		t.Error("warmup of zero responses isn't over")
+	}
+	if !w.Observe() {
+		t.Error("response wasn't observed without warmup")
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// outcomeWindow is a ring buffer of the most recent request outcomes
+// which is used to calculate an error rate.
+type outcomeWindow struct {
+	mu       sync.Mutex
+	failures []bool
+	next     int
+	// size is how many outcomes were recorded up to the window capacity.
+	size int
+	// failed is how many failures are in the window.
+	failed int
+}
+
+// newOutcomeWindow creates a window of n most recent outcomes.
+func newOutcomeWindow(n int) *outcomeWindow {
+	w := outcomeWindow{
+		failures: make([]bool, n),
+	}
+	return &w
+}
+
+// Record adds an outcome of a request to the window evicting the oldest one
+// and returns the error rate within the window.
+func (w *outcomeWindow) Record(failed bool) float64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.size == len(w.failures) {
+		if w.failures[w.next] {
+			w.failed--
+		}
+	} else {
+		w.size++
+	}
+	w.failures[w.next] = failed
+	if failed {
+		w.failed++
+	}
+	w.next = (w.next + 1) % len(w.failures)
+
+	return float64(w.failed) / float64(w.size)
+}
+
+// Len returns how many outcomes are in the window.
+func (w *outcomeWindow) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.size
+}
+
+// Rate returns the error rate within the window.
+func (w *outcomeWindow) Rate() float64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.size == 0 {
+		return 0
+	}
+	return float64(w.failed) / float64(w.size)
+}
+
+// rttWindow is a ring buffer of the most recent round-trip times
+// which is used to smooth out latency noise with a moving average.
+type rttWindow struct {
+	mu   sync.Mutex
+	rtts []time.Duration
+	next int
+	// size is how many round-trip times were recorded up to the window capacity.
+	size int
+	// sum is a total of round-trip times in the window.
+	sum time.Duration
+}
+
+// newRTTWindow creates a window of n most recent round-trip times.
+func newRTTWindow(n int) *rttWindow {
+	w := rttWindow{
+		rtts: make([]time.Duration, n),
+	}
+	return &w
+}
+
+// Record adds a round-trip time to the window evicting the oldest one
+// and returns the average round-trip time within the window.
+func (w *rttWindow) Record(rtt time.Duration) time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.size == len(w.rtts) {
+		w.sum -= w.rtts[w.next]
+	} else {
+		w.size++
+	}
+	w.rtts[w.next] = rtt
+	w.sum += rtt
+	w.next = (w.next + 1) % len(w.rtts)
+
+	return w.sum / time.Duration(w.size)
+}
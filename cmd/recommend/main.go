@@ -0,0 +1,107 @@
+// Program recommend suggests a static proxy quota from results of a capacity test.
+//
+// Each step of a capacity test is a CSV record of concurrency (in-flight requests)
+// and goodput (successful responses per second) observed at that concurrency, e.g.,
+//
+//	1,0.98
+//	2,1.97
+//	4,3.51
+//	8,3.49
+//
+// The recommended quota is the concurrency at the goodput knee,
+// i.e., the least concurrency that achieves nearly the peak goodput.
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// step is a result of a capacity test at a fixed concurrency.
+type step struct {
+	concurrency int64
+	goodput     float64
+}
+
+func main() {
+	statsPath := flag.String("stats", "-", "CSV file with concurrency,goodput records of capacity test steps, - means stdin")
+	tolerance := flag.Float64("tolerance", 0.05, "fraction of the peak goodput that can be sacrificed for lower concurrency")
+	flag.Parse()
+
+	if *tolerance < 0 || *tolerance >= 1 {
+		log.Fatalf("recommend: tolerance must be in [0, 1): %v", *tolerance)
+	}
+
+	f := os.Stdin
+	if *statsPath != "-" {
+		var err error
+		if f, err = os.Open(*statsPath); err != nil {
+			log.Fatalf("recommend: %v", err)
+		}
+		defer f.Close()
+	}
+
+	steps, err := readSteps(f)
+	if err != nil {
+		log.Fatalf("recommend: %v", err)
+	}
+	if len(steps) == 0 {
+		log.Fatal("recommend: no capacity test steps")
+	}
+
+	s := knee(steps, *tolerance)
+	fmt.Printf("-quota=%d\n", s.concurrency)
+}
+
+// readSteps reads capacity test steps from CSV records.
+func readSteps(r io.Reader) ([]step, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 2
+
+	var steps []step
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return steps, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var s step
+		if s.concurrency, err = strconv.ParseInt(rec[0], 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid concurrency %q: %w", rec[0], err)
+		}
+		if s.goodput, err = strconv.ParseFloat(rec[1], 64); err != nil {
+			return nil, fmt.Errorf("invalid goodput %q: %w", rec[1], err)
+		}
+		steps = append(steps, s)
+	}
+}
+
+// knee returns the step with the least concurrency
+// whose goodput is within tolerance of the peak goodput, e.g., 95% of the peak when tolerance is 0.05.
+// Higher concurrency beyond that point only adds queueing latency.
+func knee(steps []step, tolerance float64) step {
+	sort.Slice(steps, func(i, j int) bool { return steps[i].concurrency < steps[j].concurrency })
+
+	var peak float64
+	for _, s := range steps {
+		if s.goodput > peak {
+			peak = s.goodput
+		}
+	}
+
+	for _, s := range steps {
+		if s.goodput >= (1-tolerance)*peak {
+			return s
+		}
+	}
+	return steps[len(steps)-1]
+}
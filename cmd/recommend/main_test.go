@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKnee(t *testing.T) {
+	// Goodput grows linearly till 16 in-flight requests, then origin saturates and goodput declines.
+	csv := `32,14.2
+1,1
+2,2
+4,4
+8,8
+12,14.5
+16,15.9
+24,15.5
+`
+	steps, err := readSteps(strings.NewReader(csv))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		tolerance float64
+		want      int64
+	}{
+		"peak":        {tolerance: 0, want: 16},
+		"5% of peak":  {tolerance: 0.05, want: 16},
+		"10% of peak": {tolerance: 0.1, want: 12},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := knee(steps, tc.tolerance); got.concurrency != tc.want {
+				t.Errorf("got -quota=%d, want %d", got.concurrency, tc.want)
+			}
+		})
+	}
+}
+
+func TestReadStepsError(t *testing.T) {
+	tests := map[string]string{
+		"missing goodput":     "1,1\n2\n",
+		"invalid concurrency": "1.5,1\n",
+		"invalid goodput":     "1,fast\n",
+	}
+	for name, csv := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := readSteps(strings.NewReader(csv)); err == nil {
+				t.Errorf("%q: expected an error", csv)
+			}
+		})
+	}
+}
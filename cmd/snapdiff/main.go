@@ -0,0 +1,105 @@
+// Program snapdiff prints what changed between two snapshots of the proxy's capacity control state,
+// e.g., taken with curl http://localhost:7001/admin/snapshot during an incident.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s old.json new.json\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	old, err := readSnapshot(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("snapdiff: %v", err)
+	}
+	new, err := readSnapshot(flag.Arg(1))
+	if err != nil {
+		log.Fatalf("snapdiff: %v", err)
+	}
+
+	for _, line := range diff(old, new) {
+		fmt.Println(line)
+	}
+}
+
+// readSnapshot reads a JSON snapshot and flattens it into a map of field paths to values,
+// e.g., backends[0].used.
+func readSnapshot(path string) (map[string]interface{}, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var v interface{}
+	if err = json.Unmarshal(b, &v); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	fields := make(map[string]interface{})
+	flatten("", v, fields)
+	return fields, nil
+}
+
+// flatten adds values of v to fields keyed by their paths prefixed with prefix.
+func flatten(prefix string, v interface{}, fields map[string]interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			if prefix != "" {
+				k = prefix + "." + k
+			}
+			flatten(k, child, fields)
+		}
+	case []interface{}:
+		for i, child := range v {
+			flatten(fmt.Sprintf("%s[%d]", prefix, i), child, fields)
+		}
+	default:
+		fields[prefix] = v
+	}
+}
+
+// diff returns lines describing added, removed, and changed fields sorted by their paths.
+func diff(old, new map[string]interface{}) []string {
+	paths := make(map[string]bool)
+	for k := range old {
+		paths[k] = true
+	}
+	for k := range new {
+		paths[k] = true
+	}
+	sorted := make([]string, 0, len(paths))
+	for k := range paths {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var lines []string
+	for _, k := range sorted {
+		o, inOld := old[k]
+		n, inNew := new[k]
+		switch {
+		case !inOld:
+			lines = append(lines, fmt.Sprintf("+ %s: %v", k, n))
+		case !inNew:
+			lines = append(lines, fmt.Sprintf("- %s: %v", k, o))
+		case o != n:
+			lines = append(lines, fmt.Sprintf("~ %s: %v -> %v", k, o, n))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, body string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(body), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	old, err := readSnapshot(write("old.json", `{
+		"used": 2, "max": 10, "draining": false,
+		"limiter": {"integral": 1.5},
+		"backends": [{"url": "http://a", "used": 1}, {"url": "http://b", "used": 1}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	new, err := readSnapshot(write("new.json", `{
+		"used": 2, "max": 5, "draining": true,
+		"limiter": {"integral": 1.5, "limit": 5},
+		"backends": [{"url": "http://a", "used": 0}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"~ backends[0].used: 1 -> 0",
+		"- backends[1].url: http://b",
+		"- backends[1].used: 1",
+		"~ draining: false -> true",
+		"+ limiter.limit: 5",
+		"~ max: 10 -> 5",
+	}
+	if got := diff(old, new); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestReadSnapshotError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broken.json")
+	if err := os.WriteFile(path, []byte(`{"used":`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readSnapshot(path); err == nil {
+		t.Error("expected an error")
+	}
+}
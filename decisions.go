@@ -0,0 +1,61 @@
+package capacity
+
+import (
+	"sync"
+	"time"
+)
+
+// Decision describes how target concurrency was changed.
+type Decision struct {
+	Time time.Time `json:"time"`
+	// Action is either inc or backoff.
+	Action string `json:"action"`
+	// Trigger is what the decision was based on, e.g., fraction=0.75 for back-off to 75%.
+	// It's suffixed with floor or ceiling when target concurrency was clamped.
+	Trigger string `json:"trigger"`
+	OldMax  int64  `json:"old_max"`
+	NewMax  int64  `json:"new_max"`
+}
+
+// DecisionLog is a ring buffer of the most recent decisions,
+// e.g., to see how a limiter behaved during an incident.
+type DecisionLog struct {
+	mu        sync.Mutex
+	decisions []Decision
+	next      int
+	// size is how many decisions were recorded up to the log capacity.
+	size int
+}
+
+// NewDecisionLog creates a log of n most recent decisions.
+func NewDecisionLog(n int) *DecisionLog {
+	l := DecisionLog{
+		decisions: make([]Decision, n),
+	}
+	return &l
+}
+
+// Record adds a decision to the log evicting the oldest one.
+func (l *DecisionLog) Record(d Decision) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.size < len(l.decisions) {
+		l.size++
+	}
+	l.decisions[l.next] = d
+	l.next = (l.next + 1) % len(l.decisions)
+}
+
+// Decisions returns recorded decisions from the oldest to the most recent.
+func (l *DecisionLog) Decisions() []Decision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	dd := make([]Decision, 0, l.size)
+	oldest := (l.next - l.size + len(l.decisions)) % len(l.decisions)
+	for i := 0; i < l.size; i++ {
+		dd = append(dd, l.decisions[(oldest+i)%len(l.decisions)])
+	}
+	return dd
+}
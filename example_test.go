@@ -0,0 +1,57 @@
+package capacity_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/marselester/capacity"
+)
+
+func ExampleQuota_Receive() {
+	q := capacity.NewQuota(1)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if !q.Receive() {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		defer q.Release()
+
+		fmt.Fprint(w, "🐈\n")
+	}
+
+	// The only slot is taken by a request which is still in-flight.
+	q.Receive()
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	fmt.Println(w.Code)
+
+	q.Release()
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	fmt.Print(w.Code, " ", w.Body)
+	// Output:
+	// 429
+	// 200 🐈
+}
+
+func ExampleQuota_Middleware() {
+	q := capacity.NewQuota(1)
+	handler := q.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "🐈\n")
+	}))
+
+	// The only slot is taken by a request which is still in-flight.
+	q.Receive()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	fmt.Println(w.Code, w.Header().Get("Retry-After"))
+
+	q.Release()
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	fmt.Print(w.Code, " ", w.Body)
+	// Output:
+	// 429 1
+	// 200 🐈
+}
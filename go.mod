@@ -4,5 +4,9 @@ go 1.16
 
 require (
 	github.com/prometheus/client_golang v1.11.1
+	go.opentelemetry.io/otel v1.7.0
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.7.0
+	go.opentelemetry.io/otel/sdk v1.7.0
+	go.opentelemetry.io/otel/trace v1.7.0
 	golang.org/x/time v0.0.0-20210608053304-ed9ce3a009e4
 )
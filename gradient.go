@@ -0,0 +1,132 @@
+package capacity
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// GradientLimiter adjusts quota by the gradient of latency:
+// a ratio of the long-window minimum round-trip time (no queueing) to the short-window minimum (current queueing).
+// The gradient is 1 when requests aren't queued at the service, so target concurrency grows by a queue allowance;
+// and it shrinks proportionally as latency rises.
+type GradientLimiter struct {
+	quota *Quota
+
+	mu sync.Mutex
+	// short and long are windows of the most recent round-trip times.
+	short *minWindow
+	long  *minWindow
+	// limit is a fractional target concurrency,
+	// so that small adjustments accumulate instead of being rounded away.
+	limit float64
+	// smoothing is how much a new limit contributes to the current one (0 < smoothing <= 1).
+	smoothing float64
+}
+
+// NewGradientLimiter creates a limiter which adjusts quota q
+// based on the minimum round-trip times of shortWindow and longWindow most recent samples.
+// Target concurrency stays within the quota's floor and ceiling.
+func NewGradientLimiter(q *Quota, shortWindow, longWindow int) *GradientLimiter {
+	l := GradientLimiter{
+		quota:     q,
+		short:     newMinWindow(shortWindow),
+		long:      newMinWindow(longWindow),
+		limit:     float64(q.Max()),
+		smoothing: 0.2,
+	}
+	return &l
+}
+
+// Sample records a round-trip time of a request and updates quota.
+// A dropped request, e.g., rejected by an overloaded service, backs off the limit
+// since its round-trip time doesn't reflect queueing.
+func (l *GradientLimiter) Sample(rtt time.Duration, dropped bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var limit float64
+	if dropped {
+		limit = l.limit * 0.9
+	} else {
+		shortRTT := l.short.Record(rtt)
+		longRTT := l.long.Record(rtt)
+
+		gradient := 1.0
+		if shortRTT > 0 {
+			gradient = math.Max(0.5, math.Min(1, float64(longRTT)/float64(shortRTT)))
+		}
+		// The queue allowance lets the limit grow while there is no queueing,
+		// and it keeps the limit from collapsing when latency is noisy.
+		queue := math.Sqrt(l.limit)
+		limit = l.limit*gradient + queue
+		// There is no reason to grow the limit when quota isn't even half used,
+		// it wouldn't be probed anyway.
+		if limit > l.limit && float64(l.quota.Used()) < l.limit/2 {
+			return
+		}
+		limit = l.limit*(1-l.smoothing) + limit*l.smoothing
+	}
+
+	if floor := math.Max(1, float64(l.quota.floor)); limit < floor {
+		limit = floor
+	}
+	if ceiling := float64(l.quota.ceiling); ceiling > 0 && limit > ceiling {
+		limit = ceiling
+	}
+	l.limit = limit
+
+	l.quota.Set(int64(limit + 0.5))
+}
+
+// SampleBaseline records a round-trip time of a probe request sent while the service wasn't busy.
+// It only affects the long-window minimum, so the baseline doesn't drift stale when requests keep queueing.
+func (l *GradientLimiter) SampleBaseline(rtt time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.long.Record(rtt)
+}
+
+// Limit returns the most recent fractional limit.
+func (l *GradientLimiter) Limit() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.limit
+}
+
+// minWindow is a ring buffer of the most recent round-trip times
+// which is used to find the minimum within the window.
+type minWindow struct {
+	rtts []time.Duration
+	next int
+	// size is how many round-trip times were recorded up to the window capacity.
+	size int
+}
+
+// newMinWindow creates a window of n most recent round-trip times.
+func newMinWindow(n int) *minWindow {
+	w := minWindow{
+		rtts: make([]time.Duration, n),
+	}
+	return &w
+}
+
+// Record adds a round-trip time to the window evicting the oldest one
+// and returns the minimum round-trip time within the window.
+func (w *minWindow) Record(rtt time.Duration) time.Duration {
+	if w.size < len(w.rtts) {
+		w.size++
+	}
+	w.rtts[w.next] = rtt
+	w.next = (w.next + 1) % len(w.rtts)
+
+	min := w.rtts[0]
+	for _, d := range w.rtts[1:w.size] {
+		if d < min {
+			min = d
+		}
+	}
+	return min
+}
@@ -0,0 +1,137 @@
+package capacity
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestGradientLimiterSampleBaseline(t *testing.T) {
+	tests := map[string]struct {
+		baseline time.Duration
+		grows    bool
+	}{
+		// Requests have been queueing for so long that their latency looks like no queueing.
+		"stale baseline": {grows: true},
+		// A probe shows that the service responds twice as fast when it's not busy.
+		"probed baseline": {baseline: 50 * time.Millisecond},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := NewQuota(20)
+			for i := 0; i < 20; i++ {
+				q.Receive()
+			}
+			l := NewGradientLimiter(q, 5, 100)
+			for i := 0; i < 10; i++ {
+				l.Sample(100*time.Millisecond, false)
+			}
+			before := l.Limit()
+
+			if tc.baseline > 0 {
+				l.SampleBaseline(tc.baseline)
+			}
+			for i := 0; i < 10; i++ {
+				l.Sample(100*time.Millisecond, false)
+			}
+			if grew := l.Limit() > before; grew != tc.grows {
+				t.Errorf("limit %.2f from %.2f, want growth %t", l.Limit(), before, tc.grows)
+			}
+		})
+	}
+}
+
+// volatility is a total distance the limit travelled while it was sampled with the round-trip times.
+func volatility(l *GradientLimiter, rtts []time.Duration) float64 {
+	var v float64
+	prev := l.Limit()
+	for _, rtt := range rtts {
+		l.Sample(rtt, false)
+		v += math.Abs(l.Limit() - prev)
+		prev = l.Limit()
+	}
+	return v
+}
+
+func TestGradientLimiterWindows(t *testing.T) {
+	// Every fifth request or so is delayed by noise rather than queueing.
+	rnd := rand.New(rand.NewSource(1))
+	rtts := make([]time.Duration, 500)
+	for i := range rtts {
+		rtts[i] = 10 * time.Millisecond
+		if rnd.Intn(5) == 0 {
+			rtts[i] = 30 * time.Millisecond
+		}
+	}
+
+	trajectory := func(shortWindow, longWindow int) float64 {
+		q := NewQuota(50, WithCeiling(1000))
+		for q.Receive() {
+		}
+		return volatility(NewGradientLimiter(q, shortWindow, longWindow), rtts)
+	}
+	twitchy := trajectory(1, 100)
+	smooth := trajectory(10, 100)
+	if smooth >= twitchy/2 {
+		t.Errorf("short window of 10 travelled %.2f, want well below %.2f of short window of 1", smooth, twitchy)
+	}
+}
+
+func TestMinWindow(t *testing.T) {
+	w := newMinWindow(3)
+	tests := []struct {
+		rtt  time.Duration
+		want time.Duration
+	}{
+		{rtt: 30, want: 30},
+		{rtt: 10, want: 10},
+		{rtt: 20, want: 10},
+		{rtt: 40, want: 10},
+		// 10 is evicted from the window.
+		{rtt: 50, want: 20},
+		{rtt: 60, want: 40},
+	}
+	for i, tc := range tests {
+		if got := w.Record(tc.rtt); got != tc.want {
+			t.Errorf("record %d: got %v, want %v", i, got, tc.want)
+		}
+	}
+}
+
+func TestGradientLimiterRisingLatency(t *testing.T) {
+	q := NewQuota(50, WithFloor(5))
+	for q.Receive() {
+	}
+	l := NewGradientLimiter(q, 10, 100)
+	for i := 0; i < 50; i++ {
+		l.Sample(10*time.Millisecond, false)
+	}
+	peak := l.Limit()
+	if peak <= 50 {
+		t.Fatalf("limit %.2f, want it to grow above 50 while there is no queueing", peak)
+	}
+
+	// Latency keeps rising as requests queue at the service.
+	prev := peak
+	for step := 1; step <= 3; step++ {
+		for i := 0; i < 20; i++ {
+			l.Sample(time.Duration(10+step*10)*time.Millisecond, false)
+		}
+		if l.Limit() >= prev {
+			t.Errorf("step %d: limit %.2f, want below %.2f", step, l.Limit(), prev)
+		}
+		prev = l.Limit()
+	}
+	if q.Max() != int64(l.Limit()+0.5) {
+		t.Errorf("max %d, want the rounded limit %.2f", q.Max(), l.Limit())
+	}
+
+	// Dropped requests back off the limit, but not below the floor.
+	for i := 0; i < 100; i++ {
+		l.Sample(0, true)
+	}
+	if q.Max() != 5 {
+		t.Errorf("max %d, want the floor 5", q.Max())
+	}
+}
@@ -0,0 +1,100 @@
+// Package derive computes metrics from other metrics in-process, e.g., rates of counters,
+// so that a dashboard doesn't need PromQL recording rules.
+package derive
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Series is a value of a metric with particular labels.
+type Series struct {
+	Labels map[string]string
+	// Value is a counter or gauge value, or a sample count of a histogram.
+	Value float64
+	// Sum is a sum of histogram samples.
+	Sum float64
+}
+
+// Totals are series of gathered metrics keyed by metric name.
+type Totals map[string][]Series
+
+// Match selects series by their labels.
+type Match func(labels map[string]string) bool
+
+// Gather gathers the current values of metrics from g.
+func Gather(g prometheus.Gatherer) (Totals, error) {
+	mfs, err := g.Gather()
+	if err != nil {
+		return nil, err
+	}
+	t := make(Totals, len(mfs))
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			s := Series{Labels: make(map[string]string, len(m.GetLabel()))}
+			for _, l := range m.GetLabel() {
+				s.Labels[l.GetName()] = l.GetValue()
+			}
+			switch {
+			case m.Counter != nil:
+				s.Value = m.GetCounter().GetValue()
+			case m.Gauge != nil:
+				s.Value = m.GetGauge().GetValue()
+			case m.Histogram != nil:
+				s.Value = float64(m.GetHistogram().GetSampleCount())
+				s.Sum = m.GetHistogram().GetSampleSum()
+			}
+			t[mf.GetName()] = append(t[mf.GetName()], s)
+		}
+	}
+	return t, nil
+}
+
+// Sum adds up values and sums of the metric's series whose labels satisfy match, nil match selects all series.
+func (t Totals) Sum(name string, match Match) (value, sum float64) {
+	for _, s := range t[name] {
+		if match == nil || match(s.Labels) {
+			value += s.Value
+			sum += s.Sum
+		}
+	}
+	return value, sum
+}
+
+// Delta returns how much value and sum of the metric's series changed from prev to cur totals.
+func Delta(prev, cur Totals, name string, match Match) (value, sum float64) {
+	pv, ps := prev.Sum(name, match)
+	cv, cs := cur.Sum(name, match)
+	return cv - pv, cs - ps
+}
+
+// Ratio returns a/b, or zero when b is zero, e.g., there were no requests.
+func Ratio(a, b float64) float64 {
+	if b == 0 {
+		return 0
+	}
+	return a / b
+}
+
+// Run gathers metrics from g on every tick and passes them to update along with the previously gathered ones,
+// so that rates of counters are computed in-process.
+// Elapsed time is measured between the ticks rather than assumed, since ticks can be dropped or delayed.
+// Failures to gather metrics are passed to onError, and the tick is skipped.
+func Run(ticks <-chan time.Time, g prometheus.Gatherer, update func(prev, cur Totals, elapsed time.Duration), onError func(error)) {
+	prev, err := Gather(g)
+	if err != nil {
+		onError(err)
+	}
+	last := time.Now()
+
+	for now := range ticks {
+		cur, err := Gather(g)
+		if err != nil {
+			onError(err)
+			continue
+		}
+		update(prev, cur, now.Sub(last))
+		prev, last = cur, now
+	}
+}
@@ -0,0 +1,107 @@
+package derive
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestDelta(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests_total"}, []string{"status"})
+	duration := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "request_duration_seconds"})
+	reg.MustRegister(requests, duration)
+
+	requests.WithLabelValues("200").Add(5)
+	duration.Observe(1)
+	prev, err := Gather(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests.WithLabelValues("200").Add(3)
+	requests.WithLabelValues("429").Add(1)
+	duration.Observe(2)
+	duration.Observe(4)
+	cur, err := Gather(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		name      string
+		match     Match
+		wantValue float64
+		wantSum   float64
+	}{
+		"all statuses": {name: "requests_total", wantValue: 4},
+		"429": {
+			name: "requests_total",
+			match: func(labels map[string]string) bool {
+				return labels["status"] == "429"
+			},
+			wantValue: 1,
+		},
+		"histogram":      {name: "request_duration_seconds", wantValue: 2, wantSum: 6},
+		"missing metric": {name: "errors_total"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			value, sum := Delta(prev, cur, tc.name, tc.match)
+			if value != tc.wantValue || sum != tc.wantSum {
+				t.Errorf("got value %v sum %v, want %v %v", value, sum, tc.wantValue, tc.wantSum)
+			}
+		})
+	}
+}
+
+func TestRatio(t *testing.T) {
+	if got := Ratio(1, 4); got != 0.25 {
+		t.Errorf("got %v, want 0.25", got)
+	}
+	if got := Ratio(1, 0); got != 0 {
+		t.Errorf("got %v, want 0 when there is nothing to divide by", got)
+	}
+}
+
+// Rates are computed over the time which actually elapsed between ticks, even when the ticks are irregular.
+func TestRun(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	requests := prometheus.NewCounter(prometheus.CounterOpts{Name: "requests_total"})
+	reg.MustRegister(requests)
+
+	ticks := make(chan time.Time)
+	rates := make(chan float64)
+	go Run(ticks, reg, func(prev, cur Totals, elapsed time.Duration) {
+		n, _ := Delta(prev, cur, "requests_total", nil)
+		rates <- Ratio(n, elapsed.Seconds())
+	}, func(err error) {
+		t.Error(err)
+	})
+
+	// The first tick makes sure Run gathered the initial totals,
+	// since it measures elapsed time from when it started.
+	now := time.Now()
+	ticks <- now
+	<-rates
+
+	tests := []struct {
+		interval time.Duration
+		requests float64
+		wantRate float64
+	}{
+		{interval: time.Second, requests: 10, wantRate: 10},
+		{interval: 3 * time.Second, requests: 30, wantRate: 10},
+		{interval: 500 * time.Millisecond, requests: 20, wantRate: 40},
+	}
+	for i, tc := range tests {
+		now = now.Add(tc.interval)
+		requests.Add(tc.requests)
+		ticks <- now
+		if got := <-rates; math.Abs(got-tc.wantRate) > 0.1 {
+			t.Errorf("tick %d: rate %.2f, want %.2f", i, got, tc.wantRate)
+		}
+	}
+	close(ticks)
+}
@@ -0,0 +1,148 @@
+// Package stress hammers quota with concurrent goroutines to check whether its invariants hold,
+// e.g., in a race-detector test or in the proxy's -stress mode.
+package stress
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/marselester/capacity"
+)
+
+// Config describes how quota is hammered by Run.
+type Config struct {
+	// Goroutines is how many goroutines use quota concurrently.
+	Goroutines int
+	// Iterations is how many requests every goroutine makes.
+	Iterations int
+	// Inc lifts max concurrently as if an adaptive limiter was adjusting it.
+	Inc bool
+	// Backoff lowers max concurrently as if an adaptive limiter was adjusting it.
+	Backoff bool
+	// Strict tells that quota was created with capacity.WithStrict,
+	// so used must never exceed max.
+	Strict bool
+}
+
+// Report summarizes how quota behaved when it was hammered by concurrent goroutines.
+type Report struct {
+	Strict   bool
+	Admitted int64
+	Rejected int64
+	// Overshoots is how many times used exceeded max.
+	// They aren't counted when max is backed off,
+	// since a back-off leaves used above the lowered max until in-flight requests are released.
+	Overshoots int64
+	// MaxUsed is the highest used observed.
+	MaxUsed int64
+	// Negatives is how many times used was observed below zero.
+	Negatives int64
+	// Leftover is used after all goroutines released quota, it must be zero.
+	Leftover int64
+}
+
+// String returns the report in a human-readable form.
+func (r Report) String() string {
+	return fmt.Sprintf("strict=%t admitted=%d rejected=%d overshoots=%d max_used=%d negatives=%d leftover=%d",
+		r.Strict, r.Admitted, r.Rejected, r.Overshoots, r.MaxUsed, r.Negatives, r.Leftover)
+}
+
+// Err returns an error if quota's invariants were violated:
+// used must never be negative and it must return to zero once all requests are released.
+// Strict quota must also never let used exceed max.
+func (r Report) Err() error {
+	if r.Strict && r.Overshoots > 0 {
+		return fmt.Errorf("used exceeded max %d times", r.Overshoots)
+	}
+	if r.Negatives > 0 {
+		return fmt.Errorf("used was negative %d times", r.Negatives)
+	}
+	if r.Leftover != 0 {
+		return fmt.Errorf("used is %d after all requests were released", r.Leftover)
+	}
+	return nil
+}
+
+// Run calls Receive (or ReceiveWait in every other goroutine) and Release on quota q from many goroutines,
+// and it changes max with Inc and Backoff concurrently if the config says so.
+// Run it with the race detector, e.g., go test -race or go run -race ./cmd/proxy -stress.
+func Run(q *capacity.Quota, c Config) Report {
+	var (
+		r    = Report{Strict: c.Strict}
+		wg   sync.WaitGroup
+		done = make(chan struct{})
+	)
+	if c.Inc || c.Backoff {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if c.Backoff && (!c.Inc || rand.Intn(4) == 0) {
+					q.Backoff(0.75)
+				} else {
+					q.Inc()
+				}
+				runtime.Gosched()
+			}
+		}()
+	}
+
+	var workers sync.WaitGroup
+	for g := 0; g < c.Goroutines; g++ {
+		workers.Add(1)
+		go func(wait bool) {
+			defer workers.Done()
+
+			receive := q.Receive
+			if wait {
+				receive = func() bool {
+					ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+					defer cancel()
+					return q.ReceiveWait(ctx) == nil
+				}
+			}
+			for i := 0; i < c.Iterations; i++ {
+				if !receive() {
+					atomic.AddInt64(&r.Rejected, 1)
+					continue
+				}
+				atomic.AddInt64(&r.Admitted, 1)
+
+				// Max is loaded after used, so it can only have grown in between when it isn't backed off.
+				used := q.Used()
+				if !c.Backoff && used > q.Max() {
+					atomic.AddInt64(&r.Overshoots, 1)
+				}
+				for {
+					maxUsed := atomic.LoadInt64(&r.MaxUsed)
+					if used <= maxUsed || atomic.CompareAndSwapInt64(&r.MaxUsed, maxUsed, used) {
+						break
+					}
+				}
+				runtime.Gosched()
+
+				q.Release()
+				if q.Used() < 0 {
+					atomic.AddInt64(&r.Negatives, 1)
+				}
+			}
+		}(g%2 == 1)
+	}
+	workers.Wait()
+	close(done)
+	wg.Wait()
+
+	r.Leftover = q.Used()
+	return r
+}
@@ -0,0 +1,53 @@
+package stress
+
+import (
+	"testing"
+
+	"github.com/marselester/capacity"
+)
+
+func TestRun(t *testing.T) {
+	tests := map[string]Config{
+		"fixed":       {Goroutines: 50, Iterations: 1000},
+		"inc":         {Goroutines: 50, Iterations: 1000, Inc: true},
+		"backoff":     {Goroutines: 50, Iterations: 1000, Backoff: true},
+		"inc,backoff": {Goroutines: 50, Iterations: 1000, Inc: true, Backoff: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := capacity.NewQuota(10, capacity.WithFloor(1), capacity.WithCeiling(20))
+			r := Run(q, tc)
+			if err := r.Err(); err != nil {
+				t.Fatalf("%v: %s", err, r)
+			}
+			if r.Admitted+r.Rejected != int64(tc.Goroutines*tc.Iterations) {
+				t.Errorf("admitted %d + rejected %d, want %d requests", r.Admitted, r.Rejected, tc.Goroutines*tc.Iterations)
+			}
+			if r.Admitted == 0 {
+				t.Errorf("no requests were admitted: %s", r)
+			}
+			if q.Used() != 0 {
+				t.Errorf("used %d, want 0", q.Used())
+			}
+		})
+	}
+}
+
+func TestRunStrict(t *testing.T) {
+	tests := map[string]Config{
+		"fixed": {Goroutines: 100, Iterations: 1000, Strict: true},
+		"inc":   {Goroutines: 100, Iterations: 1000, Inc: true, Strict: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := capacity.NewQuota(5, capacity.WithStrict(), capacity.WithCeiling(10))
+			r := Run(q, tc)
+			if err := r.Err(); err != nil {
+				t.Fatalf("%v: %s", err, r)
+			}
+			if r.MaxUsed > 10 {
+				t.Errorf("max used %d, want at most 10", r.MaxUsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package capacity
+
+import "net/http"
+
+// Middleware limits how many requests next handler serves concurrently.
+// Requests over quota are rejected with 429 Too Many Requests and Retry-After header.
+// Quota is released once next returns, even if it panicked.
+func (q *Quota) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if !q.Receive() {
+			rw.Header().Set("Retry-After", "1")
+			http.Error(rw, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		defer q.Release()
+
+		next.ServeHTTP(rw, r)
+	})
+}
@@ -0,0 +1,85 @@
+package capacity
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestMiddlewareOverflow(t *testing.T) {
+	const quota, requests = 5, 20
+	// Admitted requests are held until all the requests got a response or were admitted.
+	admitted := make(chan struct{}, requests)
+	hold := make(chan struct{})
+	// Lenient quota can briefly admit more than max concurrent requests, so the overflow wouldn't be exact.
+	q := NewQuota(quota, WithStrict())
+	srv := httptest.NewServer(q.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		admitted <- struct{}{}
+		<-hold
+	})))
+	defer srv.Close()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		statuses = make(map[int]int)
+		rejected = make(chan struct{}, requests)
+	)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			resp, err := http.Get(srv.URL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusTooManyRequests {
+				if resp.Header.Get("Retry-After") == "" {
+					t.Error("429 without Retry-After header")
+				}
+				rejected <- struct{}{}
+			}
+			mu.Lock()
+			statuses[resp.StatusCode]++
+			mu.Unlock()
+		}()
+	}
+	for i := 0; i < quota; i++ {
+		<-admitted
+	}
+	for i := 0; i < requests-quota; i++ {
+		<-rejected
+	}
+	close(hold)
+	wg.Wait()
+
+	if statuses[http.StatusOK] != quota || statuses[http.StatusTooManyRequests] != requests-quota {
+		t.Errorf("got %v, want %d 200s and %d 429s", statuses, quota, requests-quota)
+	}
+	if q.Used() != 0 {
+		t.Errorf("used %d, want 0", q.Used())
+	}
+}
+
+func TestMiddlewareReleasesOnPanic(t *testing.T) {
+	q := NewQuota(1)
+	handler := q.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("handler didn't panic")
+			}
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}()
+	if q.Used() != 0 {
+		t.Errorf("used %d, want 0 after the handler panicked", q.Used())
+	}
+}
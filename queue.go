@@ -0,0 +1,72 @@
+package capacity
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrQueueFull is returned by QueuedQuota.Receive when no more requests can wait for quota.
+var ErrQueueFull = errors.New("quota queue is full")
+
+// QueuedQuota lets a limited number of requests wait for quota instead of being rejected right away,
+// so that bursts are smoothed out.
+type QueuedQuota struct {
+	*Quota
+	// size is how many requests can wait for quota at once.
+	size int32
+	// maxWait is how long a request can wait for quota.
+	maxWait time.Duration
+	// queued is how many requests wait for quota.
+	queued int32
+	// depth is a gauge of requests waiting for quota.
+	depth Gauge
+}
+
+// NewQueuedQuota creates a queue of up to size requests which wait for quota q no longer than maxWait.
+// The queue depth is reported to gauge depth unless it's nil.
+func NewQueuedQuota(q *Quota, size int, maxWait time.Duration, depth Gauge) *QueuedQuota {
+	if depth == nil {
+		depth = nopGauge{}
+	}
+	qq := QueuedQuota{
+		Quota:   q,
+		size:    int32(size),
+		maxWait: maxWait,
+		depth:   depth,
+	}
+	return &qq
+}
+
+// Receive fills quota by one, waiting in the queue if quota isn't available.
+// It returns ErrQueueFull if the queue is full,
+// context.DeadlineExceeded if quota didn't become available within the max wait,
+// ctx.Err() if ctx is done, or ErrDraining if quota stopped admitting requests.
+func (qq *QueuedQuota) Receive(ctx context.Context) error {
+	if qq.Quota.Receive() {
+		return nil
+	}
+	if qq.Draining() {
+		return ErrDraining
+	}
+
+	if atomic.AddInt32(&qq.queued, 1) > qq.size {
+		atomic.AddInt32(&qq.queued, -1)
+		return ErrQueueFull
+	}
+	qq.depth.Inc()
+	defer func() {
+		atomic.AddInt32(&qq.queued, -1)
+		qq.depth.Dec()
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, qq.maxWait)
+	defer cancel()
+	return qq.ReceiveWait(ctx)
+}
+
+// Queued returns how many requests wait for quota.
+func (qq *QueuedQuota) Queued() int {
+	return int(atomic.LoadInt32(&qq.queued))
+}
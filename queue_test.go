@@ -0,0 +1,89 @@
+package capacity
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// depthGauge counts how many requests were added and not yet removed.
+type depthGauge struct {
+	value int64
+}
+
+func (g *depthGauge) Set(v float64) { atomic.StoreInt64(&g.value, int64(v)) }
+func (g *depthGauge) Inc()          { atomic.AddInt64(&g.value, 1) }
+func (g *depthGauge) Dec()          { atomic.AddInt64(&g.value, -1) }
+
+func TestQueuedQuotaFull(t *testing.T) {
+	var depth depthGauge
+	qq := NewQueuedQuota(NewQuota(1), 2, time.Minute, &depth)
+	if err := qq.Receive(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	waited := make(chan error)
+	for i := 0; i < 2; i++ {
+		go func() {
+			waited <- qq.Receive(context.Background())
+		}()
+	}
+	waitingFor(t, qq.Quota, 2)
+	if qq.Queued() != 2 || atomic.LoadInt64(&depth.value) != 2 {
+		t.Fatalf("queued %d, depth gauge %d, want 2", qq.Queued(), atomic.LoadInt64(&depth.value))
+	}
+
+	// The queue is full, so the request is rejected right away.
+	start := time.Now()
+	if err := qq.Receive(context.Background()); err != ErrQueueFull {
+		t.Errorf("got %v, want %v", err, ErrQueueFull)
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("rejection took %v", d)
+	}
+
+	// Queued requests are admitted one by one as quota is released.
+	for i := 0; i < 2; i++ {
+		qq.Release()
+		if err := <-waited; err != nil {
+			t.Errorf("got %v, want admitted request", err)
+		}
+	}
+	qq.Release()
+	if qq.Queued() != 0 || atomic.LoadInt64(&depth.value) != 0 {
+		t.Errorf("queued %d, depth gauge %d, want 0", qq.Queued(), atomic.LoadInt64(&depth.value))
+	}
+	if qq.Used() != 0 {
+		t.Errorf("used %d, want 0", qq.Used())
+	}
+}
+
+func TestQueuedQuotaTimeout(t *testing.T) {
+	var depth depthGauge
+	qq := NewQueuedQuota(NewQuota(1), 2, 50*time.Millisecond, &depth)
+	qq.Receive(context.Background())
+
+	start := time.Now()
+	if err := qq.Receive(context.Background()); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("request waited %v, want at least the max wait", d)
+	}
+	if qq.Queued() != 0 || atomic.LoadInt64(&depth.value) != 0 {
+		t.Errorf("queued %d, depth gauge %d, want 0 once the wait expired", qq.Queued(), atomic.LoadInt64(&depth.value))
+	}
+	if qq.Used() != 1 {
+		t.Errorf("used %d, want 1", qq.Used())
+	}
+}
+
+func TestQueuedQuotaDraining(t *testing.T) {
+	qq := NewQueuedQuota(NewQuota(1), 2, time.Minute, nil)
+	qq.Receive(context.Background())
+	qq.Drain()
+	if err := qq.Receive(context.Background()); err != ErrDraining {
+		t.Errorf("got %v, want %v", err, ErrDraining)
+	}
+}
@@ -0,0 +1,346 @@
+// Package capacity limits how many requests are allowed to be in-flight to a service.
+// The limit (target concurrency) can be adjusted at runtime by capacity control algorithms,
+// e.g., it's backed off when the service is overloaded.
+//
+// A quota is filled when a request is received and freed up once the request is done:
+//
+//	q := capacity.NewQuota(10)
+//	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+//		if !q.Receive() {
+//			w.WriteHeader(http.StatusTooManyRequests)
+//			return
+//		}
+//		defer q.Release()
+//
+//		fmt.Fprint(w, "🐈\n")
+//	})
+//
+// Middleware does the same for any http.Handler:
+//
+//	http.Handle("/", q.Middleware(handler))
+package capacity
+
+import (
+	"container/list"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Quota is a limited quantity of requests allowed to be in-flight.
+type Quota struct {
+	used int64
+	max  int64
+	// draining is set to 1 when quota no longer admits requests.
+	draining int32
+	// saturated is set to 1 when a request wasn't admitted because quota ran out, Inc resets it.
+	saturated int32
+	// strict guarantees that used never exceeds max because of concurrent Receive calls.
+	strict bool
+	// floor is the least target concurrency Backoff can set, 0 means no floor.
+	floor int64
+	// ceiling is the most target concurrency Inc can set, 0 means no ceiling.
+	ceiling int64
+
+	// current is a gauge of in-flight requests.
+	current Gauge
+	// target is a gauge of target concurrency.
+	target Gauge
+	// backoffs observes ratios of target concurrency after and before every back-off.
+	backoffs Observer
+	// holdUntil is Unix time in nanoseconds until which Inc doesn't lift quota.
+	holdUntil int64
+	// postBackoffHold is how long Inc doesn't lift quota after a back-off.
+	postBackoffHold time.Duration
+	// decisions records Inc and Backoff decisions when it's not nil.
+	decisions *DecisionLog
+
+	// waiting is how many ReceiveWait callers wait for quota, so that Release doesn't lock when there are none.
+	waiting int32
+	// mu guards the waiters.
+	mu sync.Mutex
+	// waiters is a FIFO list of channels of ReceiveWait callers.
+	waiters list.List
+}
+
+// Gauge is a metric that can go up and down, e.g., prometheus.Gauge.
+type Gauge interface {
+	Set(float64)
+	Inc()
+	Dec()
+}
+
+// Observer is a metric that observes values, e.g., prometheus.Histogram.
+type Observer interface {
+	Observe(float64)
+}
+
+// nopObserver is used when a quota isn't instrumented.
+type nopObserver struct{}
+
+func (nopObserver) Observe(float64) {}
+
+// nopGauge is used when a quota isn't instrumented.
+type nopGauge struct{}
+
+func (nopGauge) Set(float64) {}
+func (nopGauge) Inc()        {}
+func (nopGauge) Dec()        {}
+
+// Option configures a quota.
+type Option func(*Quota)
+
+// WithCurrentGauge reports how many requests are in-flight to gauge g.
+func WithCurrentGauge(g Gauge) Option {
+	return func(q *Quota) {
+		q.current = g
+	}
+}
+
+// WithTargetGauge reports target concurrency to gauge g.
+func WithTargetGauge(g Gauge) Option {
+	return func(q *Quota) {
+		q.target = g
+	}
+}
+
+// WithBackoffObserver reports a ratio of new and old target concurrency on every back-off to observer o,
+// e.g., 0.75 when quota is backed off from 100 to 75.
+func WithBackoffObserver(o Observer) Option {
+	return func(q *Quota) {
+		q.backoffs = o
+	}
+}
+
+// WithDecisionLog records every Inc and Backoff decision to log l.
+func WithDecisionLog(l *DecisionLog) Option {
+	return func(q *Quota) {
+		q.decisions = l
+	}
+}
+
+// WithFloor prevents Backoff from lowering target concurrency below n,
+// so that capacity doesn't collapse after a burst of errors.
+func WithFloor(n int64) Option {
+	return func(q *Quota) {
+		q.floor = n
+	}
+}
+
+// WithCeiling prevents Inc from lifting target concurrency above n,
+// so that a long healthy period doesn't let quota grow unbounded.
+func WithCeiling(n int64) Option {
+	return func(q *Quota) {
+		q.ceiling = n
+	}
+}
+
+// WithPostBackoffHold prevents Inc from lifting quota for duration d after every back-off,
+// so that the back-off has time to take effect before quota grows again.
+func WithPostBackoffHold(d time.Duration) Option {
+	return func(q *Quota) {
+		q.postBackoffHold = d
+	}
+}
+
+// WithStrict guarantees that quota never admits more than max requests.
+// By default concurrent Receive calls can briefly exceed max in exchange for cheaper admission.
+func WithStrict() Option {
+	return func(q *Quota) {
+		q.strict = true
+	}
+}
+
+// NewQuota creates a quota of n in-flight requests.
+func NewQuota(n int64, options ...Option) *Quota {
+	q := Quota{
+		max:      n,
+		current:  nopGauge{},
+		target:   nopGauge{},
+		backoffs: nopObserver{},
+	}
+	for _, opt := range options {
+		opt(&q)
+	}
+	q.target.Set(float64(n))
+	return &q
+}
+
+// Receive fills quota by one and returns true if quota is available.
+func (q *Quota) Receive() bool {
+	if atomic.LoadInt32(&q.draining) == 1 {
+		return false
+	}
+	if q.strict {
+		return q.receiveStrict()
+	}
+
+	used := atomic.LoadInt64(&q.used)
+	max := atomic.LoadInt64(&q.max)
+	available := used < max
+	// If quota became available here, it's still ok to reject the request.
+	if !available {
+		atomic.StoreInt32(&q.saturated, 1)
+		return false
+	}
+
+	atomic.AddInt64(&q.used, 1)
+	q.current.Inc()
+
+	// If quota became unavailable here, it's still ok to process the request.
+	return true
+}
+
+// receiveStrict fills quota by one only if used is still below max at the moment of increment.
+func (q *Quota) receiveStrict() bool {
+	for {
+		used := atomic.LoadInt64(&q.used)
+		if used >= atomic.LoadInt64(&q.max) {
+			atomic.StoreInt32(&q.saturated, 1)
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&q.used, used, used+1) {
+			q.current.Inc()
+			return true
+		}
+	}
+}
+
+// Release frees up quota by one and wakes up a single ReceiveWait caller.
+func (q *Quota) Release() {
+	atomic.AddInt64(&q.used, -1)
+
+	q.current.Dec()
+	q.wakeOne()
+}
+
+// Used returns how many requests are in-flight.
+func (q *Quota) Used() int64 {
+	return atomic.LoadInt64(&q.used)
+}
+
+// Max returns target concurrency.
+func (q *Quota) Max() int64 {
+	return atomic.LoadInt64(&q.max)
+}
+
+// Set sets target concurrency to n.
+func (q *Quota) Set(n int64) {
+	atomic.StoreInt64(&q.max, n)
+
+	q.target.Set(float64(n))
+	q.wakeAll()
+}
+
+// Draining reports whether quota no longer admits requests.
+func (q *Quota) Draining() bool {
+	return atomic.LoadInt32(&q.draining) == 1
+}
+
+// Drain stops admitting new requests while in-flight ones are allowed to finish.
+func (q *Quota) Drain() {
+	atomic.StoreInt32(&q.draining, 1)
+
+	q.target.Set(0)
+	q.wakeAll()
+}
+
+// HoldUntil prevents Inc from lifting quota until time t, e.g., when a service asked to retry later.
+// An earlier hold is extended, but never shortened.
+func (q *Quota) HoldUntil(t time.Time) {
+	until := t.UnixNano()
+	for {
+		old := atomic.LoadInt64(&q.holdUntil)
+		if until <= old || atomic.CompareAndSwapInt64(&q.holdUntil, old, until) {
+			return
+		}
+	}
+}
+
+// Saturated reports whether a request wasn't admitted because quota ran out since quota was last increased,
+// i.e., whether there is a demand for more quota.
+func (q *Quota) Saturated() bool {
+	return atomic.LoadInt32(&q.saturated) == 1
+}
+
+// Inc lifts quota by one unless it reached the ceiling or it's on hold.
+func (q *Quota) Inc() {
+	q.IncN(1)
+}
+
+// IncN lifts quota by n (n > 0) unless it's on hold, quota doesn't go above the ceiling.
+func (q *Quota) IncN(n int64) {
+	if time.Now().UnixNano() < atomic.LoadInt64(&q.holdUntil) {
+		return
+	}
+	if q.ceiling == 0 {
+		newMax := atomic.AddInt64(&q.max, n)
+		atomic.StoreInt32(&q.saturated, 0)
+		q.target.Set(float64(newMax))
+		q.wakeN(n)
+		q.decide("inc", "step="+strconv.FormatInt(n, 10), newMax-n, newMax)
+		return
+	}
+
+	for {
+		oldMax := atomic.LoadInt64(&q.max)
+		if oldMax >= q.ceiling {
+			return
+		}
+		newMax := oldMax + n
+		trigger := "step=" + strconv.FormatInt(n, 10)
+		if newMax >= q.ceiling {
+			newMax = q.ceiling
+			trigger += ",ceiling"
+		}
+		if atomic.CompareAndSwapInt64(&q.max, oldMax, newMax) {
+			atomic.StoreInt32(&q.saturated, 0)
+			q.target.Set(float64(newMax))
+			q.wakeN(newMax - oldMax)
+			q.decide("inc", trigger, oldMax, newMax)
+			return
+		}
+	}
+}
+
+// Backoff sets target concurrency to a fraction p of its current size (0 <= p <= 1), e.g.,
+// back-off to 75% when a service is overloaded.
+// Target concurrency doesn't go below the floor, unless it was already set lower.
+func (q *Quota) Backoff(p float64) {
+	for {
+		oldMax := atomic.LoadInt64(&q.max)
+		newMax := math.Ceil(p * float64(oldMax))
+		trigger := "fraction=" + strconv.FormatFloat(p, 'g', -1, 64)
+		if floor := float64(q.floor); newMax < floor {
+			newMax = math.Min(floor, float64(oldMax))
+			trigger += ",floor"
+		}
+		if atomic.CompareAndSwapInt64(&q.max, oldMax, int64(newMax)) {
+			q.target.Set(newMax)
+			if oldMax > 0 {
+				q.backoffs.Observe(newMax / float64(oldMax))
+			}
+			q.decide("backoff", trigger, oldMax, int64(newMax))
+			if q.postBackoffHold > 0 {
+				q.HoldUntil(time.Now().Add(q.postBackoffHold))
+			}
+			break
+		}
+	}
+}
+
+// decide records a decision if quota has a decision log.
+func (q *Quota) decide(action, trigger string, oldMax, newMax int64) {
+	if q.decisions == nil {
+		return
+	}
+	q.decisions.Record(Decision{
+		Time:    time.Now(),
+		Action:  action,
+		Trigger: trigger,
+		OldMax:  oldMax,
+		NewMax:  newMax,
+	})
+}
@@ -0,0 +1,228 @@
+package capacity
+
+import (
+	"context"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Back-off lowers max below used, but strict quota doesn't admit anything
+// until used drops below the lowered max, and used returns to zero once all requests are released.
+func TestQuotaStrictBackoff(t *testing.T) {
+	q := NewQuota(10, WithStrict())
+	for i := 0; i < 10; i++ {
+		if !q.Receive() {
+			t.Fatalf("request %d wasn't admitted", i)
+		}
+	}
+	q.Backoff(0.5)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 50; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if q.Receive() {
+					t.Errorf("request was admitted with used %d and max %d", q.Used(), q.Max())
+					q.Release()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < 10; i++ {
+		q.Release()
+	}
+	if !q.Receive() {
+		t.Errorf("request wasn't admitted with used %d and max %d", q.Used(), q.Max())
+	}
+	q.Release()
+	if q.Used() != 0 {
+		t.Errorf("used %d, want 0", q.Used())
+	}
+}
+
+func TestQuotaDrain(t *testing.T) {
+	q := NewQuota(5)
+	for i := 0; i < 3; i++ {
+		q.Receive()
+	}
+	// A request waits for quota to become available when the drain starts.
+	q.Set(3)
+	waited := make(chan error)
+	go func() {
+		waited <- q.ReceiveWait(context.Background())
+	}()
+
+	q.Drain()
+	if !q.Draining() {
+		t.Fatal("quota isn't draining")
+	}
+	if err := <-waited; err != ErrDraining {
+		t.Errorf("waiting request got %v, want %v", err, ErrDraining)
+	}
+	for i := 0; i < 3; i++ {
+		if q.Receive() {
+			t.Fatal("draining quota admitted a request")
+		}
+		q.Release()
+	}
+	if q.Used() != 0 {
+		t.Errorf("used %d, want 0 once in-flight requests were released", q.Used())
+	}
+	if q.Receive() {
+		t.Error("drained quota admitted a request")
+	}
+}
+
+// ratios records observed back-off ratios.
+type ratios []float64
+
+func (r *ratios) Observe(v float64) {
+	*r = append(*r, v)
+}
+
+func TestQuotaBackoffObserver(t *testing.T) {
+	var got ratios
+	q := NewQuota(100, WithBackoffObserver(&got))
+	tests := []struct {
+		max  int64
+		p    float64
+		want float64
+	}{
+		{max: 100, p: 0.75, want: 0.75},
+		{max: 10, p: 0.5, want: 0.5},
+		// Target concurrency is rounded up, so small quotas back off less than asked.
+		{max: 3, p: 0.5, want: 2.0 / 3},
+		{max: 4, p: 0.1, want: 0.25},
+	}
+	for _, tc := range tests {
+		q.Set(tc.max)
+		q.Backoff(tc.p)
+	}
+
+	if len(got) != len(tests) {
+		t.Fatalf("observed %v, want %d ratios", got, len(tests))
+	}
+	for i, tc := range tests {
+		if math.Abs(got[i]-tc.want) > 1e-9 {
+			t.Errorf("back-off of %d by %v: ratio %v, want %v", tc.max, tc.p, got[i], tc.want)
+		}
+	}
+}
+
+// lastGauge remembers the most recent value it was set to.
+type lastGauge struct {
+	mu    sync.Mutex
+	value float64
+	sets  int
+}
+
+func (g *lastGauge) Set(v float64) {
+	g.mu.Lock()
+	g.value = v
+	g.sets++
+	g.mu.Unlock()
+}
+func (g *lastGauge) Inc() {}
+func (g *lastGauge) Dec() {}
+
+func TestQuotaBackoffFloor(t *testing.T) {
+	var target lastGauge
+	q := NewQuota(100, WithFloor(5), WithTargetGauge(&target))
+
+	var wg sync.WaitGroup
+	for g := 0; g < 10; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				q.Backoff(0.5)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if q.Max() != 5 {
+		t.Errorf("max %d, want the floor 5", q.Max())
+	}
+	if target.value != 5 {
+		t.Errorf("target gauge %v, want the floor 5", target.value)
+	}
+
+	// Quota set below the floor isn't raised by a back-off.
+	q.Set(2)
+	q.Backoff(0.5)
+	if q.Max() != 2 {
+		t.Errorf("max %d, want 2", q.Max())
+	}
+}
+
+func TestQuotaIncCeiling(t *testing.T) {
+	var target lastGauge
+	q := NewQuota(5, WithCeiling(10), WithTargetGauge(&target))
+
+	for i := 0; i < 20; i++ {
+		q.Inc()
+	}
+	if q.Max() != 10 {
+		t.Errorf("max %d, want the ceiling 10", q.Max())
+	}
+	// The gauge was set by NewQuota and by the 5 increments which fit below the ceiling.
+	if target.value != 10 || target.sets != 6 {
+		t.Errorf("target gauge %v set %d times, want 10 set 6 times", target.value, target.sets)
+	}
+
+	// A step larger than the headroom is cut at the ceiling.
+	q.Set(8)
+	q.IncN(5)
+	if q.Max() != 10 {
+		t.Errorf("max %d, want the ceiling 10", q.Max())
+	}
+}
+
+func TestQuotaPostBackoffHold(t *testing.T) {
+	q := NewQuota(10, WithPostBackoffHold(50*time.Millisecond))
+	q.Inc()
+	if q.Max() != 11 {
+		t.Fatalf("max %d, want 11 before a back-off", q.Max())
+	}
+
+	q.Backoff(0.5)
+	backedOff := q.Max()
+	for i := 0; i < 10; i++ {
+		q.Inc()
+	}
+	if q.Max() != backedOff {
+		t.Errorf("max %d was lifted from %d within the hold after a back-off", q.Max(), backedOff)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	q.Inc()
+	if q.Max() != backedOff+1 {
+		t.Errorf("max %d, want %d once the hold passed", q.Max(), backedOff+1)
+	}
+
+	// Without the option, quota grows right after a back-off.
+	q = NewQuota(10)
+	q.Backoff(0.5)
+	q.Inc()
+	if q.Max() != 6 {
+		t.Errorf("max %d, want 6", q.Max())
+	}
+}
+
+func TestQuotaHoldUntil(t *testing.T) {
+	q := NewQuota(10)
+	q.HoldUntil(time.Now().Add(time.Hour))
+	// An earlier hold doesn't shorten the current one.
+	q.HoldUntil(time.Now().Add(-time.Hour))
+	q.Inc()
+	if q.Max() != 10 {
+		t.Errorf("max %d was lifted while held", q.Max())
+	}
+}
@@ -0,0 +1,114 @@
+package capacity
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// ErrDraining is returned by ReceiveWait when quota no longer admits requests.
+var ErrDraining = errors.New("quota is draining")
+
+// ReceiveWait fills quota by one, waiting for it to become available if necessary.
+// It returns ctx.Err() if ctx is done before quota became available,
+// or ErrDraining if quota stopped admitting requests.
+func (q *Quota) ReceiveWait(ctx context.Context) error {
+	for {
+		if q.Draining() {
+			return ErrDraining
+		}
+		if q.Receive() {
+			return nil
+		}
+
+		// The waiter is registered before quota is checked again,
+		// so a concurrent Release either sees the waiter or frees up quota for the check.
+		w, ready := q.addWaiter()
+		if q.Receive() {
+			q.removeWaiter(w)
+			return nil
+		}
+
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			q.removeWaiter(w)
+			// The wake-up could have been sent after the context was done,
+			// so it's passed to the next waiter not to be lost.
+			select {
+			case <-ready:
+				q.wakeOne()
+			default:
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+// addWaiter registers a waiter which is woken up via ready channel when quota might have become available.
+func (q *Quota) addWaiter() (w *list.Element, ready chan struct{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	ready = make(chan struct{}, 1)
+	atomic.AddInt32(&q.waiting, 1)
+	return q.waiters.PushBack(ready), ready
+}
+
+// removeWaiter unregisters a waiter unless it was already woken up.
+func (q *Quota) removeWaiter(w *list.Element) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// A woken up waiter was already removed from the list.
+	if w.Value == nil {
+		return
+	}
+	q.waiters.Remove(w)
+	w.Value = nil
+	atomic.AddInt32(&q.waiting, -1)
+}
+
+// wakeOne wakes up the longest waiting waiter.
+func (q *Quota) wakeOne() {
+	if atomic.LoadInt32(&q.waiting) == 0 {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	w := q.waiters.Front()
+	if w == nil {
+		return
+	}
+	ready := q.waiters.Remove(w).(chan struct{})
+	w.Value = nil
+	atomic.AddInt32(&q.waiting, -1)
+	ready <- struct{}{}
+}
+
+// wakeN wakes up at most n waiters, e.g., when quota was lifted by n.
+func (q *Quota) wakeN(n int64) {
+	for i := int64(0); i < n; i++ {
+		q.wakeOne()
+	}
+}
+
+// wakeAll wakes up all the waiters, e.g., when target concurrency changed.
+func (q *Quota) wakeAll() {
+	if atomic.LoadInt32(&q.waiting) == 0 {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for w := q.waiters.Front(); w != nil; w = q.waiters.Front() {
+		ready := q.waiters.Remove(w).(chan struct{})
+		w.Value = nil
+		atomic.AddInt32(&q.waiting, -1)
+		ready <- struct{}{}
+	}
+}
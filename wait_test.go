@@ -0,0 +1,89 @@
+package capacity
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// waitingFor waits until n callers wait for quota q.
+func waitingFor(t *testing.T, q *Quota, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		q.mu.Lock()
+		l := q.waiters.Len()
+		q.mu.Unlock()
+		if l == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d callers wait, want %d", l, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReceiveWaitCancel(t *testing.T) {
+	q := NewQuota(1)
+	q.Receive()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	waited := make(chan error)
+	go func() {
+		waited <- q.ReceiveWait(ctx)
+	}()
+	waitingFor(t, q, 1)
+	cancel()
+	if err := <-waited; err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := q.ReceiveWait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	waitingFor(t, q, 0)
+	if q.Used() != 1 {
+		t.Errorf("used %d, want 1", q.Used())
+	}
+}
+
+func TestReceiveWaitRelease(t *testing.T) {
+	q := NewQuota(1)
+	q.Receive()
+
+	waited := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			waited <- q.ReceiveWait(context.Background())
+		}()
+	}
+	waitingFor(t, q, 2)
+
+	// A release wakes a single waiter, the other one keeps waiting.
+	q.Release()
+	if err := <-waited; err != nil {
+		t.Fatal(err)
+	}
+	waitingFor(t, q, 1)
+	select {
+	case err := <-waited:
+		t.Fatalf("second waiter got %v without a release", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if q.Used() != 1 {
+		t.Errorf("used %d, want 1", q.Used())
+	}
+
+	// Lifted quota admits the other waiter.
+	q.Inc()
+	if err := <-waited; err != nil {
+		t.Fatal(err)
+	}
+	if q.Used() != 2 {
+		t.Errorf("used %d, want 2", q.Used())
+	}
+}